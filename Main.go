@@ -2,53 +2,109 @@ package main
 
 import (
 	"MiniBlockChain/bid"
-	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// How long a node waits for the requests in progress when it is stopped
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	// Command line: [-data-dir directory] [-memory] [-genesis file] [-test-mode] [peer options] [-allow-private-webhooks] port
+	var dataDir *string = flag.String("data-dir", "", "directory where the node state is stored (default data/<port>)")
+	var inMemory *bool = flag.Bool("memory", false, "keep the node state in memory only (lost on restart)")
+
+	// The genesis file sets the chain id, the genesis block and the difficulty rules, which must be the
+	// same on all nodes. Test mode replaces the difficulty rules, so test nodes only join test nodes
+	var genesisFile *string = flag.String("genesis", "", "genesis configuration file (default: chain \""+bid.DefaultChainId+"\")")
+	var testMode *bool = flag.Bool("test-mode", false, "mine with a fixed, very low difficulty (test difficulty rules)")
+
+	// Known nodes are probed regularly, and removed after too many failed probes in a row
+	var peers bid.PeerConfig = bid.DefaultPeerConfig()
+	flag.DurationVar(&peers.ProbeInterval, "probe-interval", peers.ProbeInterval,
+		"time between two health probes of the known nodes (0 disables probes)")
+	flag.IntVar(&peers.MaxFailures, "max-peer-failures", peers.MaxFailures,
+		"consecutive failed probes after which a node is removed (0 never removes nodes)")
+	flag.StringVar(&peers.PublicUrl, "public-url", "",
+		"url other nodes reach this node at (default http://localhost:<port>)")
+	var seeds *string = flag.String("seeds", "", "comma separated urls of the nodes to join when starting")
+
+	// Webhooks only reach public addresses, unless their receivers run on this machine or network
+	var allowPrivateWebhooks *bool = flag.Bool("allow-private-webhooks", false,
+		"let webhooks target loopback, link-local and private addresses")
+	flag.Parse()
+
 	// Port to listen to
-	if len(os.Args) == 1 {
+	if flag.NArg() == 0 {
 		log.Fatal("missing port number!")
 	}
+	var config bid.Config = bid.DefaultConfig(flag.Arg(0))
+	if *seeds != "" {
+		peers.Seeds = strings.Split(*seeds, ",")
+	}
+	config.Peers = peers
+	config.AllowPrivateWebhooks = *allowPrivateWebhooks
+	if *genesisFile != "" {
+		var err error
+		if config.Genesis, err = bid.LoadGenesisConfig(*genesisFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *testMode {
+		config.Genesis.Difficulty = bid.TestDifficultyConfig()
+	}
 
-	port := os.Args[1]
-
-	/* The 'handlers' package from guerilla is a collection of handlers (aka "HTTP middleware")
-	for use with Go's net/http package. This package includes handlers for logging in standardised
-	formats, compressing HTTP responses, validating content types and other useful tools for
-	manipulating requests and responses.
-
-	handlers.AllowedOrigins sets the allowed origins for CORS requests, as used in the
-	Allow-Access-Control-Origin' HTTP header. Passing in a "*" will allow any domain.
-	handles.AllowedMethods explicitly allows methods in the Access-Control-Allow-Methods header.
-
-	Note the following definitions:
-	type CORSOption func(*cors) error		// CORSOption is a function type
-	type cors struct {
-		h                      http.Handler
-		allowedHeaders         []string
-		allowedMethods         []string
-		allowedOrigins         []string
-		allowedOriginValidator OriginValidator
-		exposedHeaders         []string
-		maxAge                 int
-		ignoreOptions          bool
-		allowCredentials       bool
-		optionStatusCode       int
-	} */
-	var allowedOrigins handlers.CORSOption = handlers.AllowedOrigins([]string{"*"})
-	var allowedMethods handlers.CORSOption = handlers.AllowedMethods([]string{"GET","POST"})
-
-	// Initialize headers: accept calls from any origin and work with GET and POST requests
-	var funcHandler func(http.Handler) http.Handler = handlers.CORS(allowedMethods, allowedOrigins)
-
-	// Listen to port defined in port
-	var router *mux.Router = bid.NewRouter(port)		// mux.Router implements Handler interface
-	var handler http.Handler = funcHandler(router);
-	http.ListenAndServe(":"+port, handler)
+	// Where the chain, pending bids and known nodes are stored. By default each node (port)
+	// gets its own directory so that several nodes can run from the same folder
+	if *dataDir == "" {
+		*dataDir = filepath.Join("data", config.Port)
+	}
+	if err := run(config, *dataDir, *inMemory); err != nil {
+		log.Fatal(err)
+	}
 }
 
+// run opens the storage of the node, starts the node and serves until interrupted. The storage is
+// closed whatever happens, so run returns errors rather than exiting
+func run(config bid.Config, dataDir string, inMemory bool) error {
+	if inMemory {
+		config.Storage = bid.NewMemoryStorage()
+	} else {
+		fileStorage, err := bid.NewFileStorage(dataDir)
+		if err != nil {
+			return err
+		}
+		defer fileStorage.Close()
+		config.Storage = fileStorage
+	}
+
+	// The stored chain is reloaded and validated before we start serving
+	node, err := bid.NewNode(config)
+	if err != nil {
+		return fmt.Errorf("failed to start node: %w", err)
+	}
+	if err = node.Start(); err != nil {
+		return fmt.Errorf("failed to start node: %w", err)
+	}
+	log.Printf("Node %s listening on port %s", node.Url(), config.Port)
+
+	// Run until interrupted, then let the requests in progress finish
+	var interrupted chan os.Signal = make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	<-interrupted
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = node.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down cleanly: %s", err)
+	}
+	return nil
+}
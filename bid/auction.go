@@ -0,0 +1,368 @@
+/* Auctions live on the chain. Besides bids, a block carries auction records, signed by the seller:
+1. A "create" record opens an auction: it gives the auction id (which must not be used yet), the
+   seller's public key, a description of the item, the mode (open or sealed, see sealed.go), the
+   reserve price and the open and close times (and the end of the reveal window of sealed auctions)
+2. A "close" record settles an auction: the auction's settlement rule (see settlement.go) picks the
+   winner and the price it pays. If no bid reaches the reserve price, there is no winner
+
+A bid is only valid in a block whose timestamp is between the open time (included) and the close time
+(excluded) of an existing auction that is not closed yet (reveals of sealed auctions come after the
+close time, see sealed.go), and a close record is only valid in a block whose timestamp is at or after
+the close time (the reveal time of sealed auctions). Times are Unix times in nanoseconds, like block
+timestamps. Within a block, auction creations are applied first, then bids, then auction closes, so a
+single block may create an auction and take bids for it (closing it takes a later block, whose
+timestamp is past the close time).
+
+Auction records need no sequence number: an auction can only be created once and closed once, so
+replaying a record is always rejected.
+
+The ledger is the state that validation needs after a given block: the auctions and the last sequence
+number of each bidder. Every node replays the same chain into the same ledger, so all nodes agree on
+which bids are valid and who won each auction */
+package bid
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Types of auction records
+const (
+	AuctionRecordCreate = "create"
+	AuctionRecordClose  = "close"
+)
+
+// Values of AuctionState.Status
+const (
+	AuctionScheduled = "scheduled" // created, but the open time is not reached yet
+	AuctionOpen      = "open"      // taking bids (commitments in sealed auctions)
+	AuctionRevealing = "revealing" // sealed auctions only: taking reveals
+	AuctionEnded     = "ended"     // bidding (and revealing) over, waiting for the seller's close record
+	AuctionClosed    = "closed"    // settled by a close record
+)
+
+// SignAuctionRecord fills in the seller and signature of an auction record with the given private
+// key. Any other field must be set before signing
+func SignAuctionRecord(record *AuctionRecord, privateKey ed25519.PrivateKey) {
+	record.Seller = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+	record.Signature = hex.EncodeToString(ed25519.Sign(privateKey, record.signingBytes()))
+}
+
+// Hash returns the hash of an auction record: the SHA-256 of its canonical encoding, as a hex string
+func (record AuctionRecord) Hash() string {
+	return hex.EncodeToString(record.digest())
+}
+
+// digest returns the SHA-256 of the canonical encoding of an auction record
+func (record AuctionRecord) digest() []byte {
+	var digest [sha256.Size]byte = sha256.Sum256(record.canonicalBytes())
+	return digest[:]
+}
+
+// canonicalBytes returns the canonical encoding of an auction record, signature included
+func (record AuctionRecord) canonicalBytes() []byte {
+	var buffer *bytes.Buffer = bytes.NewBuffer(record.signingBytes())
+	writeCanonicalString(buffer, record.Signature)
+	return buffer.Bytes()
+}
+
+// signingBytes returns the canonical encoding of an auction record without its signature, in the
+// same format as bids (see encoding.go): type, auction id, seller, item, mode, rule, currency, reserve price,
+// reserve commitment, reserve salt, open time, close time and reveal time
+func (record AuctionRecord) signingBytes() []byte {
+	var buffer bytes.Buffer
+	writeCanonicalString(&buffer, record.Type)
+	writeCanonicalInt(&buffer, int64(record.AuctionId))
+	writeCanonicalString(&buffer, record.Seller)
+	writeCanonicalString(&buffer, record.Item)
+	writeCanonicalString(&buffer, record.Mode)
+	writeCanonicalString(&buffer, record.Rule)
+	writeCanonicalString(&buffer, record.Currency)
+	writeCanonicalMoney(&buffer, record.ReservePrice)
+	writeCanonicalString(&buffer, record.ReserveCommitment)
+	writeCanonicalString(&buffer, record.ReserveSalt)
+	writeCanonicalInt(&buffer, record.OpenTime)
+	writeCanonicalInt(&buffer, record.CloseTime)
+	writeCanonicalInt(&buffer, record.RevealTime)
+	return buffer.Bytes()
+}
+
+// checkAuctionRecord returns the reason why an auction record is not well formed or not properly
+// signed by its seller, or "" if it is fine. Whether the record fits the chain is checked by the ledger
+func checkAuctionRecord(record AuctionRecord) string {
+	if record.ReservePrice.Units < 0 {
+		return "reserve_price must not be negative"
+	}
+
+	switch record.Type {
+	case AuctionRecordCreate:
+		if record.Item == "" {
+			return "item is missing"
+		}
+		if reason := checkSettlementRule(record); reason != "" {
+			return reason
+		}
+		if record.Currency != "" && !isCurrencyCode(record.Currency) {
+			return "currency must be 3 upper case letters (ISO 4217 code)"
+		}
+		if record.ReservePrice != (Money{}) && record.ReservePrice.Currency != record.Currency {
+			return fmt.Sprintf("reserve_price must be in the currency of the auction (%q)", record.Currency)
+		}
+		if record.ReserveSalt != "" {
+			return "reserve_salt is only revealed by the close record"
+		}
+		if record.ReserveCommitment != "" {
+			if record.ReservePrice != (Money{}) {
+				return "a hidden reserve price must not be given in reserve_price"
+			}
+			if decoded, err := hex.DecodeString(record.ReserveCommitment); err != nil || len(decoded) != sha256.Size ||
+				hex.EncodeToString(decoded) != record.ReserveCommitment {
+				return fmt.Sprintf("reserve_commitment must be %d bytes written as lowercase hex", sha256.Size)
+			}
+		}
+		if record.CloseTime <= record.OpenTime {
+			return "close_time must be after open_time"
+		}
+		switch record.Mode {
+		case "", AuctionModeOpen:
+			if record.RevealTime != 0 {
+				return "reveal_time is only used by sealed auctions"
+			}
+		case AuctionModeSealed:
+			if record.RevealTime <= record.CloseTime {
+				return "reveal_time must be after close_time"
+			}
+		default:
+			return fmt.Sprintf("mode must be %q or %q", AuctionModeOpen, AuctionModeSealed)
+		}
+	case AuctionRecordClose:
+		if record.Item != "" || record.Mode != "" || record.Rule != "" || record.Currency != "" || record.ReserveCommitment != "" ||
+			record.OpenTime != 0 || record.CloseTime != 0 || record.RevealTime != 0 {
+			return "a close record only carries the auction id, the seller, the signature and the hidden reserve price"
+		}
+	default:
+		return fmt.Sprintf("type must be %q or %q", AuctionRecordCreate, AuctionRecordClose)
+	}
+
+	// The seller signs the record like a bidder signs a bid
+	return checkSignature(record.Seller, record.Signature, record.signingBytes())
+}
+
+// ledger is the state of the auctions and the last sequence number of each bidder after a block
+type ledger struct {
+	auctions     map[int]*AuctionState
+	lastSequence map[string]uint64
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		auctions:     map[int]*AuctionState{},
+		lastSequence: map[string]uint64{},
+	}
+}
+
+// rebuildLedger replays a valid chain into a new ledger. The genesis block only holds auctions
+func rebuildLedger(chain Blocks) *ledger {
+	var state *ledger = newLedger()
+	for _, block := range chain {
+		state.applyBlock(block)
+	}
+	return state
+}
+
+// clone returns a copy of the ledger that can be changed without changing the original. Used to
+// check a block (or build a mining candidate) before it is added to the chain
+func (l *ledger) clone() *ledger {
+	var copied *ledger = newLedger()
+	for auctionId, auction := range l.auctions {
+		var auctionCopy AuctionState = *auction
+		// Limit the capacity so that appending to either copy's bids cannot overwrite the other's
+		auctionCopy.bids = auction.bids[:len(auction.bids):len(auction.bids)]
+		if auction.commitments != nil {
+			auctionCopy.commitments = make(map[string]sealedCommitment, len(auction.commitments))
+			for hash, commitment := range auction.commitments {
+				auctionCopy.commitments[hash] = commitment
+			}
+		}
+		copied.auctions[auctionId] = &auctionCopy
+	}
+	for publicKey, sequence := range l.lastSequence {
+		copied.lastSequence[publicKey] = sequence
+	}
+	return copied
+}
+
+// applyBlock applies the auction records and bids of a block to the ledger. Returns the reason why
+// the block does not fit the ledger, or "" if it does. When a reason is returned the ledger is left
+// partly updated, so callers check blocks on a clone
+func (l *ledger) applyBlock(block Block) string {
+	for position, record := range block.Auctions {
+		if record.Type == AuctionRecordCreate {
+			if reason := l.applyCreate(record, block); reason != "" {
+				return fmt.Sprintf("auction record %d: %s", position, reason)
+			}
+		}
+	}
+	for position, bid := range block.Bids {
+		if reason := l.applyBid(bid, block.Timestamp); reason != "" {
+			return fmt.Sprintf("bid %d: %s", position, reason)
+		}
+	}
+	for position, record := range block.Auctions {
+		if record.Type != AuctionRecordCreate {
+			if reason := l.applyClose(record, block); reason != "" {
+				return fmt.Sprintf("auction record %d: %s", position, reason)
+			}
+		}
+	}
+	return ""
+}
+
+// applyCreate adds the auction created by a record in the given block. The ledger is only changed
+// when "" is returned
+func (l *ledger) applyCreate(record AuctionRecord, block Block) string {
+	if reason := checkAuctionRecord(record); reason != "" {
+		return reason
+	}
+	if record.Type != AuctionRecordCreate {
+		return fmt.Sprintf("record has type %q, expected %q", record.Type, AuctionRecordCreate)
+	}
+	if _, exists := l.auctions[record.AuctionId]; exists {
+		return fmt.Sprintf("auction %d already exists", record.AuctionId)
+	}
+
+	var auction *AuctionState = &AuctionState{
+		Auction:        record,
+		CreatedInBlock: block.Index,
+	}
+	if record.Mode == AuctionModeSealed {
+		auction.commitments = map[string]sealedCommitment{}
+	}
+	l.auctions[record.AuctionId] = auction
+	return ""
+}
+
+// applyBid records a bid made in a block with the given timestamp. The ledger is only changed when
+// "" is returned
+func (l *ledger) applyBid(bid Bid, timestamp int64) string {
+	if reason := checkBidSignature(bid); reason != "" {
+		return reason
+	}
+	if reason := checkSealedBidFields(bid); reason != "" {
+		return reason
+	}
+	if last := l.lastSequence[bid.PublicKey]; bid.Sequence <= last {
+		return fmt.Sprintf("sequence %d is not higher than last sequence %d of the bidder (replayed bid?)",
+			bid.Sequence, last)
+	}
+
+	auction, exists := l.auctions[bid.AuctionId]
+	if !exists {
+		return fmt.Sprintf("auction %d does not exist", bid.AuctionId)
+	}
+	if reason := auction.Auction.checkBidCurrency(bid); reason != "" {
+		return reason
+	}
+	if auction.Auction.Mode == AuctionModeSealed {
+		if reason := auction.applySealedBid(bid, timestamp); reason != "" {
+			return reason
+		}
+		l.lastSequence[bid.PublicKey] = bid.Sequence
+		return ""
+	}
+
+	if bid.Kind != "" {
+		return fmt.Sprintf("auction %d is not sealed: %q bids are not allowed", bid.AuctionId, bid.Kind)
+	}
+	if status := auction.statusAt(timestamp); status != AuctionOpen {
+		return fmt.Sprintf("auction %d is not open (%s) at block time %d", bid.AuctionId, status, timestamp)
+	}
+	l.lastSequence[bid.PublicKey] = bid.Sequence
+	auction.recordValue(bid)
+	return ""
+}
+
+// checkBidCurrency returns the reason why the value of a bid is not in the currency of the auction,
+// or "" if it is (or if the bid carries no value)
+func (record AuctionRecord) checkBidCurrency(bid Bid) string {
+	if bid.Kind == BidKindCommit || bid.BidValue.Currency == record.Currency {
+		return ""
+	}
+	if record.Currency == "" {
+		return fmt.Sprintf("auction %d has no currency: bid_value must not carry one", bid.AuctionId)
+	}
+	return fmt.Sprintf("bid_value must be in the currency of auction %d (%s)", bid.AuctionId, record.Currency)
+}
+
+// recordValue takes a bid with a value into account for winner determination: an open bid, or a
+// reveal of a sealed auction. On equal values the earliest bid keeps the lead
+func (auction *AuctionState) recordValue(bid Bid) {
+	auction.bids = append(auction.bids, bid)
+	auction.BidCount++
+	if auction.LeadingBid == nil || bid.BidValue.Cmp(auction.LeadingBid.BidValue) > 0 {
+		var leadingBid Bid = bid
+		auction.LeadingBid = &leadingBid
+	}
+}
+
+// applyClose closes the auction named by a close record in the given block and settles its winner.
+// The ledger is only changed when "" is returned
+func (l *ledger) applyClose(record AuctionRecord, block Block) string {
+	if reason := checkAuctionRecord(record); reason != "" {
+		return reason
+	}
+	if record.Type != AuctionRecordClose {
+		return fmt.Sprintf("record has type %q, expected %q", record.Type, AuctionRecordClose)
+	}
+	auction, exists := l.auctions[record.AuctionId]
+	if !exists {
+		return fmt.Sprintf("auction %d does not exist", record.AuctionId)
+	}
+	if record.Seller != auction.Auction.Seller {
+		return fmt.Sprintf("auction %d can only be closed by its seller", record.AuctionId)
+	}
+	if status := auction.statusAt(block.Timestamp); status != AuctionEnded {
+		return fmt.Sprintf("auction %d cannot be closed (%s) at block time %d", record.AuctionId, status, block.Timestamp)
+	}
+	reservePrice, reason := revealedReservePrice(auction.Auction, record)
+	if reason != "" {
+		return reason
+	}
+
+	var rule string = settlementRuleOf(auction.Auction)
+	var settlement Settlement = settlementRules[rule].Settle(auction.bids, reservePrice)
+	settlement.AuctionId = record.AuctionId
+	settlement.Rule = rule
+	settlement.ClosedInBlock = block.Index
+	auction.ClosedInBlock = block.Index
+	auction.Settlement = &settlement
+	auction.WinningBid = settlement.WinningBid
+	return ""
+}
+
+// statusAt returns the status of an auction at the given time
+func (auction AuctionState) statusAt(timestamp int64) string {
+	switch {
+	case auction.ClosedInBlock != 0:
+		return AuctionClosed
+	case timestamp < auction.Auction.OpenTime:
+		return AuctionScheduled
+	case timestamp < auction.Auction.CloseTime:
+		return AuctionOpen
+	case auction.Auction.Mode == AuctionModeSealed && timestamp < auction.Auction.RevealTime:
+		return AuctionRevealing
+	default:
+		return AuctionEnded
+	}
+}
+
+// biddingDeadline returns the time until which an auction takes the given kind of bid
+func (record AuctionRecord) biddingDeadline(kind string) int64 {
+	if kind == BidKindReveal {
+		return record.RevealTime
+	}
+	return record.CloseTime
+}
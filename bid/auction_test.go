@@ -0,0 +1,162 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A record that is not valid JSON gets the same JSON error response as a record that is not valid
+func TestRegisterAuctionRecordInvalidJson(t *testing.T) {
+	var c *Controller = &Controller{blockChain: newTestChain(t), currentNodeUrl: "http://localhost:9100"}
+	var handlers = []struct {
+		api     string
+		handler http.HandlerFunc
+	}{
+		{"/auction", c.RegisterAuctionRecord},
+		{"/auction/broadcast", c.RegisterAndBroadcastAuctionRecord},
+	}
+	for _, test := range handlers {
+		var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+		test.handler(recorder, httptest.NewRequest("POST", test.api, strings.NewReader(`{"auction_id": "one"}`)))
+		var response ApiResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusUnprocessableEntity ||
+			!strings.HasPrefix(response.Status, "Auction record is not valid") {
+			t.Errorf("POST %s: got %d %q", test.api, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+// testClose returns a close record signed by seller
+func testClose(seller ed25519.PrivateKey, auctionId int) AuctionRecord {
+	var record AuctionRecord = AuctionRecord{Type: AuctionRecordClose, AuctionId: auctionId}
+	SignAuctionRecord(&record, seller)
+	return record
+}
+
+// testBlock returns a block with the given index and timestamp, as needed by the ledger
+func testBlock(index int, timestamp int64) Block {
+	return Block{Index: index, Timestamp: timestamp}
+}
+
+func TestApplyCreate(t *testing.T) {
+	var seller ed25519.PrivateKey = newTestKey()
+	var tampered AuctionRecord = testAuction(seller, 1, nil)
+	tampered.Item = "another item"
+	var tests = []struct {
+		name   string
+		record AuctionRecord
+		reason string
+	}{
+		{"valid", testAuction(seller, 1, nil), ""},
+		{"same auction id", testAuction(seller, 1, nil), "auction 1 already exists"},
+		{"tampered", tampered, "signature does not match"},
+		{"no item", testAuction(seller, 2, func(record *AuctionRecord) { record.Item = "" }), "item is missing"},
+		{"close before open", testAuction(seller, 2, func(record *AuctionRecord) { record.CloseTime = record.OpenTime }),
+			"close_time must be after open_time"},
+		{"unknown mode", testAuction(seller, 2, func(record *AuctionRecord) { record.Mode = "dutch" }), "mode must be"},
+		{"reveal time of an open auction", testAuction(seller, 2, func(record *AuctionRecord) { record.RevealTime = auctionReveal }),
+			"reveal_time is only used by sealed auctions"},
+		{"reserve in another currency", testAuction(seller, 2, func(record *AuctionRecord) {
+			record.Currency, record.ReservePrice = "EUR", MustParseMoney("10.00 USD")
+		}), "reserve_price must be in the currency of the auction"},
+		{"negative reserve", testAuction(seller, 2, func(record *AuctionRecord) { record.ReservePrice = MustParseMoney("-1.00") }),
+			"reserve_price must not be negative"},
+		{"close record", testClose(seller, 2), "expected \"create\""},
+		{"unknown type", testAuction(seller, 2, func(record *AuctionRecord) { record.Type = "cancel" }), "type must be"},
+	}
+	var state *ledger = newLedger()
+	for _, test := range tests {
+		expectReason(t, test.name, state.applyCreate(test.record, testBlock(2, auctionOpen)), test.reason)
+	}
+	if len(state.auctions) != 1 || state.auctions[1].CreatedInBlock != 2 {
+		t.Fatalf("got auctions %+v", state.auctions)
+	}
+}
+
+func TestApplyBidInOpenAuction(t *testing.T) {
+	var seller, bidder, other ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey()
+	var state *ledger = newLedger()
+	expectReason(t, "create", state.applyCreate(testAuction(seller, 1, nil), testBlock(2, auctionOpen)), "")
+	expectReason(t, "create in euros", state.applyCreate(testAuction(seller, 2, func(record *AuctionRecord) {
+		record.Currency = "EUR"
+	}), testBlock(2, auctionOpen)), "")
+
+	var commit Bid = Bid{ChainId: DefaultChainId, AuctionId: 1, Kind: BidKindCommit, Commitment: strings.Repeat("ab", 32), Sequence: 9}
+	SignBid(&commit, other)
+	var tests = []struct {
+		name      string
+		bid       Bid
+		timestamp int64
+		reason    string
+	}{
+		{"before open time", testBid(bidder, 1, "10.00", 1), auctionOpen - 1, "is not open (scheduled)"},
+		{"at open time", testBid(bidder, 1, "10.00", 1), auctionOpen, ""},
+		{"lower value", testBid(other, 1, "5.00", 1), auctionOpen + 1, ""},
+		{"at close time", testBid(bidder, 1, "20.00", 2), auctionClose, "is not open (ended)"},
+		{"unknown auction", testBid(bidder, 3, "20.00", 2), auctionOpen, "auction 3 does not exist"},
+		{"currency of an auction without one", testBid(bidder, 1, "20.00 EUR", 2), auctionOpen, "has no currency"},
+		{"other currency", testBid(bidder, 2, "20.00 USD", 2), auctionOpen, "must be in the currency of auction 2 (EUR)"},
+		{"commit in an open auction", commit, auctionOpen, "is not sealed"},
+		{"no value", testBid(bidder, 1, "0.00", 2), auctionOpen, "bid_value must be positive"},
+		{"in the auction currency", testBid(bidder, 2, "20.00 EUR", 2), auctionOpen, ""},
+	}
+	for _, test := range tests {
+		expectReason(t, test.name, state.applyBid(test.bid, test.timestamp), test.reason)
+	}
+	var auction *AuctionState = state.auctions[1]
+	if auction.BidCount != 2 || auction.LeadingBid == nil || auction.LeadingBid.BidValue != MustParseMoney("10.00") {
+		t.Fatalf("got auction %+v", auction)
+	}
+}
+
+func TestApplyClose(t *testing.T) {
+	var seller, bidder ed25519.PrivateKey = newTestKey(), newTestKey()
+	var state *ledger = newLedger()
+	expectReason(t, "create", state.applyCreate(testAuction(seller, 1, nil), testBlock(2, auctionOpen)), "")
+	expectReason(t, "bid", state.applyBid(testBid(bidder, 1, "10.00", 1), auctionOpen), "")
+
+	var tests = []struct {
+		name   string
+		record AuctionRecord
+		block  Block
+		reason string
+	}{
+		{"before close time", testClose(seller, 1), testBlock(3, auctionClose-1), "cannot be closed (open)"},
+		{"by someone else", testClose(bidder, 1), testBlock(3, auctionClose), "can only be closed by its seller"},
+		{"unknown auction", testClose(seller, 2), testBlock(3, auctionClose), "auction 2 does not exist"},
+		{"create record", testAuction(seller, 1, nil), testBlock(3, auctionClose), "expected \"close\""},
+		{"at close time", testClose(seller, 1), testBlock(3, auctionClose), ""},
+		{"closed twice", testClose(seller, 1), testBlock(4, auctionClose+1), "cannot be closed (closed)"},
+	}
+	for _, test := range tests {
+		expectReason(t, test.name, state.applyClose(test.record, test.block), test.reason)
+	}
+	var auction *AuctionState = state.auctions[1]
+	if auction.ClosedInBlock != 3 || auction.WinningBid == nil || auction.WinningBid.BidValue != MustParseMoney("10.00") {
+		t.Fatalf("got auction %+v", auction)
+	}
+	expectReason(t, "bid after close", state.applyBid(testBid(bidder, 1, "20.00", 2), auctionOpen+1), "is not open (closed)")
+}
+
+// Within a block, creations come first and closes last, whatever the order of the records
+func TestApplyBlockOrder(t *testing.T) {
+	var seller, bidder ed25519.PrivateKey = newTestKey(), newTestKey()
+	var state *ledger = newLedger()
+	var block Block = testBlock(2, auctionOpen)
+	block.Auctions = AuctionRecords{testClose(seller, 1), testAuction(seller, 2, nil)}
+	block.Bids = Bids{testBid(bidder, 2, "10.00", 1)}
+	expectReason(t, "close of an auction that does not exist", state.clone().applyBlock(block), "auction record 0: auction 1 does not exist")
+
+	block.Auctions = AuctionRecords{testAuction(seller, 2, nil)}
+	expectReason(t, "create and bid", state.applyBlock(block), "")
+	block = testBlock(3, auctionClose)
+	block.Auctions = AuctionRecords{testClose(seller, 2)}
+	expectReason(t, "close", state.applyBlock(block), "")
+	if state.auctions[2].Settlement == nil || state.auctions[2].Settlement.ClosedInBlock != 3 {
+		t.Fatalf("got auction %+v", state.auctions[2])
+	}
+}
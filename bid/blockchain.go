@@ -1,110 +1,799 @@
 package bid
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
-	"strconv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync/atomic"
 	"time"
 )
 
-// RegisterBid registers a bid in the blockchain
-func (b *BlockChain) RegisterBid(bid Bid) {
-	b.PendingBids = append(b.PendingBids, bid)
+const (
+	// Maximum time a received block's timestamp may be ahead of our clock
+	maxBlockTimeDrift = 2 * time.Minute
+
+	// Number of hashes ProofOfWork computes between checks for cancellation
+	proofOfWorkBatchSize = 4096
+)
+
+// Errors returned when a block cannot be added to the chain
+var (
+	// ErrStaleCandidate is returned when the chain changed while a block was being mined
+	ErrStaleCandidate = errors.New("chain changed while the block was being mined")
+	// ErrBlockRejected is returned when a received block (or the branch it ends) is not valid
+	ErrBlockRejected = errors.New("block rejected")
+	// ErrInvalidBid is returned when a bid is not properly signed, replays an earlier bid or does
+	// not target an open auction
+	ErrInvalidBid = errors.New("invalid bid")
+	// ErrInvalidAuctionRecord is returned when an auction record is not properly signed or does not
+	// fit the auctions in the chain
+	ErrInvalidAuctionRecord = errors.New("invalid auction record")
+	// ErrDuplicateBid is returned when a bid is already pending
+	ErrDuplicateBid = errors.New("bid is already pending")
+	// ErrMempoolFull is returned when the mempool cannot take more bids (see mempool.go)
+	ErrMempoolFull = errors.New("too many pending bids")
+)
+
+/* Concurrency: net/http serves each request on its own goroutine, so every method below may be
+called concurrently. Chain, the pending bids and auction records, the bid indexes and the ledger
+are guarded by mutex; NetworkNodes is guarded by nodesMutex so that talking to other nodes never
+waits on the chain. Readers take a read lock and get a consistent view; the only slow operation, proof of work, runs without any lock (see
+PrepareMiningCandidate and CreateNewBlock) so that mining does not block bid intake */
+
+// NewBlockChain creates a blockchain whose state is kept in the given storage. If the storage
+// already holds a chain (i.e., the node is restarting), the chain, pending bids and auction records
+// and known nodes are reloaded, and the chain is validated before it is used: it must start with the
+// genesis block of the given configuration. Otherwise the genesis block is created. Blocks follow the
+// difficulty rules of the genesis configuration
+func NewBlockChain(store Storage, genesis GenesisConfig) (*BlockChain, error) {
+	genesisBlock, err := genesis.Block()
+	if err != nil {
+		return nil, fmt.Errorf("invalid genesis configuration: %w", err)
+	}
+
+	var b *BlockChain = &BlockChain{
+		Chain:           Blocks{},
+		mempool:         newMempool(),
+		PendingAuctions: AuctionRecords{},
+		NetworkNodes:    map[string]*PeerRecord{},
+		bidIndex:        newBidIndex(),
+		ledger:          newLedger(),
+		difficulty:      genesis.Difficulty,
+		genesis:         genesisBlock,
+		store:           store,
+	}
+
+	chain, err := store.LoadChain()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chain: %w", err)
+	}
+
+	// Nothing stored yet: this is a new node, so start with the genesis block
+	if len(chain) == 0 {
+		if err = store.AppendBlock(genesisBlock); err != nil {
+			return nil, fmt.Errorf("failed to store genesis block: %w", err)
+		}
+		b.Chain = append(b.Chain, genesisBlock)
+		b.ledger = rebuildLedger(b.Chain)
+		b.tree = newBlockTree(b.Chain)
+		return b, nil
+	}
+
+	// Never serve a chain that is not valid, even our own
+	b.Chain = chain
+	var report ChainValidationReport = b.ChainIsValid()
+	if !report.Valid {
+		return nil, fmt.Errorf("stored chain is not valid at block %d (%s): %s",
+			report.BlockIndex, report.BlockHash, report.Reason)
+	}
+	b.bidIndex = rebuildBidIndex(chain)
+	b.ledger = rebuildLedger(chain)
+	b.tree = newBlockTree(chain)
+
+	pendingBids, err := store.LoadPendingBids()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending bids: %w", err)
+	}
+	// Arrival times are not stored: reloaded bids get a new lease
+	for _, bid := range pendingBids {
+		b.mempool.add(bid, time.Now())
+	}
+	if b.PendingAuctions, err = store.LoadPendingAuctions(); err != nil {
+		return nil, fmt.Errorf("failed to load pending auction records: %w", err)
+	}
+	// The node may have stopped after storing a block but before storing what was left pending: check
+	// the reloaded bids and records against the chain, like after a new block
+	var reloaded int = len(b.mempool.entries) + len(b.PendingAuctions)
+	b.recheckPending(map[string]bool{})
+	if dropped := reloaded - len(b.mempool.entries) - len(b.PendingAuctions); dropped > 0 {
+		log.Printf("Dropped %d reloaded pending bids and auction records that are no longer valid", dropped)
+	}
+	peers, err := store.LoadPeers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peers: %w", err)
+	}
+	for i := range peers {
+		b.NetworkNodes[peers[i].Url] = &peers[i]
+	}
+	return b, nil
+}
+
+// MarshalJSON converts the blockchain to JSON while holding its locks, so that the output is a
+// consistent snapshot even while bids and blocks are being added
+func (b *BlockChain) MarshalJSON() ([]byte, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	b.nodesMutex.RLock()
+	defer b.nodesMutex.RUnlock()
+
+	// Marshalling BlockChain itself would call MarshalJSON again
+	return json.Marshal(&BlockChainSnapshot{b.Chain, b.mempool.bids(), b.PendingAuctions, b.peerRecords()})
+}
+
+// RegisterBid registers a bid in the mempool. The bid must be properly signed, its sequence number
+// must be higher than the bidder's last sequence number in the chain and not already used by a
+// pending bid, and it must target an auction (in the chain or pending) that is not closed and whose
+// close time is not past; otherwise an error wrapping ErrInvalidBid is returned. ErrDuplicateBid is
+// returned if the bid is already pending and ErrMempoolFull if the mempool is full. The bid is stored
+// before it is accepted
+func (b *BlockChain) RegisterBid(bid Bid) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.mempool.has(bid.Hash()) {
+		return ErrDuplicateBid
+	}
+	if reason := checkBidFormat(bid); reason != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidBid, reason)
+	}
+	if reason := b.checkPendingBid(bid, b.mempool, b.pendingAuctionsById(b.PendingAuctions)); reason != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidBid, reason)
+	}
+	if b.mempool.expire(time.Now().Add(-maxPendingBidAge)) > 0 {
+		b.storePendingBids()
+	}
+	if b.mempool.isFull(len(bid.canonicalBytes())) {
+		return ErrMempoolFull
+	}
+
+	if err := b.store.AppendPendingBid(bid); err != nil {
+		return err
+	}
+	b.mempool.add(bid, time.Now())
+	return nil
+}
+
+// TakeOverPendingBids adds the pending bids of another node to the mempool (i.e., those of the node
+// our chain was synced with, see sync.go). Each bid is checked like a bid received by RegisterBid; bids
+// that are already pending or not valid against our chain are skipped, and bids stop being added once
+// the mempool is full. Returns how many bids were added
+func (b *BlockChain) TakeOverPendingBids(bids Bids) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var added int = 0
+	var pendingCreations map[int]AuctionRecord = b.pendingAuctionsById(b.PendingAuctions)
+	for _, bid := range bids {
+		if b.mempool.has(bid.Hash()) || checkBidFormat(bid) != "" ||
+			b.checkPendingBid(bid, b.mempool, pendingCreations) != "" {
+			continue
+		}
+		if b.mempool.isFull(len(bid.canonicalBytes())) {
+			break
+		}
+		b.mempool.add(bid, time.Now())
+		added++
+	}
+	if added > 0 {
+		b.storePendingBids()
+	}
+	return added
+}
+
+// RegisterAuctionRecord registers an auction record in the blockchain. The record must be properly
+// signed by the seller. A create record must use an auction id that is not used yet (in the chain or
+// by a pending record) and a close time that is not past; a close record must name an auction of the
+// same seller that is not closed or being closed. Otherwise an error wrapping ErrInvalidAuctionRecord
+// is returned. The record is stored before it is accepted
+func (b *BlockChain) RegisterAuctionRecord(record AuctionRecord) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if reason := b.checkPendingAuction(record, b.PendingAuctions); reason != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidAuctionRecord, reason)
+	}
+
+	if err := b.store.AppendPendingAuction(record); err != nil {
+		return err
+	}
+	b.PendingAuctions = append(b.PendingAuctions, record)
+	return nil
 }
 
-// RegisterNode registers a node in the blockchain if it does not already exist
-func (b *BlockChain) RegisterNode(node string) bool {
-	// Add node if it does not exist, else do nothing
-	if !b.NetworkNodes[node] {
-		b.NetworkNodes[node] = true
-		return true		// node added
+// GetAuction gets the state of an auction, with its status as of now. Returns false if there is no
+// auction with the given id in the chain
+func (b *BlockChain) GetAuction(auctionId int) (AuctionState, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	auction, exists := b.ledger.auctions[auctionId]
+	if !exists {
+		return AuctionState{}, false
 	}
-	return false		// node already exists
+	var state AuctionState = *auction
+	state.Status = state.statusAt(time.Now().UnixNano())
+	return state, true
+}
+
+// GetAuctions gets the state of all auctions in the chain, by auction id
+func (b *BlockChain) GetAuctions() []AuctionState {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var now int64 = time.Now().UnixNano()
+	var auctions []AuctionState = make([]AuctionState, 0, len(b.ledger.auctions))
+	for _, auction := range b.ledger.auctions {
+		var state AuctionState = *auction
+		state.Status = state.statusAt(now)
+		auctions = append(auctions, state)
+	}
+	sort.Slice(auctions, func(i, j int) bool {
+		return auctions[i].Auction.AuctionId < auctions[j].Auction.AuctionId
+	})
+	return auctions
 }
 
 // GetLastBlock gets last block in the chain
 func (b *BlockChain) GetLastBlock() Block {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
 	return b.Chain[len(b.Chain)-1]
 }
 
-// CreateNewBlock create new block and appends it to the blockchain
-func (b *BlockChain) CreateNewBlock(nonce int, previousBlockHash string, hash string) Block {
+// GetChainLength gets the number of blocks in the chain
+func (b *BlockChain) GetChainLength() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.Chain)
+}
+
+// PrepareMiningCandidate takes a snapshot of everything needed to mine the next block: the last
+// block and the bids and auction records pending right now. Proof of work then runs on the snapshot
+// without holding any lock, and bids that arrive in the meantime simply wait for the next block.
+// The block's timestamp is fixed here, since it decides which auctions are open: only the pending
+// bids and records that are valid at that time are taken, in the order they are applied (auction
+// creations, bids in sequence order, auction closes). The others stay pending (i.e., a bid for an
+// auction that is not open yet, or a close record for an auction that is not over yet)
+func (b *BlockChain) PrepareMiningCandidate() MiningCandidate {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var lastBlock Block = b.Chain[len(b.Chain)-1]
+
+	// Timestamps must increase along the chain (retargeting depends on them), even if the last
+	// block came from a node whose clock is slightly ahead of ours
+	var timestamp int64 = time.Now().UnixNano()
+	if timestamp <= lastBlock.Timestamp {
+		timestamp = lastBlock.Timestamp + 1
+	}
+	var block Block = Block{ChainId: lastBlock.ChainId, Index: lastBlock.Index + 1, Timestamp: timestamp, Bids: Bids{}, Auctions: AuctionRecords{}}
+
+	// Apply the pending bids and records to a copy of the ledger, keeping those that fit
+	var state *ledger = b.ledger.clone()
+	for _, record := range b.PendingAuctions {
+		if record.Type == AuctionRecordCreate && state.applyCreate(record, block) == "" {
+			block.Auctions = append(block.Auctions, record)
+		}
+	}
+	var bids Bids = b.mempool.bids()
+	sortBySequence(bids)
+	for _, bid := range bids {
+		if state.applyBid(bid, timestamp) == "" {
+			block.Bids = append(block.Bids, bid)
+		}
+	}
+	for _, record := range b.PendingAuctions {
+		if record.Type == AuctionRecordClose && state.applyClose(record, block) == "" {
+			block.Auctions = append(block.Auctions, record)
+		}
+	}
+
+	var difficulty int = b.difficulty.NextDifficulty(b.Chain)
+	var merkleRoot string = ComputeMerkleRoot(block.Bids)
+	var auctionRoot string = ComputeAuctionRoot(block.Auctions)
+	return MiningCandidate{
+		Version:           BlockVersion,
+		ChainId:           block.ChainId,
+		Index:             block.Index,
+		PreviousBlockHash: lastBlock.Hash,
+		Timestamp:         timestamp,
+		Bids:              block.Bids,
+		Auctions:          block.Auctions,
+		MerkleRoot:        merkleRoot,
+		AuctionRoot:       auctionRoot,
+		Difficulty:        difficulty,
+	}
+}
+
+// CreateNewBlock create new block from a mined candidate and appends it to the blockchain. The block
+// is stored before it is added to the chain. If the chain changed since the candidate was prepared,
+// the candidate is stale and ErrStaleCandidate is returned.
+// The candidate's bids and auction records are removed from the pending ones (a bidder cannot have
+// two pending bids with the same sequence number, so a bid hash identifies a single pending bid);
+// those that arrived while mining stay pending
+func (b *BlockChain) CreateNewBlock(candidate MiningCandidate, nonce int, hash string) (Block, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var lastBlock Block = b.Chain[len(b.Chain)-1]
+	if lastBlock.Hash != candidate.PreviousBlockHash {
+		return Block{}, ErrStaleCandidate
+	}
+
 	newBlock := Block{
-		Index:             len(b.Chain) + 1,	// Length of chain + 1
-		Timestamp:         time.Now().UnixNano(),
-		Bids:              b.PendingBids,
+		Version:           candidate.Version,
+		ChainId:           candidate.ChainId,
+		Index:             candidate.Index,
+		Timestamp:         candidate.Timestamp,
+		Bids:              candidate.Bids,
+		Auctions:          candidate.Auctions,
 		Nonce:             nonce	,
+		Difficulty:        candidate.Difficulty,
+		MerkleRoot:        candidate.MerkleRoot,
+		AuctionRoot:       candidate.AuctionRoot,
 		Hash:              hash,
-		PreviousBlockHash: previousBlockHash,
+		PreviousBlockHash: candidate.PreviousBlockHash,
 	}
 
-	// There are no pending bids when a new block is created
-	b.PendingBids = Bids{}
+	// The candidate was built on this very ledger, so this only fails on a bug
+	var state *ledger = b.ledger.clone()
+	if reason := state.applyBlock(newBlock); reason != "" {
+		return Block{}, fmt.Errorf("mined block does not fit the chain: %s", reason)
+	}
+	if err := b.store.AppendBlock(newBlock); err != nil {
+		return Block{}, err
+	}
 
-	// Add this new block to the chain
+	// Add this new block to the chain and index its bids
 	b.Chain = append(b.Chain, newBlock )
+	b.bidIndex.addBlock(newBlock)
+	b.ledger = state
+	b.tree.add(newBlock)
+
+	// Bids and auction records in the new block are no longer pending
+	b.prunePending(newBlock)
+	b.pruneSideBranches()
+	b.notifyTipChanged(newBlock)
 	
-	return  newBlock
+	return  newBlock, nil
 }
 
-// HashBlock calculates hash value for the given parameters
-func (b *BlockChain) HashBlock(previousBlockHash string, currentBlockData string, nonce int) string {
-	//  Construct the string to hash from input data
-	var stringToHash string = previousBlockHash + currentBlockData + strconv.Itoa(nonce)
+// OnTipChanged registers a function that is called each time the last block of the chain changes
+// (a block is mined or received, or the chain is replaced). The function is called with the chain
+// locked, so it must return quickly and must not call back into the blockchain
+func (b *BlockChain) OnTipChanged(listener func(newTip Block)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tipListeners = append(b.tipListeners, listener)
+}
 
-	// Perform a sha256 hash. Standard logic to perform a hash:
-	// 	h := sha256.New()
-	//	h.Write([]byte("hello world\n"))  --> The array of bytes represents a very log sequence of 1s and 0s
-	//	fmt.Printf("%x", h.Sum(nil))      --> prints: 948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a44
-	var hash hash.Hash = sha256.New()
-	hash.Write([]byte(stringToHash))
+// notifyTipChanged calls all tip listeners. Must be called with mutex held
+func (b *BlockChain) notifyTipChanged(newTip Block) {
+	for _, listener := range b.tipListeners {
+		listener(newTip)
+	}
+}
 
-	// The output of sha256 is a binary value (expressed as an array of bytes). Encoding
-	// is used to represent the hash as a string of characters, without increasing the size too much.
-	// Note the use of hash.Sum(nil) to convert the hash into a byte slice as required by EncodeToString
-	var base64Hash string = base64.URLEncoding.EncodeToString(hash.Sum(nil))
-	return base64Hash
+// checkBidFormat returns the reason why a bid is not properly signed or not well formed, or "" if it
+// is fine. This does not depend on the chain
+func checkBidFormat(bid Bid) string {
+	if reason := checkBidSignature(bid); reason != "" {
+		return reason
+	}
+	return checkSealedBidFields(bid)
 }
 
-// ProofOfWork increments a nonce until the hash value starts with a specific string value
-func (b *BlockChain) ProofOfWork (previousBlockHash string, currentBlockData string) int {
+// checkPendingBid returns the reason why a well formed bid (see checkBidFormat) cannot be added to the
+// given pending bids, or "" if it can. pendingCreations holds the auctions created by pending records.
+// Must be called with mutex held
+func (b *BlockChain) checkPendingBid(bid Bid, pendingBids *mempool, pendingCreations map[int]AuctionRecord) string {
+	if bid.ChainId != b.genesis.ChainId {
+		return fmt.Sprintf("bid is for chain %q, this node follows chain %q", bid.ChainId, b.genesis.ChainId)
+	}
+	if last := b.ledger.lastSequence[bid.PublicKey]; bid.Sequence <= last {
+		return fmt.Sprintf("sequence %d is not higher than last sequence %d of the bidder (replayed bid?)",
+			bid.Sequence, last)
+	}
+	if pendingBids.hasSequence(bid.PublicKey, bid.Sequence) {
+		return fmt.Sprintf("sequence %d is already used by a pending bid of the bidder", bid.Sequence)
+	}
+
+	// The block that will take the bid is not mined yet, so the auction can only be checked against
+	// the current time: it must not be over. A bid for an auction that is not open yet waits, but a
+	// reveal is refused before the reveal window, as it would make the value public too early
+	var auction AuctionRecord
+	if state, exists := b.ledger.auctions[bid.AuctionId]; exists {
+		if state.ClosedInBlock != 0 {
+			return fmt.Sprintf("auction %d is closed", bid.AuctionId)
+		}
+		auction = state.Auction
+	} else if creation, pending := pendingCreations[bid.AuctionId]; pending {
+		auction = creation
+	} else {
+		return fmt.Sprintf("auction %d does not exist", bid.AuctionId)
+	}
+	if reason := auction.checkBidCurrency(bid); reason != "" {
+		return reason
+	}
+	if auction.Mode == AuctionModeSealed && bid.Kind == "" {
+		return fmt.Sprintf("auction %d is sealed: bids must be %q or %q bids", bid.AuctionId, BidKindCommit, BidKindReveal)
+	}
+	if auction.Mode != AuctionModeSealed && bid.Kind != "" {
+		return fmt.Sprintf("auction %d is not sealed: %q bids are not allowed", bid.AuctionId, bid.Kind)
+	}
+	var now int64 = time.Now().UnixNano()
+	if deadline := auction.biddingDeadline(bid.Kind); now >= deadline {
+		return fmt.Sprintf("auction %d stopped taking these bids at %d", bid.AuctionId, deadline)
+	}
+	if bid.Kind == BidKindReveal && now < auction.CloseTime {
+		return fmt.Sprintf("reveals of auction %d are only taken from %d", bid.AuctionId, auction.CloseTime)
+	}
+	return ""
+}
+
+// checkPendingAuction returns the reason why an auction record cannot be added to the given pending
+// records, or "" if it can. Must be called with mutex held
+func (b *BlockChain) checkPendingAuction(record AuctionRecord, pendingAuctions AuctionRecords) string {
+	if reason := checkAuctionRecord(record); reason != "" {
+		return reason
+	}
+
+	var pendingCreations map[int]AuctionRecord = b.pendingAuctionsById(pendingAuctions)
+	state, exists := b.ledger.auctions[record.AuctionId]
+	creation, pending := pendingCreations[record.AuctionId]
+	if record.Type == AuctionRecordCreate {
+		if exists || pending {
+			return fmt.Sprintf("auction %d already exists", record.AuctionId)
+		}
+		if time.Now().UnixNano() >= record.CloseTime {
+			return "close_time is already past"
+		}
+		return ""
+	}
+
+	if exists {
+		if state.ClosedInBlock != 0 {
+			return fmt.Sprintf("auction %d is already closed", record.AuctionId)
+		}
+		creation = state.Auction
+	} else if !pending {
+		return fmt.Sprintf("auction %d does not exist", record.AuctionId)
+	}
+	if record.Seller != creation.Seller {
+		return fmt.Sprintf("auction %d can only be closed by its seller", record.AuctionId)
+	}
+	if _, reason := revealedReservePrice(creation, record); reason != "" {
+		return reason
+	}
+	for _, pendingRecord := range pendingAuctions {
+		if pendingRecord.Type == AuctionRecordClose && pendingRecord.AuctionId == record.AuctionId {
+			return fmt.Sprintf("auction %d is already being closed", record.AuctionId)
+		}
+	}
+	return ""
+}
+
+// pendingAuctionsById returns the auctions created by the given pending records, by auction id
+func (b *BlockChain) pendingAuctionsById(pendingAuctions AuctionRecords) map[int]AuctionRecord {
+	var creations map[int]AuctionRecord = map[int]AuctionRecord{}
+	for _, record := range pendingAuctions {
+		if record.Type == AuctionRecordCreate {
+			creations[record.AuctionId] = record
+		}
+	}
+	return creations
+}
+
+// prunePending removes from the pending bids and auction records those that are in newBlock and
+// those that are no longer valid against the chain (i.e., replayed bids, or bids for an auction that
+// was closed), as well as expired bids, then stores what is left. The block is already stored, so a storage failure here only
+// leaves stale pending bids or records behind, which is logged rather than returned. Must be called
+// with mutex held, after the chain and ledger are updated
+func (b *BlockChain) prunePending(newBlock Block) {
+	var inBlock map[string]bool = map[string]bool{}
+	for _, bid := range newBlock.Bids {
+		inBlock[bid.Hash()] = true
+	}
+	for _, record := range newBlock.Auctions {
+		inBlock[record.Hash()] = true
+	}
+	b.recheckPending(inBlock)
+}
+
+// recheckPending keeps the pending bids and auction records that are not in inBlock (by hash) and are
+// still valid against the chain, then stores them. Must be called with mutex held
+func (b *BlockChain) recheckPending(inBlock map[string]bool) {
+	// Records first, as bids may target auctions created by pending records
+	var auctions AuctionRecords = AuctionRecords{}
+	for _, record := range b.PendingAuctions {
+		if !inBlock[record.Hash()] && b.checkPendingAuction(record, auctions) == "" {
+			auctions = append(auctions, record)
+		}
+	}
+	// Pending bids were checked when they entered the mempool, so only what depends on the chain is
+	// checked again. Bids that waited too long are dropped too
+	var pendingCreations map[int]AuctionRecord = b.pendingAuctionsById(auctions)
+	var receivedAfter time.Time = time.Now().Add(-maxPendingBidAge)
+	var bids *mempool = newMempool()
+	for _, entry := range b.mempool.list() {
+		if !inBlock[entry.hash] && !entry.receivedAt.Before(receivedAfter) &&
+			b.checkPendingBid(entry.bid, bids, pendingCreations) == "" {
+			bids.add(entry.bid, entry.receivedAt)
+		}
+	}
+
+	b.mempool = bids
+	b.PendingAuctions = auctions
+	b.storePendingBids()
+	if err := b.store.ResetPendingAuctions(b.PendingAuctions); err != nil {
+		log.Printf("Failed to store pending auction records: %s", err)
+	}
+}
+
+// storePendingBids stores the bids of the mempool. Pending bids are not essential, so a storage
+// failure is logged rather than returned. Must be called with mutex held
+func (b *BlockChain) storePendingBids() {
+	if err := b.store.ResetPendingBids(b.mempool.bids()); err != nil {
+		log.Printf("Failed to store pending bids: %s", err)
+	}
+}
+
+// HashBlock calculates the hash of a block header: the SHA-256 of its canonical encoding, base64
+// URL encoded to represent it as a string (see blockencoding.go)
+func (b *BlockChain) HashBlock(header BlockHeader) string {
+	return header.Hash()
+}
+
+// hashMeetsDifficulty checks if a block hash (as returned by HashBlock) has at least difficulty
+// leading zero bits
+func hashMeetsDifficulty(hash string, difficulty int) bool {
+	digest, err := base64.URLEncoding.DecodeString(hash)
+	if err != nil || len(digest) != sha256.Size {
+		return false
+	}
+	return meetsDifficulty(digest, difficulty)
+}
+
+// ProofOfWork increments the nonce of a header until its hash starts with (at least) the header's
+// difficulty zero bits (the header's own nonce is ignored).
+// The search stops with ctx's error as soon as ctx is cancelled (i.e., the job was cancelled or the
+// chain tip changed). If attempts is not nil, the number of hashes computed so far is added to it
+func (b *BlockChain) ProofOfWork (ctx context.Context, header BlockHeader, attempts *int64) (int, error) {
 	// Starting value for nonce
 	nonce := -1
-	inputFormat := ""
+	found := false
+	var hasher *headerHasher = newHeaderHasher(header)
 
-	// Increment the nonce until the SHA256 hash of the block data returns a string starting with “0000”
-	for inputFormat != "0000" {
+	// Increment the nonce until the SHA256 hash of the block data has enough leading zero bits
+	for !found {
 		nonce = nonce + 1
-		var hashed string = b.HashBlock(previousBlockHash, currentBlockData, nonce)
-		inputFormat = hashed[0:4]
+
+		// Checking ctx and updating attempts on every hash would slow mining down, so do it in batches
+		if nonce%proofOfWorkBatchSize == 0 && nonce > 0 {
+			if attempts != nil {
+				atomic.AddInt64(attempts, proofOfWorkBatchSize)
+			}
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+
+		var digest [sha256.Size]byte = hasher.digest(nonce)
+		found = meetsDifficulty(digest[:], header.Difficulty)
 	}
 
-	return nonce
+	if attempts != nil {
+		atomic.AddInt64(attempts, int64(nonce%proofOfWorkBatchSize)+1)
+	}
+	return nonce, nil
 }
 
 // CheckNewBlockHash
 // A new candidate block is validated by checking its PreviousBlockHash and Index fields
-// with our copy of the blockchain
+// with our copy of the blockchain, and by checking its hash and difficulty (see checkBlock).
+// Its timestamp must also not be too far in the future
 func (b *BlockChain) CheckNewBlockHash(newBlock Block) bool {
-	var lastBlock Block = b.GetLastBlock()
-	return 	lastBlock.Hash == newBlock.PreviousBlockHash &&
-			lastBlock.Index == newBlock.Index - 1
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.checkNewBlockHash(newBlock, b.ledger.clone()) == ""
 }
 
-// ChainIsValid checks if th/e entire block chain is valid
-func (b *BlockChain) ChainIsValid() bool {
-	panic("not implemented")
+// checkNewBlockHash is CheckNewBlockHash without locking. state must be a copy of the ledger, and
+// is updated with the block. Returns the reason why the block is not valid, or "" if it is valid.
+// Must be called with mutex held
+func (b *BlockChain) checkNewBlockHash(newBlock Block, state *ledger) string {
+	if newBlock.Timestamp > time.Now().Add(maxBlockTimeDrift).UnixNano() {
+		return "timestamp is too far in the future"
+	}
+	return b.checkBlock(b.Chain, state, newBlock)
 }
 
-// GetBidsForMatch gets all bids for a specific auction
-func (b* BlockChain) GetBidsForAuction(autionId string) Bids {
-	panic("not implemented")
+// ChainIsValid checks if the entire block chain is valid. See ValidateChain for the list of checks
+func (b *BlockChain) ChainIsValid() ChainValidationReport {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.ValidateChain(b.Chain)
 }
 
-// GetBidsForPlayer gets all bids for a specific player id
-func (b *BlockChain) GetBidsForPlayer(playerId string) Bids {
-	panic("not implemented")
+// ValidateChain checks if the given chain is valid. The chain is walked from the genesis block
+// onwards and the first bad block stops validation. The following checks are performed:
+// 1. The genesis block is the genesis block of this node's configuration (see genesis.go)
+// 2. Each block's index is one more than the index of the previous block
+// 3. Each block's PreviousBlockHash is the hash of the previous block
+// 4. Each block's timestamp is later than the timestamp of the previous block
+// 5. Each block's difficulty is the difficulty required after the previous block (see NextDifficulty)
+// 6. Each bid is properly signed, with a sequence number higher than the bidder's earlier bids, and
+//    targets an auction that is open at the block's timestamp
+// 7. Each auction record is properly signed by the seller and fits the auctions created so far
+//    (see auction.go)
+// 8. Each block's Merkle roots are the roots of the Merkle trees of its bids and auction records
+// 9. Each block's hash is recomputed with HashBlock from its header, in its block version
+// 10. Each block's hash meets the block's difficulty
+func (b *BlockChain) ValidateChain(chain Blocks) ChainValidationReport {
+	if len(chain) == 0 {
+		return ChainValidationReport{Valid: false, Reason: "chain is empty"}
+	}
+
+	// Check the genesis block
+	var genesisBlock Block = chain[0]
+	if reason := checkGenesisBlock(genesisBlock, b.genesis); reason != "" {
+		return invalidChainReport(genesisBlock, reason)
+	}
+
+	// Check every other block against the blocks that precede it. The blocks are applied to a
+	// ledger as we go (starting with the auctions of the genesis block), to check the bids and
+	// auction records of the next block
+	var state *ledger = newLedger()
+	state.applyBlock(genesisBlock)
+	for i := 1; i < len(chain); i++ {
+		if reason := b.checkBlock(chain[:i], state, chain[i]); reason != "" {
+			return invalidChainReport(chain[i], reason)
+		}
+	}
+
+	return ChainValidationReport{Valid: true}
+}
+
+// checkBlock returns the reason why currentBlock cannot be added at the end of chain, or "" if it can.
+// state must be the ledger after chain; it is updated with currentBlock (and left partly updated if
+// the block is not valid)
+func (b *BlockChain) checkBlock(chain Blocks, state *ledger, currentBlock Block) string {
+	if reason := checkBlockContents(currentBlock); reason != "" {
+		return reason
+	}
+	if reason := b.checkBlockPlacement(chain, currentBlock); reason != "" {
+		return reason
+	}
+
+	// Every bid and auction record must be signed, bids must not replay earlier bids, and both must
+	// fit the auctions as of the block's timestamp
+	return state.applyBlock(currentBlock)
+}
+
+// checkBlockPlacement returns the reason why currentBlock cannot follow the last block of chain
+// (chain id, index, previous hash, timestamp and required difficulty), or "" if it can
+func (b *BlockChain) checkBlockPlacement(chain Blocks, currentBlock Block) string {
+	var previousBlock Block = chain[len(chain)-1]
+	if currentBlock.ChainId != previousBlock.ChainId {
+		return fmt.Sprintf("block is for chain %q, expected chain %q", currentBlock.ChainId, previousBlock.ChainId)
+	}
+	if currentBlock.Index != previousBlock.Index+1 {
+		return fmt.Sprintf("index %d does not follow previous index %d", currentBlock.Index, previousBlock.Index)
+	}
+	if currentBlock.PreviousBlockHash != previousBlock.Hash {
+		return fmt.Sprintf("previous block hash %q does not match hash %q of block %d",
+			currentBlock.PreviousBlockHash, previousBlock.Hash, previousBlock.Index)
+	}
+
+	if currentBlock.Timestamp <= previousBlock.Timestamp {
+		return fmt.Sprintf("timestamp %d is not after previous timestamp %d", currentBlock.Timestamp, previousBlock.Timestamp)
+	}
+	var expectedDifficulty int = b.difficulty.NextDifficulty(chain)
+	if currentBlock.Difficulty != expectedDifficulty {
+		return fmt.Sprintf("difficulty %d does not match required difficulty %d", currentBlock.Difficulty, expectedDifficulty)
+	}
+	return ""
+}
+
+// checkBlockContents returns the reason why a block is not valid on its own (version, chain id of
+// its bids, Merkle roots, hash and proof of work), or "" if it is valid. This does not depend on the
+// rest of the chain
+func checkBlockContents(currentBlock Block) string {
+	if currentBlock.Version != BlockVersion {
+		return fmt.Sprintf("unsupported block version %d (this node supports version %d)", currentBlock.Version, BlockVersion)
+	}
+	for position, bid := range currentBlock.Bids {
+		if bid.ChainId != currentBlock.ChainId {
+			return fmt.Sprintf("bid %d is for chain %q, expected chain %q", position, bid.ChainId, currentBlock.ChainId)
+		}
+	}
+
+	// The Merkle roots must be the roots of the block's bids and auction records, since the hash only
+	// covers the roots
+	var merkleRoot string = ComputeMerkleRoot(currentBlock.Bids)
+	if currentBlock.MerkleRoot != merkleRoot {
+		return fmt.Sprintf("merkle root %q does not match merkle root %q of the bids", currentBlock.MerkleRoot, merkleRoot)
+	}
+	var auctionRoot string = ComputeAuctionRoot(currentBlock.Auctions)
+	if currentBlock.AuctionRoot != auctionRoot {
+		return fmt.Sprintf("auction root %q does not match merkle root %q of the auction records", currentBlock.AuctionRoot, auctionRoot)
+	}
+
+	// The hash covers the whole header, timestamp included
+	var recomputedHash string = currentBlock.Header().Hash()
+	if recomputedHash != currentBlock.Hash {
+		return fmt.Sprintf("hash %q does not match recomputed hash %q", currentBlock.Hash, recomputedHash)
+	}
+	if !hashMeetsDifficulty(currentBlock.Hash, currentBlock.Difficulty) {
+		return fmt.Sprintf("hash %q does not have %d leading zero bits", currentBlock.Hash, currentBlock.Difficulty)
+	}
+	return ""
+}
+
+// invalidChainReport creates a report for a chain whose first bad block is the given block
+func invalidChainReport(badBlock Block, reason string) ChainValidationReport {
+	return ChainValidationReport{
+		Valid:      false,
+		BlockIndex: badBlock.Index,
+		BlockHash:  badBlock.Hash,
+		Reason:     reason,
+	}
 }
 
+// GetBidsForAuction gets all bids for a specific auction
+func (b *BlockChain) GetBidsForAuction(auctionId int, query BidQuery) BidQueryResult {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
 
+	query.normalize()
+	var pending []*mempoolEntry
+	if query.IncludePending {
+		pending = b.mempool.listForAuction(auctionId)
+	}
+	return b.runBidQuery(b.bidIndex.byAuction[auctionId], pending, query)
+}
+
+// GetBidsForPlayer gets all bids for a specific player id (the public key of the bidder)
+func (b *BlockChain) GetBidsForPlayer(playerId string, query BidQuery) BidQueryResult {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	query.normalize()
+	var pending []*mempoolEntry
+	if query.IncludePending {
+		pending = b.mempool.listForBidder(playerId)
+	}
+	return b.runBidQuery(b.bidIndex.byPlayer[playerId], pending, query)
+}
+
+// GetMerkleProof builds the Merkle inclusion proof of the confirmed bid with the given hash. Returns
+// false if no confirmed bid has this hash
+func (b *BlockChain) GetMerkleProof(bidHash string) (MerkleProof, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	location, ok := b.bidIndex.byHash[bidHash]
+	if !ok {
+		return MerkleProof{}, false
+	}
+	var block Block = b.Chain[location.BlockIndex-1]
+	var proof MerkleProof = BuildMerkleProof(block.Bids, location.Position)
+	proof.BlockIndex = block.Index
+	proof.BlockHash = block.Hash
+	return proof, true
+}
@@ -0,0 +1,160 @@
+package bid
+
+import (
+	"context"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestChain returns a blockchain with its state in memory and the test difficulty
+func newTestChain(t *testing.T) *BlockChain {
+	t.Helper()
+	b, err := NewBlockChain(NewMemoryStorage(), TestGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// mineBlock mines the pending bids into a new block, like Controller.Mine
+func mineBlock(b *BlockChain) (Block, error) {
+	var candidate MiningCandidate = b.PrepareMiningCandidate()
+	nonce, err := b.ProofOfWork(context.Background(), candidate.Header(0), new(int64))
+	if err != nil {
+		return Block{}, err
+	}
+	return b.CreateNewBlock(candidate, nonce, b.HashBlock(candidate.Header(nonce)))
+}
+
+func newTestKey() ed25519.PrivateKey {
+	_, key, _ := ed25519.GenerateKey(nil)
+	return key
+}
+
+// testBid returns a bid signed by bidder
+func testBid(bidder ed25519.PrivateKey, auctionId int, value string, sequence uint64) Bid {
+	var bid Bid = Bid{ChainId: DefaultChainId, AuctionId: auctionId, BidValue: MustParseMoney(value), Sequence: sequence}
+	SignBid(&bid, bidder)
+	return bid
+}
+
+// Times of the test auctions: bidding is open from auctionOpen to auctionClose (and reveals of sealed
+// auctions until auctionReveal)
+var (
+	auctionOpen   int64 = time.Date(2021, 7, 25, 10, 0, 0, 0, time.UTC).UnixNano()
+	auctionClose  int64 = auctionOpen + int64(time.Hour)
+	auctionReveal int64 = auctionClose + int64(time.Hour)
+)
+
+// testAuction returns a create record of an open, first-price auction, changed by change (if not nil)
+// then signed by seller
+func testAuction(seller ed25519.PrivateKey, auctionId int, change func(record *AuctionRecord)) AuctionRecord {
+	var record AuctionRecord = AuctionRecord{Type: AuctionRecordCreate, AuctionId: auctionId, Item: "test item",
+		OpenTime: auctionOpen, CloseTime: auctionClose}
+	if change != nil {
+		change(&record)
+	}
+	SignAuctionRecord(&record, seller)
+	return record
+}
+
+// remine recomputes the Merkle roots, nonce and hash of a block after it was changed, so that only the
+// change itself makes the block invalid
+func remine(t *testing.T, b *BlockChain, block *Block) {
+	t.Helper()
+	block.MerkleRoot = ComputeMerkleRoot(block.Bids)
+	block.AuctionRoot = ComputeAuctionRoot(block.Auctions)
+	nonce, err := b.ProofOfWork(context.Background(), block.Header(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Nonce = nonce
+	block.Hash = block.Header().Hash()
+}
+
+// Each check of ValidateChain reports the first bad block and why it is bad
+func TestValidateChainReport(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var seller, bidder ed25519.PrivateKey = newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	if err := b.RegisterAuctionRecord(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := mineBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.RegisterBid(testBid(bidder, 1, "10.00", uint64(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	var chain Blocks = b.Chain
+	if len(chain) != 4 || len(chain[2].Bids) != 1 || len(chain[3].Bids) != 1 {
+		t.Fatalf("unexpected test chain %+v", chain)
+	}
+	if report := b.ValidateChain(chain); !report.Valid {
+		t.Fatalf("valid chain reported %+v", report)
+	}
+	if report := b.ChainIsValid(); !report.Valid {
+		t.Fatalf("ChainIsValid reported %+v", report)
+	}
+
+	var tests = []struct {
+		name   string
+		bad    int // position of the changed block
+		change func(block *Block)
+		remine bool
+		reason string
+	}{
+		{"genesis of another chain", 0, func(block *Block) { block.ChainId = "other" }, false, "genesis block is for chain \"other\""},
+		{"other genesis", 0, func(block *Block) { block.Timestamp++ }, false, "genesis block: hash"},
+		{"genesis bids", 0, func(block *Block) { block.Bids = chain[2].Bids }, false, "genesis block must not contain bids"},
+		{"version", 2, func(block *Block) { block.Version++ }, true, "unsupported block version"},
+		{"chain id", 2, func(block *Block) { block.ChainId = "other" }, true, "bid 0 is for chain \"miniblockchain\""},
+		{"index", 2, func(block *Block) { block.Index++ }, true, "index 4 does not follow previous index 2"},
+		{"previous hash", 2, func(block *Block) { block.PreviousBlockHash = chain[0].Hash }, true, "previous block hash"},
+		{"timestamp", 2, func(block *Block) { block.Timestamp = chain[1].Timestamp }, true, "is not after previous timestamp"},
+		{"difficulty", 2, func(block *Block) { block.Difficulty++ }, true, "does not match required difficulty"},
+		{"replayed bid", 3, func(block *Block) { block.Bids = append(block.Bids, chain[2].Bids[0]) }, true,
+			"bid 1: sequence 1 is not higher than last sequence 2"},
+		{"forged bid", 2, func(block *Block) { block.Bids[0].BidValue = MustParseMoney("99.00") }, true,
+			"bid 0: signature does not match"},
+		{"auction record", 2, func(block *Block) { block.Auctions = AuctionRecords{chain[1].Auctions[0]} }, true,
+			"auction record 0: auction 1 already exists"},
+		{"merkle root", 2, func(block *Block) { block.Bids = Bids{} }, false, "merkle root"},
+		{"auction root", 1, func(block *Block) { block.Auctions = AuctionRecords{} }, false, "auction root"},
+		{"hash", 2, func(block *Block) { block.Nonce++ }, false, "does not match recomputed hash"},
+		{"proof of work", 2, func(block *Block) {
+			// A block whose hash does not have enough leading zero bits
+			for block.Nonce = 0; hashMeetsDifficulty(block.Header().Hash(), block.Difficulty); block.Nonce++ {
+			}
+			block.Hash = block.Header().Hash()
+		}, false, "leading zero bits"},
+	}
+	for _, test := range tests {
+		var changed Blocks = append(Blocks{}, chain...)
+		var block Block = changed[test.bad]
+		block.Bids = append(Bids{}, block.Bids...)
+		test.change(&block)
+		if test.remine {
+			remine(t, b, &block)
+		}
+		changed[test.bad] = block
+		var report ChainValidationReport = b.ValidateChain(changed)
+		if report.Valid || report.BlockIndex != block.Index || report.BlockHash != block.Hash ||
+			!strings.Contains(report.Reason, test.reason) {
+			t.Errorf("%s: got report %+v, expected block %d and reason %q", test.name, report, block.Index, test.reason)
+		}
+	}
+
+	if report := b.ValidateChain(Blocks{}); report.Valid || report.Reason != "chain is empty" {
+		t.Errorf("empty chain: got report %+v", report)
+	}
+}
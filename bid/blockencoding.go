@@ -0,0 +1,134 @@
+/* Canonical encoding of blocks. The hash of a block is the SHA-256 of the canonical encoding of its
+header, so the header encoding is part of consensus: every node, and any client written in another
+language, must produce exactly the same bytes. The header is written with the same primitives as bids
+(see encoding.go), in this order:
+	version				8-byte big-endian signed integer
+	chain id			4-byte big-endian length, then the UTF-8 bytes (see genesis.go)
+	index				8-byte big-endian signed integer
+	timestamp			8-byte big-endian signed integer (Unix time in nanoseconds)
+	previous block hash	4-byte big-endian length, then the base64 URL encoded hash
+	merkle root			4-byte big-endian length, then the lowercase hex root of the bids
+	auction root		4-byte big-endian length, then the lowercase hex root of the auction records
+	difficulty			8-byte big-endian signed integer
+	nonce				8-byte big-endian signed integer
+The bids and auction records are covered through their Merkle roots, so the header commits to the
+whole block. The nonce comes last so that proof of work only rewrites the last 8 bytes. The block
+hash is the base64 URL encoding of the SHA-256 of these bytes.
+
+The body of a block is encoded after its header as the number of bids (8 bytes), each bid's canonical
+encoding as a length-prefixed string, then the number of auction records and each record the same way
+(see Block.CanonicalBytes). The body is not hashed as a whole: the Merkle roots in the header cover it.
+
+The version comes first so that the format can evolve: a node only accepts blocks whose version it
+knows, and a new version may change everything after the version field. Version 2 added the chain id;
+version 1 blocks are no longer accepted.
+
+The golden vectors of this encoding (a header, its bytes and its hash, and a block with a bid and an
+auction record) are in blockencoding_test.go, and the one of the bid encoding in encoding_test.go:
+clients check their encoders against them */
+package bid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// BlockVersion is the version of the block encoding written by this node (see Block.Version)
+const BlockVersion = 2
+
+// BlockHeader is the part of a block covered by its hash
+type BlockHeader struct {
+	Version           int
+	ChainId           string
+	Index             int
+	Timestamp         int64
+	PreviousBlockHash string
+	MerkleRoot        string
+	AuctionRoot       string
+	Difficulty        int
+	Nonce             int
+}
+
+// Header returns the header of a block
+func (block Block) Header() BlockHeader {
+	return BlockHeader{
+		Version:           block.Version,
+		ChainId:           block.ChainId,
+		Index:             block.Index,
+		Timestamp:         block.Timestamp,
+		PreviousBlockHash: block.PreviousBlockHash,
+		MerkleRoot:        block.MerkleRoot,
+		AuctionRoot:       block.AuctionRoot,
+		Difficulty:        block.Difficulty,
+		Nonce:             block.Nonce,
+	}
+}
+
+// Header returns the header of the block mined from a candidate with the given nonce
+func (candidate MiningCandidate) Header(nonce int) BlockHeader {
+	return BlockHeader{
+		Version:           candidate.Version,
+		ChainId:           candidate.ChainId,
+		Index:             candidate.Index,
+		Timestamp:         candidate.Timestamp,
+		PreviousBlockHash: candidate.PreviousBlockHash,
+		MerkleRoot:        candidate.MerkleRoot,
+		AuctionRoot:       candidate.AuctionRoot,
+		Difficulty:        candidate.Difficulty,
+		Nonce:             nonce,
+	}
+}
+
+// CanonicalBytes returns the canonical encoding of a block header
+func (header BlockHeader) CanonicalBytes() []byte {
+	var buffer bytes.Buffer
+	writeCanonicalInt(&buffer, int64(header.Version))
+	writeCanonicalString(&buffer, header.ChainId)
+	writeCanonicalInt(&buffer, int64(header.Index))
+	writeCanonicalInt(&buffer, header.Timestamp)
+	writeCanonicalString(&buffer, header.PreviousBlockHash)
+	writeCanonicalString(&buffer, header.MerkleRoot)
+	writeCanonicalString(&buffer, header.AuctionRoot)
+	writeCanonicalInt(&buffer, int64(header.Difficulty))
+	writeCanonicalInt(&buffer, int64(header.Nonce))
+	return buffer.Bytes()
+}
+
+// Hash returns the hash of a block header: the SHA-256 of its canonical encoding, base64 URL encoded
+func (header BlockHeader) Hash() string {
+	var digest [sha256.Size]byte = sha256.Sum256(header.CanonicalBytes())
+	return base64.URLEncoding.EncodeToString(digest[:])
+}
+
+// CanonicalBytes returns the canonical encoding of a whole block: its header, then its bids and
+// auction records
+func (block Block) CanonicalBytes() []byte {
+	var buffer *bytes.Buffer = bytes.NewBuffer(block.Header().CanonicalBytes())
+	writeCanonicalInt(buffer, int64(len(block.Bids)))
+	for _, bid := range block.Bids {
+		writeCanonicalString(buffer, string(bid.canonicalBytes()))
+	}
+	writeCanonicalInt(buffer, int64(len(block.Auctions)))
+	for _, record := range block.Auctions {
+		writeCanonicalString(buffer, string(record.canonicalBytes()))
+	}
+	return buffer.Bytes()
+}
+
+// headerHasher computes the digests of a header for successive nonces without encoding the whole
+// header each time: the nonce is the last field, so only its 8 bytes change
+type headerHasher struct {
+	encoded []byte
+}
+
+func newHeaderHasher(header BlockHeader) *headerHasher {
+	return &headerHasher{encoded: header.CanonicalBytes()}
+}
+
+// digest returns the SHA-256 of the header with the given nonce
+func (h *headerHasher) digest(nonce int) [sha256.Size]byte {
+	binary.BigEndian.PutUint64(h.encoded[len(h.encoded)-8:], uint64(nonce))
+	return sha256.Sum256(h.encoded)
+}
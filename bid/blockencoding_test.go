@@ -0,0 +1,96 @@
+package bid
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The golden vector of the header encoding: clients in other languages check their encoder against it
+func TestBlockHeaderGoldenVector(t *testing.T) {
+	var header BlockHeader = BlockHeader{
+		Version:           2,
+		ChainId:           "miniblockchain",
+		Index:             2,
+		Timestamp:         1627171722582903400,
+		PreviousBlockHash: "0",
+		MerkleRoot:        emptyMerkleRoot,
+		AuctionRoot:       emptyMerkleRoot,
+		Difficulty:        4,
+		Nonce:             11,
+	}
+	var root string = "00000040" + strings.Repeat("30", 64)
+	var expected string = "0000000000000002" + // version
+		"0000000e" + "6d696e69626c6f636b636861696e" + // chain id
+		"0000000000000002" + // index
+		"1694e00f811d4a68" + // timestamp
+		"00000001" + "30" + // previous block hash
+		root + // merkle root
+		root + // auction root
+		"0000000000000004" + // difficulty
+		"000000000000000b" // nonce
+
+	if encoded := hex.EncodeToString(header.CanonicalBytes()); encoded != expected {
+		t.Fatalf("header encoding\n got %s\nwant %s", encoded, expected)
+	}
+	if hash := header.Hash(); hash != "A2xnkcSkQiZb0WiPSFthrFZmtrOIaRkRr7wCPs3nF4s=" {
+		t.Fatalf("header hash: got %s", hash)
+	}
+	var block Block = Block{Version: 2, ChainId: "miniblockchain", Index: 2, Timestamp: 1627171722582903400,
+		PreviousBlockHash: "0", MerkleRoot: emptyMerkleRoot, AuctionRoot: emptyMerkleRoot, Difficulty: 4, Nonce: 11}
+	if block.Header() != header {
+		t.Fatalf("block header: got %+v", block.Header())
+	}
+	// An empty body is two zero counts after the header
+	if encoded := hex.EncodeToString(block.CanonicalBytes()); encoded != expected+"0000000000000000"+"0000000000000000" {
+		t.Fatalf("block encoding: got %s", encoded)
+	}
+
+	// A body with a bid and an auction record: each is written as a length-prefixed string
+	block.Bids = Bids{goldenBid}
+	block.Auctions = AuctionRecords{{Type: AuctionRecordCreate, AuctionId: 100, Seller: "abcd", Item: "lamp",
+		Currency: "EUR", ReservePrice: MustParseMoney("10.00 EUR"), OpenTime: 1, CloseTime: 2, Signature: "ef01"}}
+	var bidBytes string = goldenBidSignedBytes + "00000004" + "65663031"
+	var recordBytes string = "00000006" + "637265617465" + // type
+		"0000000000000064" + // auction id
+		"00000004" + "61626364" + // seller
+		"00000004" + "6c616d70" + // item
+		"00000000" + // mode
+		"00000000" + // rule
+		"00000003" + "455552" + // currency
+		"00000000000003e8" + "00000003" + "455552" + // reserve price
+		"00000000" + // reserve commitment
+		"00000000" + // reserve salt
+		"0000000000000001" + // open time
+		"0000000000000002" + // close time
+		"0000000000000000" + // reveal time
+		"00000004" + "65663031" // signature
+	var body string = "0000000000000001" + "00000056" + bidBytes + // bids
+		"0000000000000001" + "00000068" + recordBytes // auction records
+	if encoded := hex.EncodeToString(block.CanonicalBytes()); encoded != expected+body {
+		t.Fatalf("block encoding\n got %s\nwant %s", encoded, expected+body)
+	}
+}
+
+// The golden vector of the difficulty rules, whose hash is the previous block hash of the genesis
+// block (see genesis.go), and of the default genesis hash
+func TestGenesisGoldenVector(t *testing.T) {
+	var rules DifficultyConfig = DefaultDifficultyConfig()
+	if hash := rules.Hash(); hash != "mEC2Q3JhBaJn--ueHQvZdgjaBJOxm0W9TWFceKogFmk=" {
+		t.Fatalf("difficulty rules hash: got %s", hash)
+	}
+	genesisBlock, err := DefaultGenesisConfig().Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesisBlock.PreviousBlockHash != rules.Hash() || genesisBlock.Hash != "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=" {
+		t.Fatalf("genesis block: got previous hash %s, hash %s", genesisBlock.PreviousBlockHash, genesisBlock.Hash)
+	}
+	// Other rules give another genesis block
+	rules.RetargetInterval++
+	var config GenesisConfig = DefaultGenesisConfig()
+	config.Difficulty = rules
+	if other, _ := config.Block(); other.Hash == genesisBlock.Hash {
+		t.Fatalf("genesis hash does not depend on the difficulty rules")
+	}
+}
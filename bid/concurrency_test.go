@@ -0,0 +1,149 @@
+package bid
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentLoad registers bids, mines, receives blocks from another node and marshals the chain,
+// all at once. Run with -race. Every accepted bid must end up exactly once in the main chain or in the
+// pending bids, whatever the reorganizations
+func TestConcurrentLoad(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var c *Controller = &Controller{blockChain: b, currentNodeUrl: "http://localhost:9100", events: NewEventBus()}
+
+	// A second chain with the same genesis block mines competing blocks, sent to the node
+	var other *BlockChain = newTestChain(t)
+	var now int64 = time.Now().UnixNano()
+	var create AuctionRecord = testAuction(newTestKey(), 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now, now+int64(time.Hour)
+	})
+	for _, chain := range []*BlockChain{b, other} {
+		if err := chain.RegisterAuctionRecord(create); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both chains create the auction in their own block 2: the node takes the other's branch only
+	// once it has more work, and the auction exists on both branches
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	otherBlock, err := mineBlock(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.AddBlock(otherBlock); err != nil {
+		t.Fatal(err)
+	}
+
+	const bidders = 4
+	const bidsPerBidder = 50
+	var accepted sync.Map
+	var stop chan struct{} = make(chan struct{})
+	var bidding, background sync.WaitGroup
+
+	// Miners, received blocks and readers run in the background until every bid is registered
+	var repeat = func(work func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				work()
+			}
+		}()
+	}
+	// Miners
+	for miner := 0; miner < 2; miner++ {
+		repeat(func() {
+			if _, err := mineBlock(b); err != nil && !errors.Is(err, ErrStaleCandidate) {
+				t.Error(err)
+			}
+			// Slower than the other chain, so that its branch takes over from time to time
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+	// Blocks of the other chain, received like gossiped blocks
+	repeat(func() {
+		block, err := mineBlock(other)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := json.Marshal(block)
+		var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+		c.ReceiveNewBlock(recorder, httptest.NewRequest("POST", "/receive-new-block", bytes.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("block %d: %d %s", block.Index, recorder.Code, recorder.Body.String())
+		}
+		time.Sleep(2 * time.Millisecond)
+	})
+	// Readers
+	repeat(func() {
+		var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+		c.GetBlockChain(recorder, httptest.NewRequest("GET", "/blockchain", nil))
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decoded); err != nil {
+			t.Errorf("GET /blockchain: %s", err)
+		}
+		b.GetBidsForAuction(1, BidQuery{IncludePending: true})
+	})
+
+	// Bidders
+	for bidder := 0; bidder < bidders; bidder++ {
+		bidding.Add(1)
+		go func(bidder int) {
+			defer bidding.Done()
+			var key ed25519.PrivateKey = newTestKey()
+			for sequence := uint64(1); sequence <= bidsPerBidder; sequence++ {
+				var bid Bid = Bid{ChainId: DefaultChainId, AuctionId: 1, BidValue: Money{Units: int64(bidder*1000) + int64(sequence)}, Sequence: sequence}
+				SignBid(&bid, key)
+				body, _ := json.Marshal(bid)
+				var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+				c.RegisterBid(recorder, httptest.NewRequest("POST", "/bid", bytes.NewReader(body)))
+				if recorder.Code != http.StatusCreated {
+					t.Errorf("bid %d of bidder %d: %d %s", sequence, bidder, recorder.Code, recorder.Body.String())
+					continue
+				}
+				accepted.Store(bid.Hash(), true)
+			}
+		}(bidder)
+	}
+
+	bidding.Wait()
+	close(stop)
+	background.Wait()
+
+	var seen map[string]int = map[string]int{}
+	for _, block := range b.Chain {
+		for _, bid := range block.Bids {
+			seen[bid.Hash()]++
+		}
+	}
+	for _, bid := range b.GetPendingBids() {
+		seen[bid.Hash()]++
+	}
+	var count int
+	accepted.Range(func(hash, _ interface{}) bool {
+		count++
+		if seen[hash.(string)] != 1 {
+			t.Errorf("bid %s is %d times in the chain and mempool", hash, seen[hash.(string)])
+		}
+		return true
+	})
+	if count != bidders*bidsPerBidder {
+		t.Errorf("%d bids accepted, expected %d", count, bidders*bidsPerBidder)
+	}
+	t.Logf("%d blocks, %d pending bids, %d reorgs", len(b.Chain), len(b.GetPendingBids()), len(b.GetForkStatus().Reorgs))
+}
@@ -5,32 +5,60 @@ package bid
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"github.com/gorilla/mux"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 )
 
+// Header carrying the url of the node that sends a POST request to another node
+const nodeUrlHeader = "X-Node-Url"
+
+// How long a call to another node (see doGetCall and doPostCall) may take, so that a node that never
+// answers does not block the caller forever
+const nodeCallTimeout = 10 * time.Second
+
+// Client of the calls to other nodes
+var nodeClient *http.Client = &http.Client{Timeout: nodeCallTimeout}
+
 // GetBlockChain GET /blockchain
 /* Retrieves the blockchain in JSON format. Typical output looks like this:
 {
 	"chain": [
 		{
+			"version": 2,
+			"chain_id": "miniblockchain",
 			"index": 1,
 			"timestamp": 1627171722582903400,
 			"bids": [],
+			"auctions": [],
 			"nonce": 100,
-			"hash": "0",
-			"previous_block_hash": "0"
+			"difficulty": 0,
+			"merkle_root": "0000000000000000000000000000000000000000000000000000000000000000",
+			"auction_root": "0000000000000000000000000000000000000000000000000000000000000000",
+			"hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+			"previous_block_hash": "mEC2Q3JhBaJn--ueHQvZdgjaBJOxm0W9TWFceKogFmk="
 		}
 	],
 	"pending_bids": [],
-	"network_nodes": []
+	"network_nodes": [
+		{
+			"url": "http://localhost:9001",
+			"added_at": "2021-07-25T10:00:00.000000000Z",
+			"last_seen": "2021-07-25T10:15:00.000000000Z",
+			"consecutive_failures": 0,
+			"total_failures": 0,
+			"latency_ms": 1.8,
+			"chain_height": 1,
+			"status": "healthy"
+		}
+	]
 }
 */
 func (c *Controller) GetBlockChain(writer http.ResponseWriter, request *http.Request) {
@@ -48,11 +76,21 @@ func (c *Controller) GetBlockChain(writer http.ResponseWriter, request *http.Req
 }
 
 // RegisterAndBroadcastBid POST /bid/broadcast
-/* Register a bid in current blockchain and transmit to all nodes in the network. Typical body input
+/* Register a bid in current blockchain and gossip it to the network (see gossip.go): the response is sent
+once the bid is accepted locally, and the bid is sent to other nodes in the background. Bids must be signed with
+the bidder's ed25519 key (see SignBid), and sequence must be higher than the bidder's previous bids; other
+bids are rejected with 422. Bids for sealed auctions also carry "kind" ("commit" or "reveal"),
+"commitment" and, for reveals, "salt" (see sealed.go). "bid_value" is an exact amount written as a string,
+in the currency of the auction if it has one (i.e. "123.45 EUR", see money.go). "chain_id" must be the
+chain followed by the node (see genesis.go). Typical body input
 {
+	"chain_id": "miniblockchain",
+	"public_key": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+	"sequence": 1,
 	"bidder_name": "YD",
 	"auction_id": 100,
-	"bid_value": "123.45"
+	"bid_value": "123.45",
+	"signature": "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155..."
 }
 */
 func (c *Controller) RegisterAndBroadcastBid(writer http.ResponseWriter, request *http.Request) {
@@ -61,11 +99,16 @@ func (c *Controller) RegisterAndBroadcastBid(writer http.ResponseWriter, request
 
 // RegisterBid POST /bid
 /* This method registers an API bid locally but does not transmit it. This happens when a user registers
-a bid and broadcasts the bid to all other nodes (users) by calling RegisterAndBroadcastBid. Typical body input:
+a bid and broadcasts the bid to all other nodes (users) by calling RegisterAndBroadcastBid. Bids gossiped by
+other nodes (with an X-Gossip-Ttl header) also arrive here, and are relayed while their TTL lasts. Typical body input:
 {
+	"chain_id": "miniblockchain",
+	"public_key": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+	"sequence": 1,
 	"bidder_name": "YD",
 	"auction_id": 100,
-	"bid_value": "123.45"
+	"bid_value": "123.45",
+	"signature": "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155..."
 }
 */
 func (c *Controller) RegisterBid(writer http.ResponseWriter, request *http.Request) {
@@ -73,43 +116,73 @@ func (c *Controller) RegisterBid(writer http.ResponseWriter, request *http.Reque
 }
 
 // Mine GET /mine
-// Mining works by getting the last block and calling ProofOfWork to find the nonce
-// of the new block to be added. Once the nonce is found, a new block is created
-// and added to the chain. Lastly the block is transmitted to all other nodes by
-// calling ReceiveNewBlock on each available node
+/* Mining works by getting the last block and calling ProofOfWork to find the nonce
+of the new block to be added. Once the nonce is found, a new block is created
+and added to the chain. Lastly the block is transmitted to all other nodes by
+calling ReceiveNewBlock on each available node.
+Proof of work can take a while, so mining runs as a background job (see Miner): this method
+starts a job and returns its status straight away (202 Accepted). If a job is already running,
+no new job is started and the running job's status is returned (200 OK). Typical output:
+{
+	"job_id": "9f86d081884c7d65",
+	"status": "running",
+	"attempts": 0,
+	"hash_rate": 0,
+	"started_at": "2021-07-25T10:15:00.000000000Z"
+}
+*/
 func (c *Controller) Mine(writer http.ResponseWriter, request *http.Request) {
-	// Get hash of the last block in the blockchain
-	var lastBlock Block = c.blockChain.GetLastBlock()
-	var lastBlockHash string = lastBlock.Hash
-
-	// To calculate proof of work, we need two items: the hash of the last block
-	//(we have it above),  and data for the new block in the form of a string.
-	// To collect data for the new block, we create a BlockData struct and then
-	// convert this struct value to a string.
-	// To convert a BlockData struct value to a string, we first convert it a []byte
-	// using json.Marshal and then we use base64 encoding to get a string representation
-	// of the []byte (recall, base64 only contains A–Z, a–z, 0–9, +, / and =)
-	var newBlockData BlockData = BlockData{strconv.Itoa(lastBlock.Index), c.blockChain.PendingBids}
-	var newBlockDataAsBinary , _ = json.Marshal(newBlockData)
-	var newBlockDataAsString  = base64.URLEncoding.EncodeToString(newBlockDataAsBinary)
-
-	// We now have both items required for proof of work. Run proof of work to get nonce
-	var nonce int =  c.blockChain.ProofOfWork(lastBlockHash, newBlockDataAsString)
-
-	// Now that we have the nonce, to create a new block we also need a hash for the new block
-	var hash =  c.blockChain.HashBlock(lastBlockHash, newBlockDataAsString, nonce)
-	var newBlock Block =  c.blockChain.CreateNewBlock(nonce, lastBlockHash, hash)
-
-	// We have a new block! Broadcast it to all nodes (call ReceiveNewBlock on all nodes)
-	blockToBroadcast, _ := json.Marshal(newBlock)
-	c.broadcastToAllNodes("/receive-new-block", blockToBroadcast);
+	status, started := c.miner.StartJob()
+	if started {
+		sendJsonResponse(writer, http.StatusAccepted, status)
+	} else {
+		sendJsonResponse(writer, http.StatusOK, status)
+	}
+}
+
+// GetMiningJob GET /mine/jobs/{jobId}
+/* Retrieves the status of a mining job: running, mined (with the index and hash of the new block),
+cancelled (with the reason) or failed. Attempts is the number of hashes computed so far and
+hash_rate the number of hashes per second */
+func (c *Controller) GetMiningJob(writer http.ResponseWriter, request *http.Request) {
+	status, ok := c.miner.GetJob(mux.Vars(request)["jobId"])
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetMiningJob", "Mining job not found")
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, status)
+}
+
+// CancelMiningJob POST /mine/jobs/{jobId}/cancel
+/* Cancels a running mining job. Cancelling a job that has already finished has no effect.
+Returns the status of the job */
+func (c *Controller) CancelMiningJob(writer http.ResponseWriter, request *http.Request) {
+	status, ok := c.miner.CancelJob(mux.Vars(request)["jobId"], "cancelled by request")
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "CancelMiningJob", "Mining job not found")
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, status)
+}
 
-	// Let caller know that we've completed mining and broadcasting
-	sendStandardResponse(writer, http.StatusOK, "Mine", "New block mined and broadcast")
+// broadcastNewBlock gossips a block mined by this node to the other nodes (calls ReceiveNewBlock on
+// them, see gossip.go) and publishes a block_mined event (see events.go). Called by the miner once a
+// mining job started by Mine has created a new block
+func (c *Controller) broadcastNewBlock(newBlock Block) {
+	blockToBroadcast, _ := json.Marshal(newBlock)
+	c.gossip.Publish("/receive-new-block", newBlock.Hash, blockToBroadcast, gossipTTL, "")
+	c.publishBlock(EventBlockMined, newBlock, BlockConnected, "")
 }
 
 // ReceiveNewBlock POST /receive-new-block
-/* Receive and validate a new block. If validated, the new block is accepted, otherwise it is rejected */
+/* Receive and validate a new block. A valid block that extends our chain is appended; a valid block on
+another branch is kept, and the chain is reorganized if that branch now has the most work; a block whose
+parent is unknown is kept as an orphan (202 Accepted) while its parents are fetched from the sending node,
+identified by the X-Node-Url header (see forks.go). Invalid blocks are rejected with 422, which senders
+do not retry (see gossip.go); 500 is only returned when a valid block could not be stored. A gossiped block that
+becomes the tip of our chain is relayed to other nodes (see gossip.go). Accepted blocks, and the orphans
+they connect, are published as block_received events, and reorganizations as chain_reorganized events
+(see events.go) */
 func (c *Controller) ReceiveNewBlock(writer http.ResponseWriter, request *http.Request) {
 	// Receive the new block (note the pattern: ioUtil.ReadAll followed by json.Unmarshal)
 	defer request.Body.Close()
@@ -120,113 +193,449 @@ func (c *Controller) ReceiveNewBlock(writer http.ResponseWriter, request *http.R
 		return
 	}
 	var newBlock Block
-	json.Unmarshal(body, &newBlock)
+	if err = json.Unmarshal(body, &newBlock); err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "ReceiveNewBlock", "Block is not valid JSON")
+		return
+	}
 
-	// Process new block: if validated, add to the blockchain
+	// Process new block: if validated, add to the blockchain (or to a side branch, or the orphan pool)
 	var message string = "New block has been rejected"
 	var statusCode int = http.StatusInternalServerError
-	if c.blockChain.CheckNewBlockHash(newBlock) {
-		c.blockChain.PendingBids = Bids{}
-		c.blockChain.Chain = append(c.blockChain.Chain, newBlock)
-		message = "New block received and accepted"
+	result, err := c.blockChain.AddBlock(newBlock)
+	var status string = result.Status
+	if err == nil && status == BlockOrphan {
+		// Ask the node that sent the block for the blocks we are missing
+		go c.fetchMissingParents(request.Header.Get(nodeUrlHeader), newBlock)
+		message = "New block received: its parent is unknown, fetching it"
+		statusCode = http.StatusAccepted
+	} else if err == nil {
+		message = fmt.Sprintf("New block received and accepted (%s)", status)
 		statusCode = http.StatusOK
+		if status == BlockConnected || status == BlockReorganized {
+			c.propagate(request, "/receive-new-block", newBlock.Hash, body, false)
+		}
+		c.publishAddedBlocks(result, newBlock.Hash, request.Header.Get(nodeUrlHeader))
+	} else if errors.Is(err, ErrBlockRejected) {
+		log.Printf("New block %d rejected: %s", newBlock.Index, err)
+		message = "New block has been rejected: " + err.Error()
+		statusCode = http.StatusUnprocessableEntity
+	} else {
+		log.Printf("Failed to store new block: %s", err)
+		message = "New block could not be stored"
 	}
 	// Send response back with the result of receiving this block
 	sendStandardResponse(writer, statusCode, "ReceiveNewBlock", message)
 }
 
-// RegisterAndBroadcastNode POST /register-and-broadcast-node
-/* When a node comes online, it finds the list of available nodes (how?), and for each node
-calls its RegisterAndBroadcastNode passing itself as the new node. This function:
-1. Will add the incoming node to its list of known nodes
-2. For each node in its list of known nodes, calls RegisterNode passing this incoming node.
-3. Calls RegisterNodesBulk passing all the current nodes of the network to the new node
-Typical input looks like this
-	{
-		"new_node_url":"http://address:port"
+// fetchMissingParents fetches the missing ancestors of an orphan block from the node that sent it,
+// one block at a time, until a block connects to the block tree. Only known nodes are asked, since
+// the sender's url comes from a request header
+func (c *Controller) fetchMissingParents(sender string, orphan Block) {
+	if !c.isKnownNode(sender) {
+		log.Printf("Orphan block %d (%s) came from unknown node %q: not fetching its parents", orphan.Index, orphan.Hash, sender)
+		return
+	}
+	var missingHash string = orphan.PreviousBlockHash
+	for fetched := 0; fetched < maxForkDepth; fetched++ {
+		body, err := doGetCall(sender + "/block/hash/" + url.PathEscape(missingHash))
+		if err != nil {
+			log.Printf("Failed to fetch block %s from node %s: %s", missingHash, sender, err)
+			return
+		}
+		var parent Block
+		if err = json.Unmarshal(body, &parent); err != nil || parent.Hash != missingHash {
+			log.Printf("Node %s did not return block %s", sender, missingHash)
+			return
+		}
+		result, err := c.blockChain.AddBlock(parent)
+		if err != nil {
+			log.Printf("Block %d (%s) from node %s rejected: %s", parent.Index, parent.Hash, sender, err)
+			return
+		}
+		c.publishAddedBlocks(result, parent.Hash, sender)
+		if result.Status != BlockOrphan {
+			return		// Connected: the orphans waiting for it were added too
+		}
+		missingHash = parent.PreviousBlockHash
+	}
+	// We are far behind the sender: sync with it (see sync.go)
+	log.Printf("Orphan block %d (%s) is more than %d blocks away from our chain: syncing with node %s",
+		orphan.Index, orphan.Hash, maxForkDepth, sender)
+	if _, err := c.syncer.Sync([]string{sender}); err != nil && !errors.Is(err, ErrSyncInProgress) {
+		log.Printf("Sync with node %s stopped: %s", sender, err)
 	}
+}
+
+// GetBlockByHash GET /block/hash/{blockHash}
+/* Retrieves a block of any branch known to this node (not only the main chain) by its hash, with its
+confirmation depth (see explorer.go). Nodes use it to fetch the missing parents of orphan blocks.
+Returns 404 if the block is not known. Typical output looks like this:
+{
+	"version": 2,
+	"chain_id": "miniblockchain",
+	"index": 2,
+	"timestamp": 1627204599865209700,
+	"bids": [],
+	"auctions": [],
+	"nonce": 1186942,
+	"difficulty": 20,
+	"merkle_root": "0000000000000000000000000000000000000000000000000000000000000000",
+	"auction_root": "0000000000000000000000000000000000000000000000000000000000000000",
+	"hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+	"previous_block_hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+	"main_chain": true,
+	"confirmations": 3
+}
 */
-func (c *Controller) RegisterAndBroadcastNode(writer http.ResponseWriter, request *http.Request) {
-	// Standard pattern: read request body into a []byte, the convert the []byte to an struct value
-	defer request.Body.Close()
-	body, err := ioutil.ReadAll(request.Body)
+func (c *Controller) GetBlockByHash(writer http.ResponseWriter, request *http.Request) {
+	block, ok := c.blockChain.GetBlockRecord(mux.Vars(request)["blockHash"])
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetBlockByHash", "No block with this hash")
+		return
+	}
+	sendCacheableJsonResponse(writer, request, block)
+}
+
+// GetBlockByIndex GET /block/{index}
+/* Retrieves the block of the main chain with the given index (the genesis block has index 1), with its
+confirmation depth. Same output as GetBlockByHash. Returns 404 if the chain is shorter */
+func (c *Controller) GetBlockByIndex(writer http.ResponseWriter, request *http.Request) {
+	index, err := strconv.Atoi(mux.Vars(request)["index"])
 	if err != nil {
-		log.Printf("Failed to register and broad cast node: %s", err)
-		writer.WriteHeader(http.StatusInternalServerError)
+		sendStandardResponse(writer, http.StatusBadRequest, "GetBlockByIndex", "Block index must be an integer")
+		return
+	}
+	block, ok := c.blockChain.GetBlockByIndex(index)
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetBlockByIndex", "No block with this index")
 		return
 	}
+	sendCacheableJsonResponse(writer, request, block)
+}
+
+// GetTip GET /block/tip
+/* Retrieves the last block of the main chain. Same output as GetBlockByHash (with 1 confirmation).
+Pollers send back the ETag in If-None-Match and get 304 Not Modified until a new block arrives */
+func (c *Controller) GetTip(writer http.ResponseWriter, request *http.Request) {
+	sendCacheableJsonResponse(writer, request, c.blockChain.GetTip())
+}
 
-	var newNode NewNode
-	err = json.Unmarshal(body, &newNode)
+// GetBlocks GET /blocks?from=1&limit=20&order=asc
+/* Retrieves a page of blocks of the main chain, from the block with index from (the genesis block, or
+the tip with order=desc, by default), at most limit (20 by default, at most 100) blocks, in ascending or
+descending order. "next" is the from of the next page, absent on the last page. Typical output looks like this:
+{
+	"tip_index": 3,
+	"blocks": [
+		{ "index": 3, ..., "main_chain": true, "confirmations": 1 },
+		{ "index": 2, ..., "main_chain": true, "confirmations": 2 }
+	],
+	"next": 1
+}
+*/
+func (c *Controller) GetBlocks(writer http.ResponseWriter, request *http.Request) {
+	from, limit, descending, err := parseBlockPageQuery(request)
 	if err != nil {
-		log.Printf("Failed to create new_node_url from body: %v", err)
-		writer.WriteHeader(http.StatusInternalServerError)
+		sendStandardResponse(writer, http.StatusBadRequest, "GetBlocks", err.Error())
+		return
+	}
+	sendCacheableJsonResponse(writer, request, c.blockChain.GetBlocks(from, limit, descending))
+}
+
+// GetBid GET /bid/{bidHash}
+/* Retrieves a bid by hash (as returned in the bid_hash field of bid queries): a confirmed bid of the
+main chain, with its block and confirmation depth, or a pending bid. Returns 404 if the bid is not
+known. Typical output looks like this:
+{
+	"chain_id": "miniblockchain",
+	"bidder_name": "YD",
+	"auction_id": 100,
+	"bid_value": "123.45",
+	"public_key": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+	"sequence": 1,
+	"signature": "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155...",
+	"block_index": 2,
+	"block_hash": "0000mt2VJBoiF2T-Eb3A7ciHKJ4arf6_GPa2_Y7iKjQ=",
+	"position": 0,
+	"bid_hash": "5c1f2e...",
+	"confirmed": true,
+	"confirmations": 2,
+	"timestamp": 1627171722582903400
+}
+*/
+func (c *Controller) GetBid(writer http.ResponseWriter, request *http.Request) {
+	bid, ok := c.blockChain.GetBid(mux.Vars(request)["bidHash"])
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetBid", "No bid with this hash")
+		return
+	}
+	sendCacheableJsonResponse(writer, request, bid)
+}
+
+// GetMempool GET /mempool
+/* Retrieves the size and limits of the mempool, the bids waiting to be mined (see mempool.go).
+Typical output looks like this:
+{
+	"count": 2,
+	"bytes": 524,
+	"auctions": 1,
+	"max_count": 50000,
+	"max_bytes": 16777216,
+	"max_age": "24h0m0s",
+	"oldest_received_at": "2021-07-25T10:15:00.000000000Z"
+}
+*/
+func (c *Controller) GetMempool(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetMempoolStatus())
+}
+
+// GetPendingBids GET /mempool/bids
+/* Retrieves the pending bids in arrival order, with their hash and arrival time. Use
+?auction_id=100 to only get the bids for an auction. Typical output looks like this:
+[
+	{
+		"public_key": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+		"sequence": 1,
+		"bidder_name": "YD",
+		"auction_id": 100,
+		"bid_value": "123.45",
+		"signature": "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155...",
+		"bid_hash": "9f2c4a6b8d0e1f3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a",
+		"received_at": "2021-07-25T10:15:00.000000000Z"
+	}
+]
+*/
+func (c *Controller) GetPendingBids(writer http.ResponseWriter, request *http.Request) {
+	var auctionId *int = nil
+	if value := request.URL.Query().Get("auction_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			sendStandardResponse(writer, http.StatusBadRequest, "GetPendingBids", "auction_id must be an integer")
+			return
+		}
+		auctionId = &id
+	}
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetPendingBidRecords(auctionId))
+}
+
+// GetPendingBidsForAuction GET /auction/{auctionId}/pending-bids
+// Retrieves the pending bids for an auction, like GET /mempool/bids?auction_id={auctionId}
+func (c *Controller) GetPendingBidsForAuction(writer http.ResponseWriter, request *http.Request) {
+	auctionId, err := strconv.Atoi(mux.Vars(request)["auctionId"])
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetPendingBidsForAuction", "Auction id must be an integer")
 		return
 	}
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetPendingBidRecords(&auctionId))
+}
+
+// GetReorgs GET /reorgs
+/* Retrieves the state of the block tree and the last reorganizations of the chain (see forks.go).
+Typical output looks like this:
+{
+	"tip_index": 12,
+	"tip_hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+	"chain_work": "45057",
+	"side_branch_blocks": 1,
+	"orphan_blocks": 0,
+	"reorgs": [
+		{
+			"time": "2021-07-25T10:15:00.000000000Z",
+			"fork_index": 10,
+			"fork_hash": "AAAd0k4Qm1D9vHn3Xb9hVq7YwR2c8Ue5m4sTg6fJ5Zs=",
+			"old_tip_index": 11,
+			"old_tip_hash": "AAAK2mZ8h4Vq1nC7bX0eR5tY9uI3oP6aS2dF8gH1jKw=",
+			"new_tip_index": 12,
+			"new_tip_hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+			"disconnected_blocks": 1,
+			"connected_blocks": 2,
+			"returned_bids": 3,
+			"returned_auction_records": 0
+		}
+	]
+}
+*/
+func (c *Controller) GetReorgs(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetForkStatus())
+}
+
+// isKnownNode checks if a url is the url of a known network node
+func (c *Controller) isKnownNode(nodeUrl string) bool {
+	for _, node := range c.blockChain.GetNetworkNodes() {
+		if node == nodeUrl {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHealth GET /health
+/* Answers the probes of other nodes (see peers.go) with the height and tip of our chain. Typical output:
+{
+	"status": "ok",
+	"node_url": "http://localhost:9000",
+	"chain_height": 12,
+	"tip_hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+	"time": "2021-07-25T10:15:00.000000000Z"
+}
+*/
+func (c *Controller) GetHealth(writer http.ResponseWriter, request *http.Request) {
+	var lastBlock Block = c.blockChain.GetLastBlock()
+	sendJsonResponse(writer, http.StatusOK, NodeHealth{
+		Status:      "ok",
+		NodeUrl:     c.currentNodeUrl,
+		ChainHeight: lastBlock.Index,
+		TipHash:     lastBlock.Hash,
+		Time:        time.Now(),
+	})
+}
+
+// GetPeers GET /peers
+/* Retrieves the known nodes with their health (see peers.go). Typical output looks like this:
+[
+	{
+		"url": "http://localhost:9001",
+		"added_at": "2021-07-25T10:00:00.000000000Z",
+		"last_seen": "2021-07-25T10:15:00.000000000Z",
+		"consecutive_failures": 0,
+		"total_failures": 2,
+		"latency_ms": 1.8,
+		"chain_height": 12,
+		"status": "healthy"
+	}
+]
+*/
+func (c *Controller) GetPeers(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetPeers())
+}
 
-	// We now have the value of the new node. Add it to our list of known nodes
-	if c.blockChain.RegisterNode(newNode.url) {
-		log.Printf("Node '%v' is already registered. Ignoring request", newNode.url)
-		writer.WriteHeader(http.StatusOK)
+// RemovePeer DELETE /peers?url=http://localhost:9001
+/* Removes a node from the known nodes. The node is no longer gossiped to, probed or asked during
+consensus, until it registers again. Returns 404 if the node is not known */
+func (c *Controller) RemovePeer(writer http.ResponseWriter, request *http.Request) {
+	var nodeUrl string = request.URL.Query().Get("url")
+	if nodeUrl == "" {
+		sendStandardResponse(writer, http.StatusBadRequest, "RemovePeer", "url is required")
 		return
 	}
+	if !c.blockChain.RemoveNode(nodeUrl) {
+		sendStandardResponse(writer, http.StatusNotFound, "RemovePeer", fmt.Sprintf("Node %s is not known", nodeUrl))
+		return
+	}
+	log.Printf("Node %s removed by request", nodeUrl)
+	sendStandardResponse(writer, http.StatusOK, "RemovePeer", fmt.Sprintf("Node %s removed", nodeUrl))
+}
 
-	// Broadcast this new node to our list of known nodes (call register-node api point on each
-	// known node passing in body)
-	c.broadcastToAllNodes("/register-node", body)
+// RegisterAndBroadcastNode POST /register-and-broadcast-node
+/* Called by a node that joins the network, with its handshake (see join.go). This function:
+1. Checks the handshake: a valid public url, the same protocol version, chain id and genesis hash, and not
+this node
+2. Adds the new node to its list of known nodes
+3. If the node was not known, calls RegisterNode on each known node passing the handshake
+4. Answers with its own handshake and its other known nodes, which the new node handshakes
+If the new node's chain is higher than ours, this node syncs with it (see sync.go).
+Joining again is harmless. Refused handshakes get 400 (invalid url), 409 (other protocol version, chain or
+genesis block) or 422 (the node itself). Typical input looks like this
+	{
+		"node_id": "5b0f8c7e2a9d4c1e8f3a6b2d9c0e7f41",
+		"protocol_version": 1,
+		"chain_id": "miniblockchain",
+		"genesis_hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+		"public_url": "http://localhost:9001",
+		"chain_height": 1
+	}
+and typical output
+	{
+		"node": {
+			"node_id": "9e2d4a7c1b8f3e6d0a5c2b9f8e1d7a34",
+			"protocol_version": 1,
+			"chain_id": "miniblockchain",
+			"genesis_hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+			"public_url": "http://localhost:9000",
+			"chain_height": 12
+		},
+		"peers": ["http://localhost:9002"]
+	}
+*/
+func (c *Controller) RegisterAndBroadcastNode(writer http.ResponseWriter, request *http.Request) {
+	// Standard pattern: read request body into a []byte, the convert the []byte to an struct value
+	newNode, ok := c.readNodeInfo(writer, request, "RegisterAndBroadcastNode")
+	if !ok {
+		return
+	}
 
-	// Get a list of our  known nodes and send back to the new node
-	knownNodes := make([]string, len(c.blockChain.NetworkNodes))
-	i := 0
-	for node ,_ := range c.blockChain.NetworkNodes {
-		knownNodes[i] = node
-		i++
+	// We now have the value of the new node. Add it to our list of known nodes, and broadcast it to our
+	// list of known nodes (call register-node api point on each known node) if it is new. The calls are
+	// queued, not waited for
+	if c.blockChain.RegisterPeer(newNode) {
+		log.Printf("Node %s joined the network (chain height %d)", newNode.PublicUrl, newNode.ChainHeight)
+		body, _ := json.Marshal(newNode)
+		c.gossip.SendToAll("/register-node", body, newNode.PublicUrl)
 	}
-	knownNodes[i] = c.currentNodeUrl
-	payload, _ :=  json.Marshal(knownNodes)
-	doPostCall( newNode.url + "/register-nodes-bulk", payload)
+	c.syncWithPeersAhead(newNode)
 
-	// Send standard response
-	sendStandardResponse(writer, http.StatusOK, "RegisterAndBroadcastNode", "Node registered successfully")
+	// Send back our handshake and our other known nodes
+	var response JoinResponse = JoinResponse{Node: c.nodeInfo(), Peers: []string{}}
+	for _, node := range c.blockChain.GetNetworkNodes() {
+		if node != newNode.PublicUrl {
+			response.Peers = append(response.Peers, node)
+		}
+	}
+	sendJsonResponse(writer, http.StatusOK, response)
 }
 
 // RegisterNode POST /register-node
-/* When a new node comes online it calls RegisterAndBroadcastNode passing itself as the new node.
+/* When a new node comes online it calls RegisterAndBroadcastNode passing its handshake.
 RegisterAndBroadcastNode on the callee adds the incoming node to the callee's list of known nodes,
-and then for each node known to the callee, calls RegisterNode passing this incoming node  */
+and then for each node known to the callee, calls RegisterNode passing this handshake. The handshake
+is checked as in RegisterAndBroadcastNode; the answer is the handshake of this node */
 func (c *Controller) RegisterNode(writer http.ResponseWriter, request *http.Request) {
-	defer request.Body.Close()
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		log.Printf("Failed to register and broad cast node: %s", err)
-		writer.WriteHeader(http.StatusInternalServerError)
+	newNode, ok := c.readNodeInfo(writer, request, "RegisterNode")
+	if !ok {
 		return
 	}
+	if c.blockChain.RegisterPeer(newNode) {
+		log.Printf("Node %s was registered", newNode.PublicUrl)
+	}
+	c.syncWithPeersAhead(newNode)
+	sendJsonResponse(writer, http.StatusOK, c.nodeInfo())
+}
 
-	var newNode NewNode
-	err = json.Unmarshal(body, &newNode)
+// readNodeInfo reads and checks the handshake in the body of a request, then checks that the node
+// really is at the url it claims (see verifyNode), so that no url is added or broadcast on hearsay. If
+// the handshake is not valid, an error response is sent and false is returned
+func (c *Controller) readNodeInfo(writer http.ResponseWriter, request *http.Request, methodName string) (NodeInfo, bool) {
+	defer request.Body.Close()
+	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
-		log.Printf("Failed to create new_node_url from body: %v", err)
+		log.Printf("%s error: %s", methodName, err)
 		writer.WriteHeader(http.StatusInternalServerError)
-		return
+		return NodeInfo{}, false
 	}
 
-	isRegistered := c.blockChain.RegisterNode(newNode.url)
-	var statusMessage string
-	if isRegistered {
-		statusMessage = fmt.Sprintf("Node %s was registereded sucessfully", newNode.url)
-	} else {
-		statusMessage = fmt.Sprintf("Node %s is already registered. No action taken", newNode.url)
+	var newNode NodeInfo
+	if err = json.Unmarshal(body, &newNode); err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, methodName, "Handshake is not valid JSON")
+		return NodeInfo{}, false
 	}
-
-	sendStandardResponse(writer, http.StatusOK, "RegisterNode", statusMessage)
+	if newNode, err = c.checkNodeInfo(newNode); err == nil {
+		newNode, err = c.verifyNode(newNode)
+	}
+	if err != nil {
+		log.Printf("%s refused: %s", methodName, err)
+		sendStandardResponse(writer, handshakeStatus(err), methodName, err.Error())
+		return NodeInfo{}, false
+	}
+	return newNode, true
 }
 
 // RegisterNodesBulk POST /register-nodes-bulk
-/* When a new node comes online it calls RegisterAndBroadcastNode passing itself as the new node.
-RegisterAndBroadcastNode will process the request and then it will call RegisterNodesBulk on the
-new node passing all the current nodes of the network to the new node. This ensures that the new
-node knows about all other nodes in the network */
+/* Registers a list of node urls, i.e. the nodes of the network given to a new node. Each node that is
+not known yet is sent our handshake and only added if its answer is accepted (see join.go). Registering
+the same nodes again has no effect; this node, invalid urls and nodes that fail the handshake are
+skipped. Typical input looks like this
+	["http://localhost:9001", "http://localhost:9002"]
+*/
 func (c *Controller) RegisterNodesBulk(writer http.ResponseWriter, request *http.Request) {
 	defer request.Body.Close()
 	body, err := ioutil.ReadAll(request.Body)
@@ -239,103 +648,416 @@ func (c *Controller) RegisterNodesBulk(writer http.ResponseWriter, request *http
 	var nodes []string
 	err = json.Unmarshal(body, &nodes)
 	if err != nil {
-		log.Printf("Failed to nodes from body: %v", err)
-		writer.WriteHeader(http.StatusInternalServerError)
+		sendStandardResponse(writer, http.StatusBadRequest, "RegisterNodesBulk", "Body must be a JSON list of node urls")
 		return
 	}
 
-	for _, node := range nodes {
-		if node != c.currentNodeUrl {
-			c.blockChain.RegisterNode(node)
-		}
-	}
-
+	added, known, skipped := c.registerNodeUrls(nodes)
 	sendStandardResponse(writer, http.StatusOK, "RegisterNodesBulk",
-		"Nodes registered successfully")
+		fmt.Sprintf("%d nodes registered, %d already known, %d skipped", added, known, skipped))
 }
 
 // Consensus GET /consensus
 /* Consensus ensures that this node - and then all the network — have the same chains,
-with the same bets: The network which contains the longest chain keeps it, forcing the
-other to drop its chain and get the new one*/
+with the same bets: The network which contains the chain with the most work (see ChainWork) keeps it,
+forcing the other to switch to it. The chains of the other nodes are synced headers first (see sync.go):
+only the headers after the last block we have in common are fetched and checked, then the missing blocks
+are downloaded from several nodes and added like received blocks. Like a reorganization, bids of our
+blocks that are not in the new chain go back to the pending bids, and the pending bids of the node whose
+chain we switched to are added to ours, checked like received bids. Chains that fail validation are
+ignored, and nodes that cannot be reached are skipped. A replaced chain is published as a
+chain_replaced event (see events.go). Returns 409 if a sync is already running. Typical output looks like this:
+{
+	"Name": "Consensus",
+	"Status": "Chain replaced with the valid chain with the most work",
+	"Time": "2021-07-25T10:15:00.000000000Z",
+	"replaced": true,
+	"source_node": "http://localhost:9001",
+	"chain_length": 4,
+	"blocks_downloaded": 3,
+	"pending_bids_added": 2
+}
+*/
 func (c *Controller) Consensus(writer http.ResponseWriter, request *http.Request) {
+	var oldTip Block = c.blockChain.GetLastBlock()
+	status, err := c.syncer.Sync(c.blockChain.GetNetworkNodes())
+	if errors.Is(err, ErrSyncInProgress) {
+		sendStandardResponse(writer, http.StatusConflict, "Consensus", "A sync is already running: see GET /sync")
+		return
+	}
 
-	// Iterate over all nodes, getting each node's blockchain and measuring its length
-	// to identify the longest chain
-	for key, _ := range c.blockChain.NetworkNodes {
-		// Ignore this node
-		if key == c.currentNodeUrl {
-			continue
+	var newTip Block = c.blockChain.GetLastBlock()
+	var response ConsensusResponse = ConsensusResponse{
+		ApiResponse:      ApiResponse{Name: "Consensus", Status: "Current chain has not been replaced", Time: time.Now()},
+		Replaced:         status.Replaced,
+		SourceNode:       "",
+		ChainLength:      newTip.Index,
+		BlocksDownloaded: status.BlocksDownloaded,
+		PendingBidsAdded: status.PendingBidsAdded,
+	}
+	if response.Replaced {
+		response.Status = "Chain replaced with the valid chain with the most work"
+		response.SourceNode = status.Peer
+		c.events.Publish(EventChainReplaced, []int{}, []string{}, ChainReplacedEvent{
+			SourceNode:  status.Peer,
+			ForkIndex:   status.ForkIndex,
+			OldTipIndex: oldTip.Index,
+			OldTipHash:  oldTip.Hash,
+			NewTipIndex: newTip.Index,
+			NewTipHash:  newTip.Hash,
+		})
+		var chain Blocks = c.blockChain.getMainChain()
+		if status.ForkIndex < len(chain) {
+			c.publishClosedAuctions(chain[status.ForkIndex:])
 		}
+	}
+	if err != nil {
+		// Blocks added before the sync stopped are kept: the next consensus resumes from there
+		log.Printf("Sync with node %s stopped: %s", status.Peer, err)
+		response.Status = fmt.Sprintf("Sync with node %s stopped: %s", status.Peer, err)
+	}
+	sendJsonResponse(writer, http.StatusOK, response)
+}
 
-		// Call /blockchain on the current node
-		requestUrl, _ := url.Parse(key + "/blockchain")
-		request := &http.Request{
-			Method: "GET",
-			URL:    requestUrl,				// URL *url.URL
-			Header: http.Header{ 			// type Header map[string][]string
-				"Content-Type": {"application/json"},
-			},
+// GetHeaders GET /sync/headers?from=1&count=2000
+/* Retrieves the headers of the blocks of our main chain from index from (1 by default), at most count
+(and at most 2000) of them, with the tip of our chain. Other nodes sync with it (see sync.go). Typical
+output looks like this:
+{
+	"chain_id": "miniblockchain",
+	"tip_index": 2,
+	"tip_hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+	"headers": [
+		{
+			"version": 2,
+			"chain_id": "miniblockchain",
+			"index": 2,
+			"timestamp": 1627204599865209700,
+			"previous_block_hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+			"merkle_root": "0000000000000000000000000000000000000000000000000000000000000000",
+			"auction_root": "0000000000000000000000000000000000000000000000000000000000000000",
+			"difficulty": 20,
+			"nonce": 1186942,
+			"hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E="
 		}
+	]
+}
+*/
+func (c *Controller) GetHeaders(writer http.ResponseWriter, request *http.Request) {
+	from, count, err := parseHeadersQuery(request)
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetHeaders", err.Error())
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetHeaders(from, count))
+}
 
-		response, err := http.DefaultClient.Do(request)
-		if err != nil {
-			log.Printf("Failed to call /blocchain on node %s. Error: %s", key, err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		defer response.Body.Close()
+// GetSyncBlocks GET /sync/blocks?hash=...&hash=...
+/* Retrieves the blocks of any known branch with the given hashes (at most 100), in the requested order.
+Unknown hashes are skipped. Other nodes download the blocks they sync with it (see sync.go) */
+func (c *Controller) GetSyncBlocks(writer http.ResponseWriter, request *http.Request) {
+	var hashes []string = request.URL.Query()["hash"]
+	if len(hashes) > maxBlocksPerRequest {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetSyncBlocks",
+			fmt.Sprintf("At most %d blocks can be requested at once", maxBlocksPerRequest))
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetBlocksByHash(hashes))
+}
 
-		// Process response from node which is the node's blockchain
-		body, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			log.Printf("Failed to call /blocchain on node %s. Error: %s", key, err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		var blockChain *BlockChain
-		err = json.Unmarshal(body, blockChain)
-		if err != nil {
-			log.Printf("Failed to process response from  node %s. Error: %s", key, err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+// GetSyncStatus GET /sync
+/* Retrieves the progress of the running sync, or the outcome of the last one (see sync.go). Typical
+output looks like this:
+{
+	"running": true,
+	"peer": "http://localhost:9001",
+	"target_index": 2400,
+	"target_hash": "AAAHbF2r3kX0Mvq5nOa3e4bXH2bV7N1qR3cT8c7Ww0E=",
+	"fork_index": 12,
+	"headers": 2388,
+	"blocks_downloaded": 1000,
+	"blocks_added": 500,
+	"cached_blocks": 500,
+	"started_at": "2021-07-25T10:15:00.000000000Z"
+}
+*/
+func (c *Controller) GetSyncStatus(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.syncer.GetStatus())
+}
 
-		// Get length of this chain, and update maximum length if necessary
+// Index GET /
+/* Answers with the handshake of this node (see join.go), which nodes use to check that a node claiming
+a url really is at that url. Typical output:
+{
+	"node_id": "9e2d4a7c1b8f3e6d0a5c2b9f8e1d7a34",
+	"protocol_version": 1,
+	"chain_id": "miniblockchain",
+	"genesis_hash": "03TUQ48qxRCu0ewfYbW2OjAB7qJTONC9K656t2VKpzY=",
+	"public_url": "http://localhost:9000",
+	"chain_height": 12
+}
+*/
+func (c *Controller) Index(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.nodeInfo())
+}
 
+// GetBidsForAuction GET /auction/{auctionId} retrieves all bids for an auction
+/* Confirmed bids are returned with the index and hash of their block and their position in
+that block. The following optional query parameters are supported:
+	include_pending=true	also return pending bids (not yet in a block)
+	sort=time|value			sort by block time (the default) or by bid value
+	order=asc|desc			sort order (ascending is the default)
+	page=1&page_size=50		page to return
+Typical output looks like this:
+{
+	"total": 1,
+	"page": 1,
+	"page_size": 50,
+	"bids": [
+		{
+			"public_key": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+			"sequence": 1,
+			"bidder_name": "YD",
+			"auction_id": 100,
+			"bid_value": "123.45",
+			"signature": "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e06522490155...",
+			"block_index": 2,
+			"block_hash": "0000mt2VJBoiF2T-Eb3A7ciHKJ4arf6_GPa2_Y7iKjQ=",
+			"position": 0,
+			"confirmed": true,
+			"confirmations": 1,
+			"timestamp": 1627171722582903400
+		}
+	]
+}
+*/
+func (c *Controller) GetBidsForAuction(writer http.ResponseWriter, request *http.Request) {
+	auctionId, err := strconv.Atoi(mux.Vars(request)["auctionId"])
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetBidsForAuction", "Auction id must be an integer")
+		return
+	}
+	query, err := parseBidQuery(request)
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetBidsForAuction", err.Error())
+		return
+	}
 
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetBidsForAuction(auctionId, query))
+}
 
+// GetBidsForPlayer GET /player/{playerId} retrieves all bids of a player (the bidder's public key).
+// Supports the same query parameters and output as GetBidsForAuction
+func (c *Controller) GetBidsForPlayer(writer http.ResponseWriter, request * http.Request) {
+	var playerId string = mux.Vars(request)["playerId"]
+	query, err := parseBidQuery(request)
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetBidsForPlayer", err.Error())
+		return
+	}
 
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetBidsForPlayer(playerId, query))
+}
 
+// GetBidProof GET /bid/{bidHash}/proof
+/* Retrieves a Merkle inclusion proof for a confirmed bid, given the bid's hash (as returned in the
+bid_hash field of bid queries). The proof can be checked with VerifyMerkleProof, without the rest of
+the chain: combining the bid hash with each hash of the path (the sibling is on the left when "left"
+is true) must give the merkle root of the block. Typical output looks like this:
+{
+	"bid_hash": "5c1f2e...",
+	"block_index": 2,
+	"block_hash": "AAAJmYrSOy-TVThMWM2u-I05MdRyEtKIDZMGQfpsSDU=",
+	"merkle_root": "9b7d0a...",
+	"position": 1,
+	"path": [
+		{ "hash": "e3b0c4...", "left": true },
+		{ "hash": "4f53cd...", "left": false }
+	]
+}
+*/
+func (c *Controller) GetBidProof(writer http.ResponseWriter, request *http.Request) {
+	proof, ok := c.blockChain.GetMerkleProof(mux.Vars(request)["bidHash"])
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetBidProof", "No confirmed bid with this hash")
+		return
 	}
+	sendJsonResponse(writer, http.StatusOK, proof)
 }
 
-// Index GET/
-func (c *Controller) Index(writer http.ResponseWriter, request *http.Request) {
+// RegisterAndBroadcastAuctionRecord POST /auction/broadcast
+/* Register an auction record in current blockchain and transmit to all nodes in the network. Records are
+signed by the seller (see SignAuctionRecord); times are Unix times in nanoseconds. The settlement rule is
+"first-price" (the default) or "second-price"; a hidden reserve price is given as "reserve_commitment"
+instead of "reserve_price", and revealed by the close record with "reserve_price" and "reserve_salt". The
+optional "currency" (ISO 4217 code) is the currency of the reserve price and of all bids.
+Invalid records are rejected with 422. Typical body input to create an auction:
+{
+	"type": "create",
+	"auction_id": 100,
+	"seller": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+	"item": "Signed football",
+	"rule": "second-price",
+	"currency": "EUR",
+	"reserve_price": "50.00 EUR",
+	"open_time": 1627171722582903400,
+	"close_time": 1627258122582903400,
+	"signature": "9a1e07c3f1b4b5f0d6a1c2b3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3..."
+}
+and to close it, once close_time is past:
+{
+	"type": "close",
+	"auction_id": 100,
+	"seller": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+	"signature": "51d2f4a6b8c0e2f4a6b8c0d2e4f6a8b0c2d4e6f8a0b2c4d6e8f0a2b4c6d8e0f2..."
+}
+*/
+func (c *Controller) RegisterAndBroadcastAuctionRecord(writer http.ResponseWriter, request *http.Request) {
+	c.registerAuctionRecordImp(writer, request, true)		// Broadcast
+}
 
+// RegisterAuctionRecord POST /auction
+// Registers an auction record locally but does not transmit it (see RegisterAndBroadcastAuctionRecord)
+func (c *Controller) RegisterAuctionRecord(writer http.ResponseWriter, request *http.Request) {
+	c.registerAuctionRecordImp(writer, request, false)		// Do not broadcast
 }
 
-// GetBidsForAuction GET /auction/{auctionId} retrieves all bids for an auction
-func (c *Controller) GetBidsForAuction(writer http.ResponseWriter, request *http.Request) {
+// GetAuction GET /auction/{auctionId}/state
+/* Retrieves the state of an auction: the record that created it, its status (scheduled, open, revealing,
+ended or closed), the number of bids, the leading bid and, once closed, the winning bid. There is no
+winning bid when no bid reached the reserve price. For sealed auctions, only revealed bids count and
+commitment_count gives the number of commitments. Typical output looks like this:
+{
+	"auction": {
+		"type": "create",
+		"auction_id": 100,
+		"seller": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+		"item": "Signed football",
+		"currency": "EUR",
+		"reserve_price": "50.00 EUR",
+		"open_time": 1627171722582903400,
+		"close_time": 1627258122582903400,
+		"signature": "9a1e07c3f1b4b5f0d6a1c2b3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3..."
+	},
+	"status": "closed",
+	"created_in_block": 2,
+	"closed_in_block": 9,
+	"bid_count": 3,
+	"leading_bid": { "bidder_name": "YD", "auction_id": 100, "bid_value": "123.45 EUR", ... },
+	"winning_bid": { "bidder_name": "YD", "auction_id": 100, "bid_value": "123.45 EUR", ... }
+}
+*/
+func (c *Controller) GetAuction(writer http.ResponseWriter, request *http.Request) {
+	auctionId, err := strconv.Atoi(mux.Vars(request)["auctionId"])
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetAuction", "Auction id must be an integer")
+		return
+	}
+	auction, ok := c.blockChain.GetAuction(auctionId)
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetAuction", "No auction with this id in the chain")
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, auction)
+}
 
+// GetAuctionSettlement GET /auction/{auctionId}/settlement
+/* Retrieves the settlement of a closed auction, as computed by the auction's settlement rule: the winning
+bid and the clearing price (the price the winner pays). There is no winning bid when no bid reached the
+reserve price. Returns 409 while the auction is not closed. Typical output looks like this:
+{
+	"auction_id": 100,
+	"rule": "second-price",
+	"closed_in_block": 9,
+	"bid_count": 3,
+	"reserve_price": "50.00 EUR",
+	"winning_bid": { "bidder_name": "YD", "auction_id": 100, "bid_value": "123.45 EUR", ... },
+	"clearing_price": "110.00 EUR"
+}
+*/
+func (c *Controller) GetAuctionSettlement(writer http.ResponseWriter, request *http.Request) {
+	auctionId, err := strconv.Atoi(mux.Vars(request)["auctionId"])
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetAuctionSettlement", "Auction id must be an integer")
+		return
+	}
+	auction, ok := c.blockChain.GetAuction(auctionId)
+	if !ok {
+		sendStandardResponse(writer, http.StatusNotFound, "GetAuctionSettlement", "No auction with this id in the chain")
+		return
+	}
+	if auction.Settlement == nil {
+		sendStandardResponse(writer, http.StatusConflict, "GetAuctionSettlement", "Auction is not closed yet")
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, auction.Settlement)
 }
 
-// GetBidsForPlayer GET /player/{playerId}
-func (c *Controller) GetBidsForPlayer(writer http.ResponseWriter, request * http.Request) {
+// GetAuctions GET /auctions retrieves the state of all auctions in the chain (see GetAuction)
+func (c *Controller) GetAuctions(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.blockChain.GetAuctions())
+}
 
+// GetGossipMetrics GET /gossip
+/* Retrieves the counters of the gossip layer (see gossip.go). Typical output looks like this:
+{
+	"fanout": 4,
+	"ttl": 6,
+	"workers": 8,
+	"queue_length": 0,
+	"queue_capacity": 1024,
+	"seen_messages": 42,
+	"published": 40,
+	"duplicates": 12,
+	"sent": 118,
+	"failed": 3,
+	"retried": 2,
+	"dropped": 0,
+	"peers": {
+		"http://localhost:9001": {
+			"sent": 60,
+			"failed": 0,
+			"average_latency_ms": 2.4,
+			"last_sent_at": "2021-07-25T10:15:00.000000000Z"
+		},
+		"http://localhost:9002": {
+			"sent": 58,
+			"failed": 3,
+			"average_latency_ms": 3.1,
+			"last_sent_at": "2021-07-25T10:14:58.000000000Z",
+			"last_error": "unexpected status 503"
+		}
+	}
+}
+*/
+func (c *Controller) GetGossipMetrics(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.gossip.GetMetrics())
 }
 
 /* Helpers */
-func (c *Controller) broadcastToAllNodes(api string, body []byte) {
-	for key, _ := range c.blockChain.NetworkNodes {
-		if key != c.currentNodeUrl {
-			doPostCall(key + api, body)
+
+// propagate hands a message accepted by this node to the gossip layer. A message submitted to a
+// broadcast api point (publish) gets the full TTL; a message received from another node is relayed
+// with one hop less, and not at all if it was not gossiped (no X-Gossip-Ttl header)
+func (c *Controller) propagate(request *http.Request, api string, hash string, body []byte, publish bool) {
+	var ttl int = gossipTTL
+	if !publish {
+		receivedTTL, err := strconv.Atoi(request.Header.Get(gossipTTLHeader))
+		if err != nil {
+			return
 		}
+		ttl = receivedTTL - 1
 	}
+	c.gossip.Publish(api, hash, body, ttl, request.Header.Get(nodeUrlHeader))
+}
+
+// isGossipDuplicate checks if a request is a gossiped message that this node has already seen
+func (c *Controller) isGossipDuplicate(request *http.Request, hash string) bool {
+	return request.Header.Get(gossipTTLHeader) != "" && c.gossip.Seen(hash)
 }
 
 // Creates a Bid object from the body and adds the bid to the blockchain. The bid is conditionally
-// broadcast to all other registered nodes
+// broadcast to other nodes, or relayed if it was gossiped to this node (see propagate)
 func (c *Controller) registerBidImp(writer http.ResponseWriter, request *http.Request, shouldBroadCast bool) {
 	// Read body from request and check for errors
 	defer request.Body.Close()
@@ -346,27 +1068,125 @@ func (c *Controller) registerBidImp(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	// Parse the bid (in json) and convert to Bid object
+	// Parse the bid (in json) and convert to Bid object. Unknown fields are refused, as they would be
+	// silently dropped from the bid
 	var bid Bid
-	err = json.Unmarshal(body, &bid)
-	if err != nil {
+	var decoder *json.Decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&bid); err != nil {
 		log.Printf("RegisterAndBroadcastBid error: %s", err)
-		writer.WriteHeader(http.StatusUnprocessableEntity)
+		sendStandardResponse(writer, http.StatusUnprocessableEntity, "RegisterAndBroadcastBid", "Bid is not valid: "+err.Error())
+		return
+	}
+	if c.isGossipDuplicate(request, bid.Hash()) {
+		sendStandardResponse(writer, http.StatusOK, "RegisterAndBroadcastBid", "Bid was already received")
 		return
 	}
 
-	// We have a Bid object. Register it in the blockchain
-	c.blockChain.RegisterBid(bid)
-
-	// Broadcast to all other available nodes
-	if shouldBroadCast {
-		c.broadcastToAllNodes("/bid", body)
+	// We have a Bid object. Register it in the blockchain. A bid we already have is not an error
+	// (nodes receive the same bid from several peers), but it is not broadcast again
+	if err = c.blockChain.RegisterBid(bid); err != nil {
+		switch {
+		case errors.Is(err, ErrDuplicateBid):
+			sendStandardResponse(writer, http.StatusOK, "RegisterAndBroadcastBid", "Bid is already pending")
+		case errors.Is(err, ErrInvalidBid):
+			log.Printf("RegisterAndBroadcastBid error: %s", err)
+			sendStandardResponse(writer, http.StatusUnprocessableEntity, "RegisterAndBroadcastBid", err.Error())
+		case errors.Is(err, ErrMempoolFull):
+			log.Printf("RegisterAndBroadcastBid error: %s", err)
+			sendStandardResponse(writer, http.StatusServiceUnavailable, "RegisterAndBroadcastBid", err.Error())
+		default:
+			log.Printf("RegisterAndBroadcastBid error: %s", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+		return
 	}
 
+	// Gossip to other nodes. The bid is sent in the background: the caller does not wait for them
+	c.propagate(request, "/bid", bid.Hash(), body, shouldBroadCast)
+	c.publishBid(bid)
+
 	// Return success to caller
 	sendStandardResponse(writer, http.StatusCreated, "RegisterAndBroadcastBid", "Bid created and broadcast successfully")
 }
 
+// Creates an AuctionRecord object from the body and adds the record to the blockchain. The record is
+// conditionally broadcast to other nodes, or relayed if it was gossiped to this node (see propagate)
+func (c *Controller) registerAuctionRecordImp(writer http.ResponseWriter, request *http.Request, shouldBroadCast bool) {
+	defer request.Body.Close()
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		log.Printf("RegisterAndBroadcastAuctionRecord error: %s", err)
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var record AuctionRecord
+	err = json.Unmarshal(body, &record)
+	if err != nil {
+		log.Printf("RegisterAndBroadcastAuctionRecord error: %s", err)
+		sendStandardResponse(writer, http.StatusUnprocessableEntity, "RegisterAndBroadcastAuctionRecord", "Auction record is not valid: "+err.Error())
+		return
+	}
+	if c.isGossipDuplicate(request, record.Hash()) {
+		sendStandardResponse(writer, http.StatusOK, "RegisterAndBroadcastAuctionRecord", "Auction record was already received")
+		return
+	}
+
+	if err = c.blockChain.RegisterAuctionRecord(record); err != nil {
+		log.Printf("RegisterAndBroadcastAuctionRecord error: %s", err)
+		if errors.Is(err, ErrInvalidAuctionRecord) {
+			sendStandardResponse(writer, http.StatusUnprocessableEntity, "RegisterAndBroadcastAuctionRecord", err.Error())
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.propagate(request, "/auction", record.Hash(), body, shouldBroadCast)
+
+	sendStandardResponse(writer, http.StatusCreated, "RegisterAndBroadcastAuctionRecord", "Auction record created and broadcast successfully")
+}
+
+// parseBidQuery reads the options of a bid query from the query string of the request
+func parseBidQuery(request *http.Request) (BidQuery, error) {
+	var values url.Values = request.URL.Query()
+	var query BidQuery
+	var err error
+
+	if value := values.Get("include_pending"); value != "" {
+		if query.IncludePending, err = strconv.ParseBool(value); err != nil {
+			return query, fmt.Errorf("include_pending must be true or false")
+		}
+	}
+
+	query.SortBy = values.Get("sort")
+	if query.SortBy != "" && query.SortBy != SortBidsByTime && query.SortBy != SortBidsByValue {
+		return query, fmt.Errorf("sort must be %q or %q", SortBidsByTime, SortBidsByValue)
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+		query.Descending = false
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("order must be \"asc\" or \"desc\"")
+	}
+
+	if value := values.Get("page"); value != "" {
+		if query.Page, err = strconv.Atoi(value); err != nil || query.Page < 1 {
+			return query, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if value := values.Get("page_size"); value != "" {
+		if query.PageSize, err = strconv.Atoi(value); err != nil || query.PageSize < 1 {
+			return query, fmt.Errorf("page_size must be a positive integer")
+		}
+	}
+	return query, nil
+}
+
 // sendStandardResponse sends a standard response from all controller api methods: send a content type,
 // a status, and a ApiResponse object with additional data
 func sendStandardResponse(writer http.ResponseWriter, statusCode int, methodName string, message string) {
@@ -381,9 +1201,34 @@ func sendStandardResponse(writer http.ResponseWriter, statusCode int, methodName
 	writer.Write(data)
 }
 
+// sendJsonResponse sends any object as JSON with the given status code. Used by api methods
+// that need to return more than the fields of ApiResponse
+func sendJsonResponse(writer http.ResponseWriter, statusCode int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	writer.WriteHeader(statusCode)
+	data, _ := json.Marshal(value)
+	writer.Write(data)
+}
+
+// Do a get call to the given url and return the body of the response. Typically used to
+// query other nodes, for example to get their blockchain during consensus
+func doGetCall(url string) ([]byte, error) {
+	response, err := nodeClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", response.StatusCode, url)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
 // Do a post call to the given url. Typically used to inform other nodes of interesting changes
-// such as a new block or a new node
-func doPostCall(url string, body []byte) error {
+// such as a new block or a new node. senderUrl (the url of this node) is sent in the X-Node-Url
+// header, so that the receiving node can call back (i.e., to fetch the parents of an orphan block)
+func doPostCall(url string, body []byte, senderUrl string) error {
 	contentType := "application/json;charset=UTF-8"
 
 	/* A Buffer is a variable-sized buffer of bytes with Read and Write methods.
@@ -396,7 +1241,14 @@ func doPostCall(url string, body []byte) error {
 	A Buffer instance can therefore be used as an io.Reader in http.Post
 	*/
 	var buffer *bytes.Buffer = bytes.NewBuffer(body)
-	response, err := http.Post(url, contentType, buffer)
+	request, err := http.NewRequest(http.MethodPost, url, buffer)
+	if err != nil {
+		log.Printf("Failed to POST call to %s: %s", url,  err)
+		return err
+	}
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set(nodeUrlHeader, senderUrl)
+	response, err := nodeClient.Do(request)
 	if err != nil {
 		log.Printf("Failed to POST call to %s: %s", url,  err)
 		return err
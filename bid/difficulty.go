@@ -0,0 +1,176 @@
+/* Proof of work difficulty. The difficulty of a block is the number of leading zero bits that the
+SHA-256 hash of the block must have: each extra bit doubles the average number of hashes needed to
+mine the block. The difficulty is stored in each block (and covered by the block's hash) and every
+node checks it, so a block mined with a lower difficulty than required is rejected.
+
+The required difficulty is retargeted every RetargetInterval blocks: the time it took to mine the last
+RetargetInterval blocks (taken from the Timestamp of the blocks) is compared with the time it should
+have taken at TargetBlockTime per block, and the difficulty goes up when blocks came too fast and down
+when they came too slow. The rule only uses data stored in the chain, so every node computes the same
+difficulty for the same chain, as long as every node uses the same rules. The rules are therefore part of
+the genesis configuration (see genesis.go) and the genesis block commits to them (see
+DifficultyConfig.Hash): nodes with other rules have another genesis hash, and refuse each other's
+handshake */
+package bid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// DifficultyConfig configures the proof of work difficulty (in leading zero bits) of mined blocks
+type DifficultyConfig struct {
+	// InitialDifficulty is the difficulty of the first blocks after the genesis block
+	InitialDifficulty int `json:"initial_difficulty"`
+	// MinDifficulty and MaxDifficulty bound the difficulty after retargeting
+	MinDifficulty int `json:"min_difficulty"`
+	MaxDifficulty int `json:"max_difficulty"`
+	// TargetBlockTime is the average time between blocks that retargeting aims for (in nanoseconds in
+	// a genesis file)
+	TargetBlockTime time.Duration `json:"target_block_time"`
+	// RetargetInterval is the number of blocks between two difficulty adjustments
+	RetargetInterval int `json:"retarget_interval"`
+	// Fixed disables retargeting: every block uses InitialDifficulty. Used in test mode
+	Fixed bool `json:"fixed"`
+}
+
+// DefaultDifficultyConfig returns the difficulty settings used by a node unless configured otherwise:
+// around a million hashes per block to start with, retargeted every 10 blocks toward 10 seconds per block
+func DefaultDifficultyConfig() DifficultyConfig {
+	return DifficultyConfig{
+		InitialDifficulty: 20,
+		MinDifficulty:     8,
+		MaxDifficulty:     48,
+		TargetBlockTime:   10 * time.Second,
+		RetargetInterval:  10,
+		Fixed:             false,
+	}
+}
+
+// TestDifficultyConfig returns a fixed, very low difficulty so that blocks are mined in a few
+// microseconds. Used for tests and local experiments
+func TestDifficultyConfig() DifficultyConfig {
+	return DifficultyConfig{
+		InitialDifficulty: 4,
+		MinDifficulty:     4,
+		MaxDifficulty:     4,
+		TargetBlockTime:   time.Second,
+		RetargetInterval:  10,
+		Fixed:             true,
+	}
+}
+
+// maxHashDifficulty is the highest difficulty a block can meet: a SHA-256 hash has 256 bits
+const maxHashDifficulty = sha256.Size * 8
+
+// check returns an error if the rules cannot be followed: negative difficulties or difficulties above
+// the 256 bits of a hash, bounds that do not contain the initial difficulty, or no target block time or
+// retarget interval when retargeting
+func (config DifficultyConfig) check() error {
+	switch {
+	case config.InitialDifficulty < 0 || config.MinDifficulty < 0:
+		return fmt.Errorf("difficulties must not be negative")
+	case config.InitialDifficulty > maxHashDifficulty || config.MaxDifficulty > maxHashDifficulty:
+		return fmt.Errorf("difficulties must not be above %d bits, the size of a block hash", maxHashDifficulty)
+	case config.Fixed:
+		return nil
+	case config.MinDifficulty > config.InitialDifficulty || config.InitialDifficulty > config.MaxDifficulty:
+		return fmt.Errorf("initial difficulty %d must be between min difficulty %d and max difficulty %d",
+			config.InitialDifficulty, config.MinDifficulty, config.MaxDifficulty)
+	case config.TargetBlockTime <= 0 || config.RetargetInterval < 1:
+		return fmt.Errorf("target block time and retarget interval must be positive")
+	}
+	return nil
+}
+
+// Hash returns the hash of the rules, which the genesis block commits to (see genesis.go): the SHA-256
+// of the fields in declaration order, written as integers like the block header (see blockencoding.go),
+// with Fixed as 0 or 1, base64 URL encoded like a block hash
+func (config DifficultyConfig) Hash() string {
+	var fixed int64 = 0
+	if config.Fixed {
+		fixed = 1
+	}
+	var buffer bytes.Buffer
+	writeCanonicalInt(&buffer, int64(config.InitialDifficulty))
+	writeCanonicalInt(&buffer, int64(config.MinDifficulty))
+	writeCanonicalInt(&buffer, int64(config.MaxDifficulty))
+	writeCanonicalInt(&buffer, int64(config.TargetBlockTime))
+	writeCanonicalInt(&buffer, int64(config.RetargetInterval))
+	writeCanonicalInt(&buffer, fixed)
+	var digest [sha256.Size]byte = sha256.Sum256(buffer.Bytes())
+	return base64.URLEncoding.EncodeToString(digest[:])
+}
+
+// NextDifficulty returns the difficulty required for the block that follows the last block of chain.
+// Retargeting happens on blocks whose index is a multiple of RetargetInterval plus one (21, 31, ...)
+// and moves the difficulty by at most 2 bits (a factor of 4) at a time. The genesis block is never
+// part of the window: its timestamp is fixed in the genesis configuration (see genesis.go), usually long
+// before the first block is mined, so the first retarget (block 11) is skipped
+func (config DifficultyConfig) NextDifficulty(chain Blocks) int {
+	var lastBlock Block = chain[len(chain)-1]
+	if config.Fixed || lastBlock.Index == 1 {
+		return config.InitialDifficulty // the genesis block is not mined, so it has no difficulty
+	}
+
+	var difficulty int = lastBlock.Difficulty
+	if config.RetargetInterval < 1 || (lastBlock.Index-1)%config.RetargetInterval != 0 ||
+		lastBlock.Index-config.RetargetInterval <= 1 {
+		return difficulty
+	}
+
+	// Time it took to mine the last RetargetInterval blocks, against the time it should have taken
+	var firstBlock Block = chain[len(chain)-1-config.RetargetInterval]
+	var actual int64 = lastBlock.Timestamp - firstBlock.Timestamp
+	var expected int64 = int64(config.RetargetInterval) * int64(config.TargetBlockTime)
+	switch {
+	case actual*4 < expected:
+		difficulty += 2
+	case actual*2 < expected:
+		difficulty += 1
+	case actual > expected*4:
+		difficulty -= 2
+	case actual > expected*2:
+		difficulty -= 1
+	}
+
+	if difficulty < config.MinDifficulty {
+		difficulty = config.MinDifficulty
+	}
+	if difficulty > config.MaxDifficulty {
+		difficulty = config.MaxDifficulty
+	}
+	return difficulty
+}
+
+// MinOrphanDifficulty returns the lowest difficulty of a block whose parent is unknown that is worth
+// keeping, given the difficulty required after our tip: a block of the network ahead of us can only be
+// one retarget (2 bits) easier, and never easier than MinDifficulty. Anything lower did not take real
+// work to mine
+func (config DifficultyConfig) MinOrphanDifficulty(nextDifficulty int) int {
+	if config.Fixed {
+		return config.InitialDifficulty
+	}
+	var difficulty int = nextDifficulty - 2
+	if difficulty < config.MinDifficulty {
+		difficulty = config.MinDifficulty
+	}
+	return difficulty
+}
+
+// meetsDifficulty checks if a SHA-256 digest starts with at least difficulty zero bits
+func meetsDifficulty(digest []byte, difficulty int) bool {
+	var zeroBits int = 0
+	for _, value := range digest {
+		if value != 0 {
+			zeroBits += bits.LeadingZeros8(value)
+			break
+		}
+		zeroBits += 8
+	}
+	return zeroBits >= difficulty
+}
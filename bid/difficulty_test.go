@@ -0,0 +1,104 @@
+package bid
+
+import (
+	"testing"
+	"time"
+)
+
+// retargetTestChain returns a genesis block (with its fixed timestamp, years ago) followed by count
+// blocks, the first one mined now and each next one blockTime later, each at the difficulty required
+// after the blocks before it
+func retargetTestChain(t *testing.T, config DifficultyConfig, count int, blockTime time.Duration) Blocks {
+	t.Helper()
+	var genesis GenesisConfig = DefaultGenesisConfig()
+	genesis.Difficulty = config
+	genesisBlock, err := genesis.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain Blocks = Blocks{genesisBlock}
+	var timestamp int64 = time.Now().UnixNano()
+	for i := 0; i < count; i++ {
+		chain = append(chain, Block{
+			Index:      len(chain) + 1,
+			Timestamp:  timestamp + int64(i)*int64(blockTime),
+			Difficulty: config.NextDifficulty(chain),
+		})
+	}
+	return chain
+}
+
+// Blocks mined at the target block time keep the difficulty, even though the genesis timestamp is
+// far in the past
+func TestNextDifficultyAtTargetBlockTime(t *testing.T) {
+	var config DifficultyConfig = DefaultDifficultyConfig()
+	var chain Blocks = retargetTestChain(t, config, 3*config.RetargetInterval+1, config.TargetBlockTime)
+	for _, block := range chain[1:] {
+		if block.Difficulty != config.InitialDifficulty {
+			t.Fatalf("block %d: difficulty %d, expected %d", block.Index, block.Difficulty, config.InitialDifficulty)
+		}
+	}
+	if next := config.NextDifficulty(chain); next != config.InitialDifficulty {
+		t.Fatalf("next difficulty %d, expected %d", next, config.InitialDifficulty)
+	}
+}
+
+// Retargets move the difficulty by at most 2 bits, within the bounds, once a full window of mined
+// blocks is available
+func TestNextDifficultyRetargets(t *testing.T) {
+	var config DifficultyConfig = DefaultDifficultyConfig()
+	var interval int = config.RetargetInterval
+	var tests = []struct {
+		name      string
+		blockTime time.Duration
+		expected  int
+	}{
+		{"much faster", config.TargetBlockTime / 8, config.InitialDifficulty + 2},
+		{"faster", config.TargetBlockTime / 3, config.InitialDifficulty + 1},
+		{"on target", config.TargetBlockTime, config.InitialDifficulty},
+		{"slower", config.TargetBlockTime * 3, config.InitialDifficulty - 1},
+		{"much slower", config.TargetBlockTime * 8, config.InitialDifficulty - 2},
+	}
+	for _, test := range tests {
+		// The window of the first retarget would start at the genesis block: no retarget
+		var chain Blocks = retargetTestChain(t, config, interval, test.blockTime)
+		if next := config.NextDifficulty(chain); next != config.InitialDifficulty {
+			t.Errorf("%s: first retarget gave difficulty %d, expected no change", test.name, next)
+		}
+		chain = retargetTestChain(t, config, 2*interval, test.blockTime)
+		if next := config.NextDifficulty(chain); next != test.expected {
+			t.Errorf("%s: difficulty %d, expected %d", test.name, next, test.expected)
+		}
+	}
+
+	// Retargets stop at the bounds
+	config.MaxDifficulty = config.InitialDifficulty + 1
+	if next := config.NextDifficulty(retargetTestChain(t, config, 2*interval, config.TargetBlockTime/8)); next != config.MaxDifficulty {
+		t.Errorf("difficulty %d, expected max difficulty %d", next, config.MaxDifficulty)
+	}
+}
+
+func TestDifficultyConfigCheck(t *testing.T) {
+	var tests = []struct {
+		name   string
+		change func(config *DifficultyConfig)
+		valid  bool
+	}{
+		{"default", func(config *DifficultyConfig) {}, true},
+		{"max difficulty of 256 bits", func(config *DifficultyConfig) { config.MaxDifficulty = 256 }, true},
+		{"max difficulty above 256 bits", func(config *DifficultyConfig) { config.MaxDifficulty = 257 }, false},
+		{"fixed above 256 bits", func(config *DifficultyConfig) { config.Fixed, config.InitialDifficulty = true, 300 }, false},
+		{"negative", func(config *DifficultyConfig) { config.MinDifficulty = -1 }, false},
+		{"initial below min", func(config *DifficultyConfig) { config.InitialDifficulty = config.MinDifficulty - 1 }, false},
+		{"initial above max", func(config *DifficultyConfig) { config.InitialDifficulty = config.MaxDifficulty + 1 }, false},
+		{"no target block time", func(config *DifficultyConfig) { config.TargetBlockTime = 0 }, false},
+		{"no retarget interval", func(config *DifficultyConfig) { config.RetargetInterval = 0 }, false},
+	}
+	for _, test := range tests {
+		var config DifficultyConfig = DefaultDifficultyConfig()
+		test.change(&config)
+		if err := config.check(); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/* Canonical encoding of bids. A bid is identified by its hash, and the hashes of the bids of a block
+are the leaves of the block's Merkle tree, so every node (and every client checking a proof) must turn
+a bid into exactly the same bytes. JSON is not suitable for this: field order, spacing and string
+escaping are all up to the encoder. Instead, the fields of a bid are written one after the other:
+	chain id		4-byte big-endian length, then the UTF-8 bytes
+	public key		4-byte big-endian length, then the lowercase hex string
+	sequence		8-byte big-endian unsigned integer
+	bidder name		4-byte big-endian length, then the UTF-8 bytes
+	auction id		8-byte big-endian signed integer
+	bid value		8-byte big-endian signed integer (the Units of the Money, see money.go), then the
+					currency code as a string (4-byte length, then the code; length 0 for no currency)
+	kind			4-byte big-endian length, then the UTF-8 bytes ("", "commit" or "reveal")
+	commitment		4-byte big-endian length, then the lowercase hex string
+	salt			4-byte big-endian length, then the UTF-8 bytes
+	signature		4-byte big-endian length, then the lowercase hex string
+The bidder signs every field but the signature (see signingBytes), and the bid hash covers all of them.
+Signing the chain id means that a bid made on one network cannot be replayed on another */
+package bid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Hash returns the hash of a bid: the SHA-256 of its canonical encoding, as a hex string
+func (bid Bid) Hash() string {
+	return hex.EncodeToString(bid.digest())
+}
+
+// digest returns the SHA-256 of the canonical encoding of a bid
+func (bid Bid) digest() []byte {
+	var digest [sha256.Size]byte = sha256.Sum256(bid.canonicalBytes())
+	return digest[:]
+}
+
+// canonicalBytes returns the canonical encoding of a bid
+func (bid Bid) canonicalBytes() []byte {
+	var buffer *bytes.Buffer = bytes.NewBuffer(bid.signingBytes())
+	writeCanonicalString(buffer, bid.Signature)
+	return buffer.Bytes()
+}
+
+// signingBytes returns the canonical encoding of a bid without its signature: the bytes signed by
+// the bidder
+func (bid Bid) signingBytes() []byte {
+	var buffer bytes.Buffer
+	writeCanonicalString(&buffer, bid.ChainId)
+	writeCanonicalString(&buffer, bid.PublicKey)
+	writeCanonicalInt(&buffer, int64(bid.Sequence))
+	writeCanonicalString(&buffer, bid.BidderName)
+	writeCanonicalInt(&buffer, int64(bid.AuctionId))
+	writeCanonicalMoney(&buffer, bid.BidValue)
+	writeCanonicalString(&buffer, bid.Kind)
+	writeCanonicalString(&buffer, bid.Commitment)
+	writeCanonicalString(&buffer, bid.Salt)
+	return buffer.Bytes()
+}
+
+// writeCanonicalString writes a string as its length (4 bytes, big-endian) followed by its bytes
+func writeCanonicalString(buffer *bytes.Buffer, value string) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	buffer.Write(length[:])
+	buffer.WriteString(value)
+}
+
+// writeCanonicalInt writes an integer as 8 bytes, big-endian
+func writeCanonicalInt(buffer *bytes.Buffer, value int64) {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], uint64(value))
+	buffer.Write(encoded[:])
+}
+
+// writeCanonicalMoney writes an amount as its minor units (8 bytes, big-endian) followed by its
+// currency code as a string
+func writeCanonicalMoney(buffer *bytes.Buffer, value Money) {
+	writeCanonicalInt(buffer, value.Units)
+	writeCanonicalString(buffer, value.Currency)
+}
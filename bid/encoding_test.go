@@ -0,0 +1,44 @@
+package bid
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The bid of the golden vectors, and its encoding without the signature
+var goldenBid Bid = Bid{
+	ChainId:    "miniblockchain",
+	PublicKey:  "abcd",
+	Sequence:   7,
+	BidderName: "alice",
+	AuctionId:  100,
+	BidValue:   MustParseMoney("123.45 EUR"),
+	Signature:  "ef01",
+}
+
+const goldenBidSignedBytes = "0000000e" + "6d696e69626c6f636b636861696e" + // chain id
+	"00000004" + "61626364" + // public key
+	"0000000000000007" + // sequence
+	"00000005" + "616c696365" + // bidder name
+	"0000000000000064" + // auction id
+	"0000000000003039" + "00000003" + "455552" + // bid value
+	"00000000" + // kind
+	"00000000" + // commitment
+	"00000000" // salt
+
+// The golden vector of the bid encoding, whose hashes are the leaves of the Merkle tree of a block
+func TestBidGoldenVector(t *testing.T) {
+	var bid Bid = goldenBid
+	var signed string = goldenBidSignedBytes
+	var expected string = signed + "00000004" + "65663031" // signature
+
+	if encoded := hex.EncodeToString(bid.signingBytes()); encoded != signed {
+		t.Fatalf("signed bytes\n got %s\nwant %s", encoded, signed)
+	}
+	if encoded := hex.EncodeToString(bid.canonicalBytes()); encoded != expected {
+		t.Fatalf("bid encoding\n got %s\nwant %s", encoded, expected)
+	}
+	if hash := bid.Hash(); hash != "cd3ea83a4d44aa96536a04205f0910871aef9ae31bb105137fe9d062b59d1658" {
+		t.Fatalf("bid hash: got %s", hash)
+	}
+}
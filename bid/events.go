@@ -0,0 +1,383 @@
+/* Event stream. Clients used to poll GET /blockchain to notice new bids and blocks. GET /events streams
+typed events instead, as Server-Sent Events (text/event-stream):
+	bid_accepted		a bid entered the mempool (data: the bid, see BidRecord)
+	block_mined			this node mined a block (data: BlockEvent)
+	block_received		a block received from another node was accepted (data: BlockEvent)
+	chain_reorganized	a received block made another branch the main chain (data: ReorgEvent)
+	chain_replaced		consensus switched to the chain of another node (data: ChainReplacedEvent)
+	auction_closed		a block closing an auction joined the main chain (data: AuctionState)
+Each event is written as
+	id: 1627171722582903
+	event: bid_accepted
+	data: {"id":1627171722582903,"type":"bid_accepted",...}
+Clients filter with query parameters: auction_id (repeatable) and bidder (the bidder's public key,
+repeatable) keep the events about those auctions and bidders, plus the events about the whole chain
+(chain_reorganized and chain_replaced); types=bid_accepted,block_mined keeps those types.
+The last maxEventHistory events are kept, so a client that reconnects with the Last-Event-ID header (or
+the last_event_id query parameter) first gets the events it missed. Event ids start from the time the
+node started, in microseconds, so they keep increasing across restarts. A client that reads too slowly
+is disconnected, and resumes the same way */
+package bid
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	EventBidAccepted      = "bid_accepted"
+	EventBlockMined       = "block_mined"
+	EventBlockReceived    = "block_received"
+	EventChainReorganized = "chain_reorganized"
+	EventChainReplaced    = "chain_replaced"
+	EventAuctionClosed    = "auction_closed"
+)
+
+// Settings of the event stream
+const (
+	maxEventHistory     = 1000
+	subscriberQueueSize = 256
+	eventKeepAlive      = 15 * time.Second
+)
+
+// EventFilter selects events: those about one of AuctionIds or Bidders (all if both are empty), of one
+// of Types (all if empty)
+type EventFilter struct {
+	AuctionIds []int
+	Bidders    []string
+	Types      []string
+}
+
+// subscriber is a client of the event stream. events is closed when the subscriber is dropped
+type subscriber struct {
+	filter EventFilter
+	events chan Event
+}
+
+// EventBus keeps the last events and sends new events to subscribers and observers
+type EventBus struct {
+	mutex       sync.Mutex
+	nextId      uint64
+	history     []Event
+	subscribers map[*subscriber]bool
+	observers   []func(event Event)
+	closed      bool
+}
+
+// NewEventBus creates an event bus whose first event id is the current time in microseconds
+func NewEventBus() *EventBus {
+	return &EventBus{
+		nextId:      uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		history:     []Event{},
+		subscribers: map[*subscriber]bool{},
+	}
+}
+
+// Observe registers a function that is called with every event (i.e., to send webhooks, see
+// webhooks.go). Unlike subscribers, observers are never dropped, so they must not block for long
+func (bus *EventBus) Observe(observer func(event Event)) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.observers = append(bus.observers, observer)
+}
+
+// Publish sends an event about the given auctions and bidders (none for events about the whole
+// chain) to the subscribers whose filter matches it, then to the observers
+func (bus *EventBus) Publish(eventType string, auctionIds []int, bidders []string, data interface{}) {
+	var event Event = bus.publish(eventType, auctionIds, bidders, data)
+	bus.mutex.Lock()
+	var observers []func(event Event) = bus.observers
+	bus.mutex.Unlock()
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// publish creates an event, keeps it and sends it to the subscribers
+func (bus *EventBus) publish(eventType string, auctionIds []int, bidders []string, data interface{}) Event {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	var event Event = Event{
+		Id:         bus.nextId,
+		Type:       eventType,
+		Time:       time.Now(),
+		AuctionIds: auctionIds,
+		Bidders:    bidders,
+		Data:       data,
+	}
+	bus.nextId++
+	bus.history = append(bus.history, event)
+	if len(bus.history) > maxEventHistory {
+		bus.history = bus.history[len(bus.history)-maxEventHistory:]
+	}
+	for sub := range bus.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// Too slow: drop it rather than hold up the node. It resumes with Last-Event-ID
+			delete(bus.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return event
+}
+
+// Subscribe registers a subscriber and returns the kept events after lastEventId that match its filter
+// (none if lastEventId is 0), followed on the channel by new events
+func (bus *EventBus) Subscribe(filter EventFilter, lastEventId uint64) ([]Event, *subscriber) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	var missed []Event = []Event{}
+	if lastEventId > 0 {
+		for _, event := range bus.history {
+			if event.Id > lastEventId && filter.matches(event) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	var sub *subscriber = &subscriber{filter: filter, events: make(chan Event, subscriberQueueSize)}
+	if bus.closed {
+		close(sub.events)
+		return missed, sub
+	}
+	bus.subscribers[sub] = true
+	return missed, sub
+}
+
+// Unsubscribe removes a subscriber, if it was not dropped already
+func (bus *EventBus) Unsubscribe(sub *subscriber) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if bus.subscribers[sub] {
+		delete(bus.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Close disconnects all subscribers, and those that subscribe later, so that open streams end (i.e.,
+// when the node shuts down)
+func (bus *EventBus) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.closed = true
+	for sub := range bus.subscribers {
+		delete(bus.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// matches checks if an event passes a filter. Events about the whole chain pass the auction and
+// bidder filters
+func (filter EventFilter) matches(event Event) bool {
+	if len(filter.Types) > 0 && !containsString(filter.Types, event.Type) {
+		return false
+	}
+	if len(filter.AuctionIds) == 0 && len(filter.Bidders) == 0 {
+		return true
+	}
+	if len(event.AuctionIds) == 0 && len(event.Bidders) == 0 {
+		return true
+	}
+	for _, auctionId := range event.AuctionIds {
+		for _, wanted := range filter.AuctionIds {
+			if auctionId == wanted {
+				return true
+			}
+		}
+	}
+	for _, bidder := range event.Bidders {
+		if containsString(filter.Bidders, bidder) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// blockSubjects returns the auctions and bidders a block is about: those of its bids and auction
+// records, each once
+func blockSubjects(block Block) ([]int, []string) {
+	var auctionIds []int = []int{}
+	var bidders []string = []string{}
+	var seenAuctions map[int]bool = map[int]bool{}
+	var seenBidders map[string]bool = map[string]bool{}
+	var addAuction = func(auctionId int) {
+		if !seenAuctions[auctionId] {
+			seenAuctions[auctionId] = true
+			auctionIds = append(auctionIds, auctionId)
+		}
+	}
+	for _, bid := range block.Bids {
+		addAuction(bid.AuctionId)
+		if !seenBidders[bid.PublicKey] {
+			seenBidders[bid.PublicKey] = true
+			bidders = append(bidders, bid.PublicKey)
+		}
+	}
+	for _, record := range block.Auctions {
+		addAuction(record.AuctionId)
+	}
+	return auctionIds, bidders
+}
+
+/* Hooks, called by the controller */
+
+// publishBid publishes a bid_accepted event
+func (c *Controller) publishBid(bid Bid) {
+	c.events.Publish(EventBidAccepted, []int{bid.AuctionId}, []string{bid.PublicKey},
+		BidRecord{Bid: bid, BidHash: bid.Hash()})
+}
+
+// publishBlock publishes a block_mined or block_received event, then an auction_closed event for each
+// auction the block closes if it joined the main chain
+func (c *Controller) publishBlock(eventType string, block Block, status string, source string) {
+	auctionIds, bidders := blockSubjects(block)
+	c.events.Publish(eventType, auctionIds, bidders, BlockEvent{Block: block, Status: status, SourceNode: source})
+	if status == BlockConnected || status == BlockReorganized {
+		c.publishClosedAuctions(Blocks{block})
+	}
+}
+
+// publishAddedBlocks publishes what AddBlock did with the block of the given hash, received from source:
+// a block_received event for each block added to the block tree (the block, then the orphans it
+// connected, whose source is unknown), an auction_closed event for each auction closed by the blocks
+// that joined the main chain, and a chain_reorganized event for each reorganization
+func (c *Controller) publishAddedBlocks(result AddBlockResult, hash string, source string) {
+	for _, added := range result.Added {
+		var addedSource string = ""
+		if added.Block.Hash == hash {
+			addedSource = source
+		}
+		auctionIds, bidders := blockSubjects(added.Block)
+		c.events.Publish(EventBlockReceived, auctionIds, bidders, BlockEvent{Block: added.Block, Status: added.Status, SourceNode: addedSource})
+	}
+	c.publishClosedAuctions(result.Connected)
+	for _, reorg := range result.Reorgs {
+		c.events.Publish(EventChainReorganized, []int{}, []string{}, reorg)
+	}
+}
+
+// publishClosedAuctions publishes an auction_closed event for each auction closed by the given blocks
+func (c *Controller) publishClosedAuctions(blocks Blocks) {
+	for _, block := range blocks {
+		for _, record := range block.Auctions {
+			if record.Type != AuctionRecordClose {
+				continue
+			}
+			if state, ok := c.blockChain.GetAuction(record.AuctionId); ok {
+				c.events.Publish(EventAuctionClosed, []int{record.AuctionId}, []string{}, state)
+			}
+		}
+	}
+}
+
+// GetEvents GET /events?auction_id=100&bidder=3d40...&types=bid_accepted,block_mined
+/* Streams events as Server-Sent Events (see the doc comment of this file). The stream stays open until
+the client disconnects; a comment line is sent every 15 seconds to keep it alive. Typical output:
+id: 1627171722582903
+event: bid_accepted
+data: {"id":1627171722582903,"type":"bid_accepted","time":"2021-07-25T10:15:00.000000000Z","auction_ids":[100],"bidders":["3d40..."],"data":{"chain_id":"miniblockchain",...,"bid_hash":"5c1f2e...","confirmed":false,"confirmations":0}}
+*/
+func (c *Controller) GetEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		sendStandardResponse(writer, http.StatusInternalServerError, "GetEvents", "Streaming is not supported")
+		return
+	}
+	filter, lastEventId, err := parseEventQuery(request)
+	if err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "GetEvents", err.Error())
+		return
+	}
+
+	missed, sub := c.events.Subscribe(filter, lastEventId)
+	defer c.events.Unsubscribe(sub)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	for _, event := range missed {
+		writeEvent(writer, event)
+	}
+	flusher.Flush()
+
+	var keepAlive *time.Ticker = time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event, open := <-sub.events:
+			if !open {
+				return // dropped: the client resumes with Last-Event-ID
+			}
+			writeEvent(writer, event)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes an event in the Server-Sent Events format
+func writeEvent(writer http.ResponseWriter, event Event) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+}
+
+// parseEventQuery reads the filter of GET /events and the id of the last event the client saw
+func parseEventQuery(request *http.Request) (EventFilter, uint64, error) {
+	var values = request.URL.Query()
+	var filter EventFilter = EventFilter{AuctionIds: []int{}, Bidders: values["bidder"], Types: []string{}}
+	for _, value := range values["auction_id"] {
+		auctionId, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, 0, fmt.Errorf("auction_id must be an integer")
+		}
+		filter.AuctionIds = append(filter.AuctionIds, auctionId)
+	}
+	if value := values.Get("types"); value != "" {
+		for _, eventType := range strings.Split(value, ",") {
+			switch eventType {
+			case EventBidAccepted, EventBlockMined, EventBlockReceived, EventChainReorganized, EventChainReplaced, EventAuctionClosed:
+				filter.Types = append(filter.Types, eventType)
+			default:
+				return filter, 0, fmt.Errorf("unknown event type %q", eventType)
+			}
+		}
+	}
+
+	var lastEventId string = request.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = values.Get("last_event_id")
+	}
+	if lastEventId == "" {
+		return filter, 0, nil
+	}
+	id, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		return filter, 0, fmt.Errorf("last event id must be an event id")
+	}
+	return filter, id, nil
+}
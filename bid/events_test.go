@@ -0,0 +1,112 @@
+package bid
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventStream is an open GET /events stream
+type eventStream struct {
+	response *http.Response
+	reader   *bufio.Reader
+}
+
+func openEventStream(t *testing.T, ctx context.Context, url string, lastEventId string) *eventStream {
+	t.Helper()
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	return &eventStream{response: response, reader: bufio.NewReader(response.Body)}
+}
+
+// next reads the id of the next event of the stream
+func (s *eventStream) next(t *testing.T) uint64 {
+	t.Helper()
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			id, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "id: ")), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+	}
+}
+
+// lastEventId returns the id of the last event published on a bus
+func lastEventId(bus *EventBus) uint64 {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.history[len(bus.history)-1].Id
+}
+
+// A client that reconnects with Last-Event-ID first gets the events it missed that match its filter,
+// then the new ones
+func TestEventStreamResumes(t *testing.T) {
+	var c *Controller = newTestController(t, "http://localhost:9100")
+	defer c.gossip.Stop()
+	var server *httptest.Server = httptest.NewServer(http.HandlerFunc(c.GetEvents))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var events *EventBus = c.events
+	var ids []uint64 = []uint64{}
+	for _, auctionId := range []int{1, 2, 1, 1} {
+		events.Publish(EventBidAccepted, []int{auctionId}, []string{}, nil)
+		ids = append(ids, lastEventId(events))
+	}
+
+	// Missed events, then new ones
+	var stream *eventStream = openEventStream(t, ctx, server.URL+"/events?auction_id=1", strconv.FormatUint(ids[0], 10))
+	defer stream.response.Body.Close()
+	for _, expected := range []uint64{ids[2], ids[3]} {
+		if id := stream.next(t); id != expected {
+			t.Fatalf("got event %d, expected %d", id, expected)
+		}
+	}
+	events.Publish(EventBidAccepted, []int{2}, []string{}, nil)
+	events.Publish(EventBidAccepted, []int{1}, []string{}, nil)
+	if id, last := stream.next(t), lastEventId(events); id != last {
+		t.Fatalf("got event %d, expected the new event %d", id, last)
+	}
+
+	// The query parameter works like the header, and a client that never saw an event only gets new ones
+	var resumed *eventStream = openEventStream(t, ctx, server.URL+"/events?types=bid_accepted&last_event_id="+strconv.FormatUint(ids[2], 10), "")
+	defer resumed.response.Body.Close()
+	if id := resumed.next(t); id != ids[3] {
+		t.Fatalf("got event %d, expected %d", id, ids[3])
+	}
+	var fresh *eventStream = openEventStream(t, ctx, server.URL+"/events", "")
+	defer fresh.response.Body.Close()
+	waitFor(t, 5*time.Second, "the subscription", func() bool {
+		events.mutex.Lock()
+		defer events.mutex.Unlock()
+		return len(events.subscribers) == 3
+	})
+	events.Publish(EventBlockMined, []int{}, []string{}, nil)
+	if id, last := fresh.next(t), lastEventId(events); id != last {
+		t.Fatalf("got event %d, expected only the new event %d", id, last)
+	}
+}
@@ -0,0 +1,180 @@
+/* Block explorer. Dashboards and scripts used to download the whole chain (GET /blockchain) to read a
+single block. The explorer endpoints return only what is asked for:
+	GET /block/{index}				a block of the main chain, by index
+	GET /block/hash/{blockHash}		a block of any known branch, by hash
+	GET /block/tip					the last block of the main chain
+	GET /blocks?from=1&limit=20		a page of blocks of the main chain
+	GET /bid/{bidHash}				a confirmed or pending bid, by hash
+Blocks and bids are returned with their confirmation depth: 1 for the tip (and the bids in it), 2 for its
+parent, and so on; 0 for blocks on side branches and pending bids.
+Responses carry an ETag, the hash of the response body: a client that sends it back in If-None-Match
+gets 304 Not Modified, without a body, as long as the data is unchanged. The depth of a block grows with
+each new block, so its ETag changes too */
+package bid
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Default and maximum number of blocks returned in a single page by GET /blocks
+const (
+	defaultBlockPageSize = 20
+	maxBlockPageSize     = 100
+)
+
+// blockRecord returns a block with its confirmation depth. Must be called with mutex held
+func (b *BlockChain) blockRecord(block Block) BlockRecord {
+	var record BlockRecord = BlockRecord{Block: block}
+	if block.Index >= 1 && block.Index <= len(b.Chain) && b.Chain[block.Index-1].Hash == block.Hash {
+		record.MainChain = true
+		record.Confirmations = len(b.Chain) - block.Index + 1
+	}
+	return record
+}
+
+// GetBlockByIndex gets the block of the main chain with the given index (the genesis block has index 1).
+// Returns false if there is no such block
+func (b *BlockChain) GetBlockByIndex(index int) (BlockRecord, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if index < 1 || index > len(b.Chain) {
+		return BlockRecord{}, false
+	}
+	return b.blockRecord(b.Chain[index-1]), true
+}
+
+// GetBlockRecord gets a block of any known branch by hash, with its confirmation depth. Returns false
+// if no such block is known
+func (b *BlockChain) GetBlockRecord(hash string) (BlockRecord, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	node, exists := b.tree.nodes[hash]
+	if !exists {
+		return BlockRecord{}, false
+	}
+	return b.blockRecord(node.block), true
+}
+
+// GetTip gets the last block of the main chain
+func (b *BlockChain) GetTip() BlockRecord {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.blockRecord(b.Chain[len(b.Chain)-1])
+}
+
+// GetBlocks gets up to limit blocks of the main chain, starting with the block at index from and going
+// up (or down if descending is set). from 0 starts at the genesis block (at the tip if descending)
+func (b *BlockChain) GetBlocks(from int, limit int, descending bool) BlockPage {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var step int = 1
+	if descending {
+		step = -1
+		if from == 0 || from > len(b.Chain) {
+			from = len(b.Chain)
+		}
+	} else if from == 0 {
+		from = 1
+	}
+	var page BlockPage = BlockPage{TipIndex: len(b.Chain), Blocks: []BlockRecord{}}
+	var index int = from
+	for ; index >= 1 && index <= len(b.Chain) && len(page.Blocks) < limit; index += step {
+		page.Blocks = append(page.Blocks, b.blockRecord(b.Chain[index-1]))
+	}
+	if index >= 1 && index <= len(b.Chain) {
+		page.Next = index
+	}
+	return page
+}
+
+// GetBid gets a confirmed bid of the main chain, or a pending bid, by hash. Returns false if no such bid
+// is known
+func (b *BlockChain) GetBid(bidHash string) (BidRecord, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if location, confirmed := b.bidIndex.byHash[bidHash]; confirmed {
+		var block Block = b.Chain[location.BlockIndex-1]
+		return BidRecord{
+			Bid:           block.Bids[location.Position],
+			BidHash:       bidHash,
+			Confirmed:     true,
+			Confirmations: len(b.Chain) - block.Index + 1,
+			BidLocation:   location,
+			Timestamp:     block.Timestamp,
+		}, true
+	}
+	for position, entry := range b.mempool.list() {
+		if entry.hash == bidHash {
+			return BidRecord{
+				Bid:         entry.bid,
+				BidHash:     bidHash,
+				Confirmed:   false,
+				BidLocation: BidLocation{Position: position},
+			}, true
+		}
+	}
+	return BidRecord{}, false
+}
+
+// parseBlockPageQuery reads the from, limit and order query parameters of GET /blocks
+func parseBlockPageQuery(request *http.Request) (int, int, bool, error) {
+	var from int = 0
+	var limit int = defaultBlockPageSize
+	var err error
+	if value := request.URL.Query().Get("from"); value != "" {
+		if from, err = strconv.Atoi(value); err != nil || from < 1 {
+			return 0, 0, false, fmt.Errorf("from must be a block index (1 or more)")
+		}
+	}
+	if value := request.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxBlockPageSize {
+			return 0, 0, false, fmt.Errorf("limit must be between 1 and %d", maxBlockPageSize)
+		}
+	}
+	switch request.URL.Query().Get("order") {
+	case "", "asc":
+		return from, limit, false, nil
+	case "desc":
+		return from, limit, true, nil
+	}
+	return 0, 0, false, fmt.Errorf("order must be \"asc\" or \"desc\"")
+}
+
+// sendCacheableJsonResponse sends a value as JSON with an ETag (a hash of the JSON). If the request's
+// If-None-Match header has the same ETag, 304 Not Modified is sent instead, without a body
+func sendCacheableJsonResponse(writer http.ResponseWriter, request *http.Request, value interface{}) {
+	data, _ := json.Marshal(value)
+	var digest [sha256.Size]byte = sha256.Sum256(data)
+	var etag string = `"` + base64.RawURLEncoding.EncodeToString(digest[:16]) + `"`
+	writer.Header().Set("ETag", etag)
+	writer.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(request.Header.Get("If-None-Match"), etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+// etagMatches checks if an If-None-Match header (a list of ETags, or "*") matches an ETag. Weak ETags
+// (W/"...") match their strong form
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
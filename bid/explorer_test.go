@@ -0,0 +1,67 @@
+package bid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// explorerHandler serves the explorer endpoints of a controller, with the paths of routes
+func explorerHandler(c *Controller) http.Handler {
+	var router *mux.Router = mux.NewRouter()
+	router.Methods("GET").Path("/block/tip").HandlerFunc(c.GetTip)
+	router.Methods("GET").Path("/block/{index:[0-9]+}").HandlerFunc(c.GetBlockByIndex)
+	router.Methods("GET").Path("/blocks").HandlerFunc(c.GetBlocks)
+	router.Methods("GET").Path("/block/hash/{blockHash}").HandlerFunc(c.GetBlockByHash)
+	return router
+}
+
+// explorerGet sends a GET request to a handler, with an If-None-Match header unless etag is empty
+func explorerGet(handler http.Handler, path string, etag string) *httptest.ResponseRecorder {
+	var request *http.Request = httptest.NewRequest("GET", path, nil)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	var recorder *httptest.ResponseRecorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// An explorer response sent back with its ETag gives 304 Not Modified until the data changes
+func TestExplorerETag(t *testing.T) {
+	var c *Controller = newTestController(t, "http://localhost:9100")
+	defer c.gossip.Stop()
+	var handler http.Handler = explorerHandler(c)
+
+	for _, path := range []string{"/block/1", "/block/tip", "/blocks?from=1", "/block/hash/" + c.blockChain.GetGenesisBlock().Hash} {
+		var first *httptest.ResponseRecorder = explorerGet(handler, path, "")
+		var etag string = first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: status %d, ETag %q", path, first.Code, etag)
+		}
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			var cached *httptest.ResponseRecorder = explorerGet(handler, path, ifNoneMatch)
+			if cached.Code != http.StatusNotModified || cached.Body.Len() != 0 || cached.Header().Get("ETag") != etag {
+				t.Fatalf("%s with If-None-Match %s: status %d, %d bytes", path, ifNoneMatch, cached.Code, cached.Body.Len())
+			}
+		}
+		if other := explorerGet(handler, path, `"other"`); other.Code != http.StatusOK || other.Body.String() != first.Body.String() {
+			t.Fatalf("%s with another ETag: status %d", path, other.Code)
+		}
+	}
+
+	// A new block changes the depth of the genesis block, and the tip
+	var genesis string = explorerGet(handler, "/block/1", "").Header().Get("ETag")
+	var tip string = explorerGet(handler, "/block/tip", "").Header().Get("ETag")
+	if _, err := mineBlock(c.blockChain); err != nil {
+		t.Fatal(err)
+	}
+	for path, etag := range map[string]string{"/block/1": genesis, "/block/tip": tip} {
+		var changed *httptest.ResponseRecorder = explorerGet(handler, path, etag)
+		if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+			t.Fatalf("%s after a new block: status %d, ETag %q", path, changed.Code, changed.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,494 @@
+/* Forks. Two nodes can mine a block on the same tip at about the same time, and each half of the
+network then extends a different branch. Instead of rejecting every block that does not extend our
+tip, the node keeps a tree of the blocks it knows and follows the branch with the most cumulative work:
+the sum over its blocks of 2^difficulty, the expected number of hashes needed to mine them. When
+another branch gets more work than the main chain, the node reorganizes: the blocks of the main chain
+after the fork point are disconnected, the blocks of the other branch are connected, and the bids and
+auction records of the disconnected blocks that are not confirmed on the new branch go back to the
+pending ones.
+
+Blocks on side branches are checked on their own when they arrive (hash, difficulty, Merkle roots,
+place in their branch); their bids and auction records are only checked against the ledger when their
+branch is about to become the main chain. A block that fails then is dropped with its descendants.
+
+Blocks whose parent is unknown are kept in an orphan pool (at most maxOrphans blocks, for at most
+orphanExpiry) until the parent arrives. The node fetches missing parents from the peer that sent the
+orphan (see Controller.ReceiveNewBlock).
+
+The tree is only kept in memory: after a restart the node only knows its main chain. Side branches
+that fork more than maxForkDepth blocks below the tip are dropped */
+package bid
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+const (
+	// Maximum number of blocks in the orphan pool, and how long an orphan waits for its parent
+	maxOrphans   = 100
+	orphanExpiry = 10 * time.Minute
+
+	// Side branch blocks this many blocks below the tip are dropped
+	maxForkDepth = 100
+
+	// Number of reorganizations kept for GET /reorgs
+	maxReorgEvents = 100
+)
+
+// Outcomes of AddBlock
+const (
+	BlockConnected   = "connected"    // the block extends the main chain
+	BlockSideBranch  = "side-branch"  // the block is on a branch with less work than the main chain
+	BlockReorganized = "reorganized"  // the block made its branch the main chain
+	BlockOrphan      = "orphan"       // the block's parent is unknown: it waits in the orphan pool
+	BlockKnown       = "known"        // the block was already known
+)
+
+// AddBlockResult is what AddBlock did: the outcome for the block, the blocks added to the block tree
+// (the block, then the orphans that were waiting for it) with their outcome, the blocks that joined
+// the main chain and the reorganizations, in the order they happened
+type AddBlockResult struct {
+	Status    string
+	Added     []AddedBlock
+	Connected Blocks
+	Reorgs    []ReorgEvent
+}
+
+// AddedBlock is a block added to the block tree by AddBlock, with its outcome
+type AddedBlock struct {
+	Block  Block
+	Status string
+}
+
+// blockNode is a block of the block tree, with the cumulative work of the chain it ends
+type blockNode struct {
+	block Block
+	work  *big.Int
+}
+
+// orphanBlock is a block waiting for its parent
+type orphanBlock struct {
+	block      Block
+	receivedAt time.Time
+}
+
+// blockTree holds the blocks of all known branches (main chain included) and the orphan pool, by hash
+type blockTree struct {
+	nodes   map[string]*blockNode
+	orphans map[string]orphanBlock
+}
+
+func newBlockTree(chain Blocks) *blockTree {
+	var tree *blockTree = &blockTree{nodes: map[string]*blockNode{}, orphans: map[string]orphanBlock{}}
+	tree.addChain(chain)
+	return tree
+}
+
+// blockWork returns the work of a block of the given difficulty: 2^difficulty
+func blockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// ChainWork returns the cumulative work of a chain
+func ChainWork(chain Blocks) *big.Int {
+	var work *big.Int = new(big.Int)
+	for _, block := range chain {
+		work.Add(work, blockWork(block.Difficulty))
+	}
+	return work
+}
+
+// add adds a block whose parent is in the tree (or the genesis block) and returns its node
+func (tree *blockTree) add(block Block) *blockNode {
+	if node, exists := tree.nodes[block.Hash]; exists {
+		return node
+	}
+	var work *big.Int = blockWork(block.Difficulty)
+	if parent, exists := tree.nodes[block.PreviousBlockHash]; exists {
+		work.Add(work, parent.work)
+	}
+	var node *blockNode = &blockNode{block: block, work: work}
+	tree.nodes[block.Hash] = node
+	return node
+}
+
+// addChain adds the blocks of a chain, parents first
+func (tree *blockTree) addChain(chain Blocks) {
+	for _, block := range chain {
+		tree.add(block)
+	}
+}
+
+// removeBranch removes a block and all its descendants from the tree
+func (tree *blockTree) removeBranch(hash string) {
+	var removed map[string]bool = map[string]bool{hash: true}
+	delete(tree.nodes, hash)
+	for found := true; found; {
+		found = false
+		for nodeHash, node := range tree.nodes {
+			if removed[node.block.PreviousBlockHash] {
+				removed[nodeHash] = true
+				delete(tree.nodes, nodeHash)
+				found = true
+			}
+		}
+	}
+}
+
+// addOrphan adds a block to the orphan pool, dropping expired orphans and, if the pool is full,
+// the oldest one
+func (tree *blockTree) addOrphan(block Block) {
+	var now time.Time = time.Now()
+	var oldestHash string = ""
+	for hash, orphan := range tree.orphans {
+		if now.Sub(orphan.receivedAt) > orphanExpiry {
+			delete(tree.orphans, hash)
+		} else if oldestHash == "" || orphan.receivedAt.Before(tree.orphans[oldestHash].receivedAt) {
+			oldestHash = hash
+		}
+	}
+	if len(tree.orphans) >= maxOrphans {
+		delete(tree.orphans, oldestHash)
+	}
+	tree.orphans[block.Hash] = orphanBlock{block: block, receivedAt: now}
+}
+
+// takeOrphansOf removes the orphans whose parent is the given block from the pool and returns them
+func (tree *blockTree) takeOrphansOf(parentHash string) Blocks {
+	var children Blocks = Blocks{}
+	for hash, orphan := range tree.orphans {
+		if orphan.block.PreviousBlockHash == parentHash {
+			children = append(children, orphan.block)
+			delete(tree.orphans, hash)
+		}
+	}
+	return children
+}
+
+// AddBlock adds a block received from another node. A block that extends the main chain is checked
+// (see checkNewBlockHash) and appended; a block on another branch is kept in the block tree, and the
+// node reorganizes to that branch if it now has more work than the main chain; a block whose parent is
+// unknown goes to the orphan pool. Orphans waiting for the block are then added too. Returns what was
+// done (see AddBlockResult; its Status is one of the Block* outcomes), or an error wrapping
+// ErrBlockRejected if the block (or its branch) is not valid
+func (b *BlockChain) AddBlock(newBlock Block) (AddBlockResult, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var result AddBlockResult = AddBlockResult{Added: []AddedBlock{}, Connected: Blocks{}, Reorgs: []ReorgEvent{}}
+	status, err := b.addBlock(newBlock, &result)
+	result.Status = status
+	if err != nil || status == BlockOrphan || status == BlockKnown {
+		return result, err
+	}
+
+	// Blocks that were waiting for this one can be added now, and so can their own children
+	var parents []string = []string{newBlock.Hash}
+	for len(parents) > 0 {
+		var parent string = parents[0]
+		parents = parents[1:]
+		for _, orphan := range b.tree.takeOrphansOf(parent) {
+			orphanStatus, err := b.addBlock(orphan, &result)
+			if err != nil {
+				log.Printf("Orphan block %d (%s) rejected: %s", orphan.Index, orphan.Hash, err)
+				continue
+			}
+			log.Printf("Orphan block %d (%s) added: %s", orphan.Index, orphan.Hash, orphanStatus)
+			parents = append(parents, orphan.Hash)
+		}
+	}
+	return result, nil
+}
+
+// addBlock is AddBlock for a single block. The block, and what it connected, are recorded in result
+// unless it was known, orphaned or rejected. Must be called with mutex held
+func (b *BlockChain) addBlock(newBlock Block, result *AddBlockResult) (string, error) {
+	if _, known := b.tree.nodes[newBlock.Hash]; known {
+		return BlockKnown, nil
+	}
+	if _, known := b.tree.orphans[newBlock.Hash]; known {
+		return BlockOrphan, nil
+	}
+	// A block of another network never connects to our tree: do not keep it as an orphan
+	if newBlock.ChainId != b.genesis.ChainId {
+		return "", fmt.Errorf("%w: block is for chain %q, this node follows chain %q", ErrBlockRejected, newBlock.ChainId, b.genesis.ChainId)
+	}
+	if newBlock.Timestamp > time.Now().Add(maxBlockTimeDrift).UnixNano() {
+		return "", fmt.Errorf("%w: timestamp is too far in the future", ErrBlockRejected)
+	}
+	// Check what can be checked without the parent first. The proof of work is only checked against
+	// the difficulty the block declares, which its parent is needed to verify: the orphan pool also
+	// requires a difficulty close to ours, so that it only holds blocks that took real work to mine
+	if reason := checkBlockContents(newBlock); reason != "" {
+		return "", fmt.Errorf("%w: %s", ErrBlockRejected, reason)
+	}
+	parent, exists := b.tree.nodes[newBlock.PreviousBlockHash]
+	if !exists {
+		var minDifficulty int = b.difficulty.MinOrphanDifficulty(b.difficulty.NextDifficulty(b.Chain))
+		if newBlock.Difficulty < minDifficulty {
+			return "", fmt.Errorf("%w: block with unknown parent has difficulty %d, below %d", ErrBlockRejected, newBlock.Difficulty, minDifficulty)
+		}
+		b.tree.addOrphan(newBlock)
+		return BlockOrphan, nil
+	}
+
+	// Most blocks simply extend the main chain
+	var tip Block = b.Chain[len(b.Chain)-1]
+	if parent.block.Hash == tip.Hash {
+		var state *ledger = b.ledger.clone()
+		if reason := b.checkNewBlockHash(newBlock, state); reason != "" {
+			return "", fmt.Errorf("%w: %s", ErrBlockRejected, reason)
+		}
+		if err := b.store.AppendBlock(newBlock); err != nil {
+			return "", err
+		}
+		b.Chain = append(b.Chain, newBlock)
+		b.bidIndex.addBlock(newBlock)
+		b.ledger = state
+		b.tree.add(newBlock)
+
+		// Bids and auction records in the new block, and those it made stale, are no longer pending
+		b.prunePending(newBlock)
+		b.pruneSideBranches()
+		b.notifyTipChanged(newBlock)
+		result.Added = append(result.Added, AddedBlock{Block: newBlock, Status: BlockConnected})
+		result.Connected = append(result.Connected, newBlock)
+		return BlockConnected, nil
+	}
+
+	// The block is on a side branch: check its place in that branch
+	var branch Blocks = b.branchTo(parent)
+	if branch == nil {
+		return "", fmt.Errorf("%w: block forks more than %d blocks below the tip", ErrBlockRejected, maxForkDepth)
+	}
+	if reason := b.checkBlockPlacement(branch, newBlock); reason != "" {
+		return "", fmt.Errorf("%w: %s", ErrBlockRejected, reason)
+	}
+	var node *blockNode = b.tree.add(newBlock)
+	if node.work.Cmp(b.tree.nodes[tip.Hash].work) <= 0 {
+		// On equal work the branch seen first is kept
+		result.Added = append(result.Added, AddedBlock{Block: newBlock, Status: BlockSideBranch})
+		return BlockSideBranch, nil
+	}
+	connected, reorg, err := b.reorganize(append(branch, newBlock))
+	if err != nil {
+		return "", err
+	}
+	result.Added = append(result.Added, AddedBlock{Block: newBlock, Status: BlockReorganized})
+	result.Connected = append(result.Connected, connected...)
+	if reorg != nil {
+		result.Reorgs = append(result.Reorgs, *reorg)
+	}
+	return BlockReorganized, nil
+}
+
+// branchTo returns the chain that ends with the given node: the main chain up to the fork point,
+// followed by the side branch blocks. Returns nil if the branch cannot be followed back to the main
+// chain (its blocks were dropped). Must be called with mutex held
+func (b *BlockChain) branchTo(node *blockNode) Blocks {
+	var sideBlocks Blocks = Blocks{}
+	for {
+		var block Block = node.block
+		if block.Index >= 1 && block.Index <= len(b.Chain) && b.Chain[block.Index-1].Hash == block.Hash {
+			var branch Blocks = append(Blocks{}, b.Chain[:block.Index]...)
+			for i := len(sideBlocks) - 1; i >= 0; i-- {
+				branch = append(branch, sideBlocks[i])
+			}
+			return branch
+		}
+		sideBlocks = append(sideBlocks, block)
+		parent, exists := b.tree.nodes[block.PreviousBlockHash]
+		if !exists {
+			return nil
+		}
+		node = parent
+	}
+}
+
+// reorganize makes newChain the main chain. newChain must have more work than the main chain. Its
+// blocks after the fork point are checked against the ledger first; if one is not valid, it is
+// dropped from the tree with its descendants and an error wrapping ErrBlockRejected is returned.
+// Returns the blocks that joined the main chain and the reorganization (see switchChain). Must be
+// called with mutex held
+func (b *BlockChain) reorganize(newChain Blocks) (Blocks, *ReorgEvent, error) {
+	var forkLength int = commonPrefixLength(b.Chain, newChain)
+	var state *ledger = rebuildLedger(newChain[:forkLength])
+	for i := forkLength; i < len(newChain); i++ {
+		if reason := b.checkBlock(newChain[:i], state, newChain[i]); reason != "" {
+			b.tree.removeBranch(newChain[i].Hash)
+			return nil, nil, fmt.Errorf("%w: block %d of the new branch: %s", ErrBlockRejected, newChain[i].Index, reason)
+		}
+	}
+	reorg, err := b.switchChain(newChain, forkLength, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(Blocks{}, newChain[forkLength:]...), reorg, nil
+}
+
+// switchChain replaces the main chain with newChain, whose first forkLength blocks are the same as
+// the main chain's. state is the ledger after newChain. The bids and auction records of the
+// disconnected blocks go back to the pending ones (unless newChain confirms them); all are then
+// checked against the new chain. Returns the reorganization, also kept for GET /reorgs, or nil if no
+// block was disconnected. Must be called with mutex held
+func (b *BlockChain) switchChain(newChain Blocks, forkLength int, state *ledger) (*ReorgEvent, error) {
+	if err := b.store.ReplaceChain(newChain); err != nil {
+		return nil, err
+	}
+	var oldChain Blocks = b.Chain
+	b.Chain = newChain
+	b.bidIndex = rebuildBidIndex(newChain)
+	b.ledger = state
+	b.tree.addChain(newChain)
+
+	var connectedRecords map[string]bool = map[string]bool{}
+	for _, block := range newChain[forkLength:] {
+		for _, record := range block.Auctions {
+			connectedRecords[record.Hash()] = true
+		}
+	}
+	var abandonedBids Bids = Bids{}
+	var abandonedAuctions AuctionRecords = AuctionRecords{}
+	for _, block := range oldChain[forkLength:] {
+		for _, bid := range block.Bids {
+			if _, confirmed := b.bidIndex.byHash[bid.Hash()]; !confirmed {
+				abandonedBids = append(abandonedBids, bid)
+			}
+		}
+		for _, record := range block.Auctions {
+			if !connectedRecords[record.Hash()] {
+				abandonedAuctions = append(abandonedAuctions, record)
+			}
+		}
+	}
+
+	// The abandoned bids and records are older than the pending ones, so they go first
+	var bids *mempool = newMempool()
+	for _, bid := range abandonedBids {
+		bids.add(bid, time.Now())
+	}
+	for _, entry := range b.mempool.list() {
+		bids.add(entry.bid, entry.receivedAt)
+	}
+	b.mempool = bids
+	b.PendingAuctions = append(abandonedAuctions, b.PendingAuctions...)
+	b.prunePending(Block{})
+
+	var oldTip Block = oldChain[len(oldChain)-1]
+	var newTip Block = newChain[len(newChain)-1]
+	var reorg *ReorgEvent = nil
+	if len(oldChain) > forkLength {
+		var event ReorgEvent = ReorgEvent{
+			Time:                  time.Now(),
+			ForkIndex:             newChain[forkLength-1].Index,
+			ForkHash:              newChain[forkLength-1].Hash,
+			OldTipIndex:           oldTip.Index,
+			OldTipHash:            oldTip.Hash,
+			NewTipIndex:           newTip.Index,
+			NewTipHash:            newTip.Hash,
+			DisconnectedBlocks:    len(oldChain) - forkLength,
+			ConnectedBlocks:       len(newChain) - forkLength,
+			ReturnedBids:          countPending(b.mempool, abandonedBids),
+			ReturnedAuctionRecords: countPendingAuctions(b.PendingAuctions, abandonedAuctions),
+		}
+		b.reorgs = append(b.reorgs, event)
+		if len(b.reorgs) > maxReorgEvents {
+			b.reorgs = b.reorgs[len(b.reorgs)-maxReorgEvents:]
+		}
+		log.Printf("Chain reorganized at block %d: %d blocks disconnected (old tip %d %s), %d connected (new tip %d %s), "+
+			"%d bids and %d auction records returned to pending", event.ForkIndex, event.DisconnectedBlocks,
+			oldTip.Index, oldTip.Hash, event.ConnectedBlocks, newTip.Index, newTip.Hash,
+			event.ReturnedBids, event.ReturnedAuctionRecords)
+		reorg = &event
+	}
+
+	b.pruneSideBranches()
+	b.notifyTipChanged(newTip)
+	return reorg, nil
+}
+
+// pruneSideBranches drops the side branch blocks that are maxForkDepth blocks or more below the tip.
+// Must be called with mutex held
+func (b *BlockChain) pruneSideBranches() {
+	var oldestIndex int = len(b.Chain) - maxForkDepth
+	for hash, node := range b.tree.nodes {
+		var index int = node.block.Index
+		if index < oldestIndex && (index < 1 || index > len(b.Chain) || b.Chain[index-1].Hash != hash) {
+			delete(b.tree.nodes, hash)
+		}
+	}
+}
+
+// GetBlockByHash gets a block of any known branch by hash. Returns false if no such block is known
+func (b *BlockChain) GetBlockByHash(hash string) (Block, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	node, exists := b.tree.nodes[hash]
+	if !exists {
+		return Block{}, false
+	}
+	return node.block, true
+}
+
+// GetChainWork gets the cumulative work of the main chain
+func (b *BlockChain) GetChainWork() *big.Int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return new(big.Int).Set(b.tree.nodes[b.Chain[len(b.Chain)-1].Hash].work)
+}
+
+// GetForkStatus gets the state of the block tree: the work of the main chain, the number of side
+// branch and orphan blocks, and the last reorganizations
+func (b *BlockChain) GetForkStatus() ForkStatus {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var tip Block = b.Chain[len(b.Chain)-1]
+	return ForkStatus{
+		TipIndex:         tip.Index,
+		TipHash:          tip.Hash,
+		ChainWork:        b.tree.nodes[tip.Hash].work.String(),
+		SideBranchBlocks: len(b.tree.nodes) - len(b.Chain),
+		OrphanBlocks:     len(b.tree.orphans),
+		Reorgs:           append([]ReorgEvent{}, b.reorgs...),
+	}
+}
+
+// commonPrefixLength returns the number of blocks two chains have in common at their start
+func commonPrefixLength(chain Blocks, other Blocks) int {
+	var length int = 0
+	for length < len(chain) && length < len(other) && chain[length].Hash == other[length].Hash {
+		length++
+	}
+	return length
+}
+
+// countPending counts the bids of returned that are among the pending bids
+func countPending(pending *mempool, returned Bids) int {
+	var count int = 0
+	for _, bid := range returned {
+		if pending.has(bid.Hash()) {
+			count++
+		}
+	}
+	return count
+}
+
+// countPendingAuctions counts the auction records of returned that are among the pending records
+func countPendingAuctions(pending AuctionRecords, returned AuctionRecords) int {
+	var pendingHashes map[string]bool = map[string]bool{}
+	for _, record := range pending {
+		pendingHashes[record.Hash()] = true
+	}
+	var count int = 0
+	for _, record := range returned {
+		if pendingHashes[record.Hash()] {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,108 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+// Blocks of a longer branch that arrive children first wait in the orphan pool; once the fork block
+// arrives they are adopted, the node reorganizes to that branch and the bids of the disconnected block
+// go back to the mempool
+func TestReorgAdoptsOrphans(t *testing.T) {
+	var b, other *BlockChain = newTestChain(t), newTestChain(t)
+	var seller, alice ed25519.PrivateKey = newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	if err := b.RegisterAuctionRecord(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	shared, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.AddBlock(shared); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node confirms a bid in block 3, the other chain mines blocks 3 to 5 without it
+	var bid Bid = testBid(alice, 1, "10.00", 1)
+	if err = b.RegisterBid(bid); err != nil {
+		t.Fatal(err)
+	}
+	disconnected, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var branch Blocks = Blocks{}
+	for len(branch) < 3 {
+		block, err := mineBlock(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, block)
+	}
+
+	for _, block := range []Block{branch[2], branch[1]} {
+		result, err := b.AddBlock(block)
+		if err != nil || result.Status != BlockOrphan {
+			t.Fatalf("block %d: status %q, error %v, expected an orphan", block.Index, result.Status, err)
+		}
+	}
+	if status := b.GetForkStatus(); status.OrphanBlocks != 2 || status.TipHash != disconnected.Hash {
+		t.Fatalf("fork status %+v before the fork block", status)
+	}
+
+	// The fork block has the same work as the main chain, its orphans then make its branch the longest
+	result, err := b.AddBlock(branch[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string = []string{}
+	for _, added := range result.Added {
+		statuses = append(statuses, added.Status)
+	}
+	if result.Status != BlockSideBranch || len(statuses) != 3 || statuses[1] != BlockReorganized || statuses[2] != BlockConnected {
+		t.Fatalf("status %q, added blocks %v", result.Status, statuses)
+	}
+	if len(result.Connected) != 3 || len(result.Reorgs) != 1 || result.Reorgs[0].NewTipHash != branch[1].Hash {
+		t.Fatalf("%d blocks connected, reorganizations %+v", len(result.Connected), result.Reorgs)
+	}
+	if !sameBlocks(b.Chain, other.Chain) {
+		t.Fatalf("node did not follow the other branch: height %d", len(b.Chain))
+	}
+	var forkStatus ForkStatus = b.GetForkStatus()
+	if forkStatus.OrphanBlocks != 0 || forkStatus.SideBranchBlocks != 1 || len(forkStatus.Reorgs) != 1 {
+		t.Fatalf("fork status %+v after the reorganization", forkStatus)
+	}
+	var reorg ReorgEvent = forkStatus.Reorgs[0]
+	if reorg.ForkHash != shared.Hash || reorg.OldTipHash != disconnected.Hash || reorg.DisconnectedBlocks != 1 ||
+		reorg.ConnectedBlocks != 2 || reorg.ReturnedBids != 1 {
+		t.Fatalf("wrong reorganization %+v", reorg)
+	}
+	if pending := b.GetPendingBids(); len(pending) != 1 || pending[0] != bid {
+		t.Fatalf("bid of the disconnected block not pending again: %+v", pending)
+	}
+
+	// The returned bid is confirmed by the next block
+	block, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Bids) != 1 || block.Bids[0] != bid || len(b.GetPendingBids()) != 0 {
+		t.Fatalf("returned bid not mined again")
+	}
+}
+
+func sameBlocks(chain Blocks, other Blocks) bool {
+	if len(chain) != len(other) {
+		return false
+	}
+	for i := range chain {
+		if chain[i].Hash != other[i].Hash {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+/* Genesis configuration. All nodes of a network must start from the same genesis block, so the genesis
+block is built from a configuration file rather than from the clock:
+{
+	"chain_id": "miniblockchain-staging",
+	"timestamp": 1627171722582903400,
+	"difficulty": {
+		"initial_difficulty": 16,
+		"min_difficulty": 8,
+		"max_difficulty": 48,
+		"target_block_time": 10000000000,
+		"retarget_interval": 10,
+		"fixed": false
+	},
+	"auctions": [
+		{
+			"type": "create",
+			"auction_id": 1,
+			"seller": "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c",
+			"item": "Signed football",
+			"open_time": 1627171722582903400,
+			"close_time": 1658707722582903400,
+			"signature": "9a1e07c3f1b4b5f0d6a1c2b3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3..."
+		}
+	]
+}
+The chain id identifies the network: it is part of the hash of every block (see blockencoding.go), it is
+signed in every bid (see encoding.go) and it is checked in the handshake of the join protocol (see
+join.go), so nodes, blocks and bids of another network are rejected. The timestamp (Unix time in
+nanoseconds) is the timestamp of the genesis block. difficulty is the difficulty rules of the chain (see
+difficulty.go); fields left out of the file take their default value (see DefaultDifficultyConfig). The
+genesis block is not mined and has no parent, so its previous block hash is the hash of the difficulty
+rules (see DifficultyConfig.Hash): the genesis hash commits to the rules, and nodes that do not follow
+the same rules refuse each other's handshake. auctions are signed create records (see
+SignAuctionRecord) of the auctions that exist from the start. The same file therefore always gives the
+same genesis hash, which nodes log when they start */
+package bid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// Fixed values of the genesis block
+const (
+	genesisNonce            = 100
+	defaultGenesisTimestamp = 1627171722582903400
+)
+
+// Chain ids are short lower case names, i.e. "miniblockchain-staging"
+var chainIdPattern *regexp.Regexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{0,63}$`)
+
+// GenesisConfig configures the genesis block of a chain (see the doc comment of this file)
+type GenesisConfig struct {
+	ChainId    string           `json:"chain_id"`
+	Timestamp  int64            `json:"timestamp"`
+	Difficulty DifficultyConfig `json:"difficulty"`
+	Auctions   AuctionRecords   `json:"auctions,omitempty"`
+}
+
+// DefaultGenesisConfig returns the genesis of nodes started without a genesis file: chain
+// DefaultChainId, a fixed timestamp, the default difficulty rules and no auctions
+func DefaultGenesisConfig() GenesisConfig {
+	return GenesisConfig{
+		ChainId:    DefaultChainId,
+		Timestamp:  defaultGenesisTimestamp,
+		Difficulty: DefaultDifficultyConfig(),
+		Auctions:   AuctionRecords{},
+	}
+}
+
+// TestGenesisConfig returns the default genesis with the test difficulty rules (see
+// TestDifficultyConfig). Its genesis hash differs from the default one, so test nodes only join test
+// nodes
+func TestGenesisConfig() GenesisConfig {
+	var config GenesisConfig = DefaultGenesisConfig()
+	config.Difficulty = TestDifficultyConfig()
+	return config
+}
+
+// LoadGenesisConfig reads a genesis file and checks that it gives a valid genesis block
+func LoadGenesisConfig(path string) (GenesisConfig, error) {
+	var config GenesisConfig = GenesisConfig{Difficulty: DefaultDifficultyConfig()}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to read genesis file %s: %w", path, err)
+	}
+	if config.Auctions == nil {
+		config.Auctions = AuctionRecords{}
+	}
+	if _, err = config.Block(); err != nil {
+		return config, fmt.Errorf("genesis file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Block builds the genesis block. An error is returned if the configuration is not valid: a chain id
+// that does not match chainIdPattern, a timestamp that is not positive, difficulty rules that cannot be
+// followed, or auction records that are not valid create records
+func (config GenesisConfig) Block() (Block, error) {
+	switch {
+	case !chainIdPattern.MatchString(config.ChainId):
+		return Block{}, fmt.Errorf("chain id %q must be 1 to 64 lower case letters, digits, '.' or '-'", config.ChainId)
+	case config.Timestamp <= 0:
+		return Block{}, fmt.Errorf("timestamp must be a positive Unix time in nanoseconds")
+	}
+	if err := config.Difficulty.check(); err != nil {
+		return Block{}, fmt.Errorf("difficulty rules: %w", err)
+	}
+
+	var auctions AuctionRecords = append(AuctionRecords{}, config.Auctions...)
+	var genesisBlock Block = Block{
+		Version:           BlockVersion,
+		ChainId:           config.ChainId,
+		Index:             1,
+		Timestamp:         config.Timestamp,
+		Bids:              Bids{},
+		Auctions:          auctions,
+		Nonce:             genesisNonce,
+		MerkleRoot:        emptyMerkleRoot,
+		AuctionRoot:       ComputeAuctionRoot(auctions),
+		PreviousBlockHash: config.Difficulty.Hash(),
+	}
+	genesisBlock.Hash = genesisBlock.Header().Hash()
+
+	// The auctions are applied like those of any other block
+	if reason := newLedger().applyBlock(genesisBlock); reason != "" {
+		return Block{}, fmt.Errorf("genesis block is not valid: %s", reason)
+	}
+	return genesisBlock, nil
+}
+
+// checkGenesisBlock returns the reason why the first block of a chain is not the expected genesis
+// block, or "" if it is. The hash covers the whole header, so checking the contents and the hash is
+// enough
+func checkGenesisBlock(genesisBlock Block, expected Block) string {
+	switch {
+	case genesisBlock.ChainId != expected.ChainId:
+		return fmt.Sprintf("genesis block is for chain %q, expected chain %q", genesisBlock.ChainId, expected.ChainId)
+	case genesisBlock.Version != BlockVersion:
+		return fmt.Sprintf("genesis block has version %d, expected %d", genesisBlock.Version, BlockVersion)
+	case len(genesisBlock.Bids) != 0:
+		return "genesis block must not contain bids"
+	}
+	if reason := checkBlockContents(genesisBlock); reason != "" {
+		return "genesis block: " + reason
+	}
+	if genesisBlock.Hash != expected.Hash {
+		return fmt.Sprintf("genesis block has hash %q, expected %q (another genesis configuration?)", genesisBlock.Hash, expected.Hash)
+	}
+	return ""
+}
+
+// ChainId gets the id of the chain followed by this node
+func (b *BlockChain) ChainId() string {
+	return b.genesis.ChainId
+}
+
+// GetGenesisBlock gets the genesis block of the chain followed by this node
+func (b *BlockChain) GetGenesisBlock() Block {
+	return b.genesis
+}
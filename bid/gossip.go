@@ -0,0 +1,306 @@
+/* Gossip: how bids, auction records and blocks travel between nodes. A node used to POST every message
+to every known node, one after the other, from inside the request handler, so that a slow node stalled
+the caller and messages bounced between nodes. Now a message accepted by a node is:
+	1. remembered by hash in a seen set for seenMessageExpiry, so that it is relayed once
+	2. sent to a random subset of gossipFanout peers (never back to the node it came from)
+	3. sent by background workers from a bounded queue, with a timeout per peer and a few retries
+The number of hops a message may still travel is sent in the X-Gossip-Ttl header. A node publishing a
+message gives it gossipTTL hops; each node that accepts it relays it with one hop less. Messages without
+the header (i.e., a bid sent by a client to POST /bid) are not relayed.
+When the queue is full, new deliveries are dropped (and counted): the other peers relay the message, and
+consensus catches up with anything that is lost. GET /gossip shows the counters of the gossip layer */
+package bid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header carrying the number of hops a gossiped message may still travel
+const gossipTTLHeader = "X-Gossip-Ttl"
+
+// Settings of the gossip layer
+const (
+	gossipFanout         = 4
+	gossipTTL            = 6
+	gossipWorkers        = 8
+	gossipQueueSize      = 1024
+	gossipPeerTimeout    = 5 * time.Second
+	gossipMaxAttempts    = 3
+	gossipRetryDelay     = 500 * time.Millisecond
+	seenMessageExpiry    = 10 * time.Minute
+	maxSeenMessages      = 100000
+	seenMessagePruneTime = time.Minute
+)
+
+// gossipDelivery is a message waiting to be sent to one peer
+type gossipDelivery struct {
+	peer    string
+	api     string
+	body    []byte
+	ttl     int
+	attempt int
+}
+
+// Gossip propagates messages to the peers of a node. peers returns the urls of the known nodes
+type Gossip struct {
+	selfUrl string
+	peers   func() []string
+	client  *http.Client
+	queue   chan gossipDelivery
+	stop    chan struct{}
+	workers sync.WaitGroup
+
+	mutex      sync.Mutex
+	random     *rand.Rand
+	seen       map[string]time.Time
+	lastPrune  time.Time
+	started    bool
+	stopped    bool
+	counters   GossipMetrics
+	peerCounts map[string]*PeerGossipMetrics
+}
+
+// NewGossip creates the gossip layer of the node with the given url. Messages are sent once started
+func NewGossip(selfUrl string, peers func() []string) *Gossip {
+	var gossip *Gossip = &Gossip{
+		selfUrl:    selfUrl,
+		peers:      peers,
+		client:     &http.Client{Timeout: gossipPeerTimeout},
+		queue:      make(chan gossipDelivery, gossipQueueSize),
+		stop:       make(chan struct{}),
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		seen:       map[string]time.Time{},
+		lastPrune:  time.Now(),
+		peerCounts: map[string]*PeerGossipMetrics{},
+	}
+	return gossip
+}
+
+// Start starts the workers that send the queued messages. Later calls, and calls after Stop, do nothing
+func (g *Gossip) Start() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.started || g.stopped {
+		return
+	}
+	g.started = true
+	for worker := 0; worker < gossipWorkers; worker++ {
+		g.workers.Add(1)
+		go g.work()
+	}
+}
+
+// Stop stops the workers once the deliveries they are sending are done. Queued deliveries are dropped
+func (g *Gossip) Stop() {
+	g.mutex.Lock()
+	if g.stopped {
+		g.mutex.Unlock()
+		return
+	}
+	g.stopped = true
+	close(g.stop)
+	g.mutex.Unlock()
+	g.workers.Wait()
+}
+
+// Seen checks if the message with the given hash was published or relayed recently
+func (g *Gossip) Seen(hash string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	seenAt, seen := g.seen[hash]
+	return seen && time.Since(seenAt) < seenMessageExpiry
+}
+
+// Publish sends a message to a random subset of peers, except the node it came from (exclude), with
+// ttl hops left. A message that was seen recently is not sent again, and false is returned. A message
+// whose ttl is used up is only marked as seen
+func (g *Gossip) Publish(api string, hash string, body []byte, ttl int, exclude string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var now time.Time = time.Now()
+	if seenAt, seen := g.seen[hash]; seen && now.Sub(seenAt) < seenMessageExpiry {
+		g.counters.Duplicates++
+		return false
+	}
+	g.markSeen(hash, now)
+	if ttl <= 0 {
+		return true
+	}
+
+	g.counters.Published++
+	for _, peer := range g.choosePeers(exclude) {
+		g.enqueue(gossipDelivery{peer: peer, api: api, body: body, ttl: ttl, attempt: 1})
+	}
+	return true
+}
+
+// SendToAll sends a message to every peer but exclude, bypassing the seen set and the fanout. Used
+// for the messages every node must receive, such as the registration of a new node
+func (g *Gossip) SendToAll(api string, body []byte, exclude string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for _, peer := range g.peers() {
+		if peer != g.selfUrl && peer != exclude {
+			g.enqueue(gossipDelivery{peer: peer, api: api, body: body, attempt: 1})
+		}
+	}
+}
+
+// GetMetrics gets the counters of the gossip layer
+func (g *Gossip) GetMetrics() GossipMetrics {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var metrics GossipMetrics = g.counters
+	metrics.Fanout = gossipFanout
+	metrics.TTL = gossipTTL
+	metrics.Workers = gossipWorkers
+	metrics.QueueLength = len(g.queue)
+	metrics.QueueCapacity = cap(g.queue)
+	metrics.SeenMessages = len(g.seen)
+	metrics.Peers = make(map[string]PeerGossipMetrics, len(g.peerCounts))
+	for peer, counts := range g.peerCounts {
+		metrics.Peers[peer] = *counts
+	}
+	return metrics
+}
+
+// markSeen adds a hash to the seen set. Expired hashes are pruned from time to time; if the set is
+// still full, arbitrary hashes are dropped (at worst, a message is relayed twice). Called with the
+// mutex held
+func (g *Gossip) markSeen(hash string, now time.Time) {
+	if len(g.seen) >= maxSeenMessages || now.Sub(g.lastPrune) >= seenMessagePruneTime {
+		for seenHash, seenAt := range g.seen {
+			if now.Sub(seenAt) >= seenMessageExpiry {
+				delete(g.seen, seenHash)
+			}
+		}
+		for seenHash := range g.seen {
+			if len(g.seen) < maxSeenMessages {
+				break
+			}
+			delete(g.seen, seenHash)
+		}
+		g.lastPrune = now
+	}
+	g.seen[hash] = now
+}
+
+// choosePeers picks up to gossipFanout random peers, other than this node and exclude. Called with
+// the mutex held
+func (g *Gossip) choosePeers(exclude string) []string {
+	var candidates []string = []string{}
+	for _, peer := range g.peers() {
+		if peer != g.selfUrl && peer != exclude {
+			candidates = append(candidates, peer)
+		}
+	}
+	g.random.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > gossipFanout {
+		candidates = candidates[:gossipFanout]
+	}
+	return candidates
+}
+
+// enqueue queues a delivery, or drops it if the queue is full or the workers are stopped. Called
+// with the mutex held
+func (g *Gossip) enqueue(delivery gossipDelivery) {
+	if g.stopped {
+		return
+	}
+	select {
+	case g.queue <- delivery:
+	default:
+		g.counters.Dropped++
+		log.Printf("Gossip queue is full: dropping %s for %s", delivery.api, delivery.peer)
+	}
+}
+
+// work sends queued deliveries until the gossip layer is stopped
+func (g *Gossip) work() {
+	defer g.workers.Done()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case delivery := <-g.queue:
+			g.deliver(delivery)
+		}
+	}
+}
+
+// deliver sends a message to one peer. Failed deliveries are retried after an exponential backoff,
+// unless the peer refused the message (4xx): sending it again would not change the answer
+func (g *Gossip) deliver(delivery gossipDelivery) {
+	var start time.Time = time.Now()
+	retry, err := g.post(delivery)
+	var latency time.Duration = time.Since(start)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	counts, exists := g.peerCounts[delivery.peer]
+	if !exists {
+		counts = &PeerGossipMetrics{}
+		g.peerCounts[delivery.peer] = counts
+	}
+	if err == nil {
+		g.counters.Sent++
+		counts.Sent++
+		counts.totalLatency += latency
+		counts.AverageLatencyMs = float64(counts.totalLatency.Microseconds()) / float64(counts.Sent) / 1000
+		var sentAt time.Time = time.Now()
+		counts.LastSentAt = &sentAt
+		return
+	}
+
+	g.counters.Failed++
+	counts.Failed++
+	counts.LastError = err.Error()
+	if !retry || delivery.attempt >= gossipMaxAttempts {
+		log.Printf("Gossip of %s to %s failed: %s", delivery.api, delivery.peer, err)
+		return
+	}
+	g.counters.Retried++
+	delivery.attempt++
+	time.AfterFunc(gossipRetryDelay<<(delivery.attempt-2), func() {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		g.enqueue(delivery)
+	})
+}
+
+// post sends a delivery and tells whether a failure is worth a retry (the peer could not be reached
+// or failed with a 5xx status)
+func (g *Gossip) post(delivery gossipDelivery) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.peer+delivery.api, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	request.Header.Set(nodeUrlHeader, g.selfUrl)
+	if delivery.ttl > 0 {
+		request.Header.Set(gossipTTLHeader, fmt.Sprint(delivery.ttl))
+	}
+	response, err := g.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return response.StatusCode >= http.StatusInternalServerError, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,133 @@
+package bid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls condition until it holds, or fails the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	var deadline time.Time = time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// gossipPeers serves peers that count the messages they receive and answer with status
+type gossipPeers struct {
+	servers []*httptest.Server
+
+	mutex    sync.Mutex
+	received map[string]int
+	ttls     map[string]string
+}
+
+func newGossipPeers(count int, status func(peer string) int) *gossipPeers {
+	var peers *gossipPeers = &gossipPeers{received: map[string]int{}, ttls: map[string]string{}}
+	for i := 0; i < count; i++ {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			peers.mutex.Lock()
+			peers.received[server.URL]++
+			peers.ttls[server.URL] = request.Header.Get(gossipTTLHeader)
+			peers.mutex.Unlock()
+			writer.WriteHeader(status(server.URL))
+		}))
+		peers.servers = append(peers.servers, server)
+	}
+	return peers
+}
+
+func (p *gossipPeers) urls() []string {
+	var urls []string = []string{}
+	for _, server := range p.servers {
+		urls = append(urls, server.URL)
+	}
+	return urls
+}
+
+func (p *gossipPeers) total() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var total int
+	for _, count := range p.received {
+		total += count
+	}
+	return total
+}
+
+func (p *gossipPeers) close() {
+	for _, server := range p.servers {
+		server.Close()
+	}
+}
+
+// A message goes to gossipFanout peers, never back to its sender, and only once
+func TestGossipFanout(t *testing.T) {
+	var peers *gossipPeers = newGossipPeers(gossipFanout+3, func(string) int { return http.StatusOK })
+	defer peers.close()
+	var sender string = peers.servers[0].URL
+	var gossip *Gossip = NewGossip("http://localhost:9100", peers.urls)
+	gossip.Start()
+	defer gossip.Stop()
+
+	if !gossip.Publish("/bid", "hash", []byte("{}"), gossipTTL, sender) {
+		t.Fatalf("new message not published")
+	}
+	if gossip.Publish("/bid", "hash", []byte("{}"), gossipTTL, "") || !gossip.Seen("hash") {
+		t.Fatalf("message published twice")
+	}
+	if !gossip.Publish("/bid", "last hop", []byte("{}"), 0, "") || !gossip.Seen("last hop") {
+		t.Fatalf("message without hops left not marked as seen")
+	}
+	waitFor(t, 5*time.Second, "the deliveries", func() bool { return gossip.GetMetrics().Sent == gossipFanout })
+	time.Sleep(50 * time.Millisecond)
+
+	var metrics GossipMetrics = gossip.GetMetrics()
+	if metrics.Published != 1 || metrics.Duplicates != 1 || metrics.Sent != gossipFanout || peers.total() != gossipFanout {
+		t.Fatalf("metrics %+v, %d messages received", metrics, peers.total())
+	}
+	peers.mutex.Lock()
+	defer peers.mutex.Unlock()
+	if peers.received[sender] != 0 {
+		t.Fatalf("message sent back to its sender")
+	}
+	for peer, ttl := range peers.ttls {
+		if ttl != "6" {
+			t.Fatalf("%s got ttl %q", peer, ttl)
+		}
+	}
+}
+
+// Deliveries that fail with 5xx are retried, those refused with 4xx are not
+func TestGossipRetries(t *testing.T) {
+	var peers *gossipPeers
+	peers = newGossipPeers(2, func(peer string) int {
+		if peer == peers.servers[0].URL {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusBadRequest
+	})
+	defer peers.close()
+	var gossip *Gossip = NewGossip("http://localhost:9100", peers.urls)
+	gossip.Start()
+	defer gossip.Stop()
+
+	gossip.SendToAll("/register-node", []byte("{}"), "")
+	waitFor(t, 10*time.Second, "the retries", func() bool { return gossip.GetMetrics().Failed == gossipMaxAttempts+1 })
+	time.Sleep(50 * time.Millisecond)
+
+	var metrics GossipMetrics = gossip.GetMetrics()
+	peers.mutex.Lock()
+	defer peers.mutex.Unlock()
+	if metrics.Retried != gossipMaxAttempts-1 || peers.received[peers.servers[0].URL] != gossipMaxAttempts || peers.received[peers.servers[1].URL] != 1 {
+		t.Fatalf("metrics %+v, received %v", metrics, peers.received)
+	}
+}
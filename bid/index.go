@@ -0,0 +1,152 @@
+/* Secondary indexes over the bids stored in the chain. Bids are stored inside blocks, so finding
+all bids for an auction or a player would require a scan of the whole chain. Instead, each time a
+block is added to the chain, the location of each of its bids is recorded in a map keyed by auction
+id and in a map keyed by player (the bidder's public key), with the hash of the bid. Pending bids are
+indexed the same way by the mempool (see mempool.go). Queries then only visit the bids they return */
+package bid
+
+import (
+	"sort"
+)
+
+// Default and maximum number of bids returned in a single page of a bid query
+const (
+	defaultBidPageSize = 50
+	maxBidPageSize     = 500
+)
+
+// Sort orders supported by bid queries
+const (
+	SortBidsByTime  = "time"
+	SortBidsByValue = "value"
+)
+
+// indexedBid is the location of a bid in the chain, with the hash of the bid so that queries do not
+// hash the bids they return
+type indexedBid struct {
+	location BidLocation
+	hash     string
+}
+
+// bidIndex maps auction ids and player ids (public keys) to the locations of their bids in the
+// chain, and bid hashes to the location of the bid
+type bidIndex struct {
+	byAuction map[int][]indexedBid
+	byPlayer  map[string][]indexedBid
+	byHash    map[string]BidLocation
+}
+
+func newBidIndex() *bidIndex {
+	return &bidIndex{
+		byAuction: map[int][]indexedBid{},
+		byPlayer:  map[string][]indexedBid{},
+		byHash:    map[string]BidLocation{},
+	}
+}
+
+// addBlock records the location of every bid in the given block
+func (index *bidIndex) addBlock(block Block) {
+	for position, bid := range block.Bids {
+		var entry indexedBid = indexedBid{
+			location: BidLocation{
+				BlockIndex: block.Index,
+				BlockHash:  block.Hash,
+				Position:   position,
+			},
+			hash: bid.Hash(),
+		}
+		index.byAuction[bid.AuctionId] = append(index.byAuction[bid.AuctionId], entry)
+		index.byPlayer[bid.PublicKey] = append(index.byPlayer[bid.PublicKey], entry)
+		index.byHash[entry.hash] = entry.location
+	}
+}
+
+// rebuildBidIndex creates a new index from scratch for the given chain. Used when the whole
+// chain is replaced (i.e., consensus)
+func rebuildBidIndex(chain Blocks) *bidIndex {
+	var index *bidIndex = newBidIndex()
+	for _, block := range chain {
+		index.addBlock(block)
+	}
+	return index
+}
+
+// runBidQuery turns the indexed locations of confirmed bids, plus the matching pending bids (in arrival
+// order, see mempool.listForAuction and mempool.listForBidder) when the query includes them, into a
+// sorted page of bid records
+func (b *BlockChain) runBidQuery(confirmed []indexedBid, pending []*mempoolEntry, query BidQuery) BidQueryResult {
+	// Confirmed bids, in chain order. Block indexes start at 1, so the block with index i is Chain[i-1]
+	var records []BidRecord = make([]BidRecord, 0, len(confirmed)+len(pending))
+	for _, entry := range confirmed {
+		var block Block = b.Chain[entry.location.BlockIndex-1]
+		records = append(records, BidRecord{
+			Bid:           block.Bids[entry.location.Position],
+			BidHash:       entry.hash,
+			Confirmed:     true,
+			Confirmations: len(b.Chain) - block.Index + 1,
+			BidLocation:   entry.location,
+			Timestamp:     block.Timestamp,
+		})
+	}
+
+	// Pending bids come from the indexes of the mempool. Their position is their position among the
+	// matching pending bids
+	if query.IncludePending {
+		for position, entry := range pending {
+			records = append(records, BidRecord{
+				Bid:         entry.bid,
+				BidHash:     entry.hash,
+				Confirmed:   false,
+				BidLocation: BidLocation{Position: position},
+			})
+		}
+	}
+
+	// Records are already in time order: confirmed bids in chain order, then pending bids in
+	// arrival order. A stable sort by value keeps the time order among equal values
+	if query.SortBy == SortBidsByValue {
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].BidValue.Cmp(records[j].BidValue) < 0
+		})
+	}
+	if query.Descending {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	// Extract the requested page
+	var result BidQueryResult = BidQueryResult{
+		Total:    len(records),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+		Bids:     []BidRecord{},
+	}
+	// Compare page numbers before multiplying: a large page times the page size would overflow
+	var pages int = (len(records) + query.PageSize - 1) / query.PageSize
+	if query.Page-1 < pages {
+		var start int = (query.Page - 1) * query.PageSize
+		var end int = start + query.PageSize
+		if end > len(records) {
+			end = len(records)
+		}
+		result.Bids = records[start:end]
+	}
+	return result
+}
+
+// normalize fills in defaults for the paging fields of a query and caps the page size
+func (query *BidQuery) normalize() {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = defaultBidPageSize
+	}
+	if query.PageSize > maxBidPageSize {
+		query.PageSize = maxBidPageSize
+	}
+	if query.SortBy == "" {
+		query.SortBy = SortBidsByTime
+	}
+}
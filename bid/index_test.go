@@ -0,0 +1,81 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// Auction and player queries return the confirmed bids from the index and the pending bids from the
+// indexes of the mempool, with their hashes
+func TestBidQueries(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var seller, alice, bob ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	for auctionId := 1; auctionId <= 2; auctionId++ {
+		if err := b.RegisterAuctionRecord(testAuction(seller, auctionId, func(record *AuctionRecord) {
+			record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+		})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var confirmed Bids = Bids{testBid(alice, 1, "10.00", 1), testBid(bob, 2, "20.00", 1), testBid(bob, 1, "30.00", 2)}
+	var pending Bids = Bids{testBid(alice, 2, "40.00", 2), testBid(alice, 1, "5.00", 3), testBid(bob, 1, "50.00", 3)}
+	for _, bid := range confirmed {
+		if err := b.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, bid := range pending {
+		if err := b.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var aliceKey string = hex.EncodeToString(alice.Public().(ed25519.PublicKey))
+	var tests = []struct {
+		name     string
+		query    func(query BidQuery) BidQueryResult
+		options  BidQuery
+		expected Bids
+	}{
+		{"auction", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(1, query) }, BidQuery{},
+			Bids{confirmed[0], confirmed[2]}},
+		{"auction with pending bids", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(1, query) },
+			BidQuery{IncludePending: true}, Bids{confirmed[0], confirmed[2], pending[1], pending[2]}},
+		{"auction by value", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(1, query) },
+			BidQuery{IncludePending: true, SortBy: SortBidsByValue, Descending: true},
+			Bids{pending[2], confirmed[2], confirmed[0], pending[1]}},
+		{"auction second page", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(1, query) },
+			BidQuery{IncludePending: true, Page: 2, PageSize: 3}, Bids{pending[2]}},
+		{"page far past the end", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(1, query) },
+			BidQuery{IncludePending: true, Page: 1 << 62, PageSize: 3}, Bids{}},
+		{"player", func(query BidQuery) BidQueryResult { return b.GetBidsForPlayer(aliceKey, query) }, BidQuery{},
+			Bids{confirmed[0]}},
+		{"player with pending bids", func(query BidQuery) BidQueryResult { return b.GetBidsForPlayer(aliceKey, query) },
+			BidQuery{IncludePending: true}, Bids{confirmed[0], pending[0], pending[1]}},
+		{"unknown auction", func(query BidQuery) BidQueryResult { return b.GetBidsForAuction(3, query) },
+			BidQuery{IncludePending: true}, Bids{}},
+	}
+	for _, test := range tests {
+		var result BidQueryResult = test.query(test.options)
+		if len(result.Bids) != len(test.expected) {
+			t.Errorf("%s: got %d bids, expected %d", test.name, len(result.Bids), len(test.expected))
+			continue
+		}
+		for i, record := range result.Bids {
+			var expected Bid = test.expected[i]
+			var isPending bool = false
+			for _, bid := range pending {
+				isPending = isPending || bid.Hash() == expected.Hash()
+			}
+			if record.Bid != expected || record.BidHash != expected.Hash() || record.Confirmed == isPending {
+				t.Errorf("%s: bid %d is %+v, expected %+v (pending %v)", test.name, i, record, expected, isPending)
+			}
+		}
+	}
+}
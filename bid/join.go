@@ -0,0 +1,331 @@
+/* Join protocol. A node joins the network by calling POST /register-and-broadcast-node on a node it
+knows (a seed, see PeerConfig.Seeds) with a handshake describing itself (NodeInfo): a random node id,
+the protocol version it speaks, the id and genesis hash of the chain it follows, the public url other
+nodes reach it at and the height of its chain. Every node serves its handshake at GET /. The seed:
+	1. checks the handshake: a valid url, the same protocol version, chain id and genesis hash (so the
+	   same genesis block and difficulty rules, see genesis.go), and not itself. It then fetches the
+	   handshake served at the claimed url, which must be the same node (same node id)
+	2. adds the new node to its known nodes, and if it was not known, sends the handshake to all its
+	   known nodes (POST /register-node), which check and add it the same way
+	3. answers with its own NodeInfo and the list of its other known nodes
+The joining node checks the seed's NodeInfo the same way and adds the seed. It then sends its handshake to
+each of the seed's nodes (POST /register-node) and adds those whose answer passes the same checks: a node
+is never added on hearsay. Nodes registered in bulk (POST /register-nodes-bulk) are handshaked the same
+way. When a handshake advertises a chain higher than ours, the node syncs with it (see sync.go). Joining
+again is harmless: a known node is not broadcast again, and every step only adds what is missing.
+Node urls are normalized to scheme://host:port, so "http://localhost:9000/" and "http://localhost:9000"
+are the same node */
+package bid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the node to node protocol. Nodes only join nodes of the same version
+const ProtocolVersion = 1
+
+// DefaultChainId is the id of the chain followed by nodes started without a genesis file (see
+// genesis.go). Nodes only join nodes of the same chain
+const DefaultChainId = "miniblockchain"
+
+// Join attempts: a node tries all its seeds, and tries again later if none answered
+const (
+	joinTimeout    = 10 * time.Second
+	joinAttempts   = 6
+	joinRetryDelay = 10 * time.Second
+)
+
+// Errors returned when a handshake is refused
+var (
+	// ErrInvalidNodeUrl is returned when a node url is not an absolute http(s) url without path
+	ErrInvalidNodeUrl = errors.New("invalid node url")
+	// ErrSelfRegistration is returned when a node is asked to register itself
+	ErrSelfRegistration = errors.New("a node cannot register itself")
+	// ErrIncompatibleNode is returned when a node speaks another protocol version or follows another chain
+	// (another chain id or genesis block)
+	ErrIncompatibleNode = errors.New("incompatible node")
+	// ErrUnverifiedNode is returned when the url in a handshake does not serve the handshake of the same
+	// node (see verifyNode)
+	ErrUnverifiedNode = errors.New("node is not at the url it claims")
+)
+
+// normalizeNodeUrl checks that a node url is an absolute http or https url with a host and no path,
+// query or fragment, and returns it as scheme://host[:port] in lower case
+func normalizeNodeUrl(rawUrl string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrInvalidNodeUrl, rawUrl, err)
+	}
+	var scheme string = strings.ToLower(parsed.Scheme)
+	switch {
+	case scheme != "http" && scheme != "https":
+		return "", fmt.Errorf("%w %q: scheme must be http or https", ErrInvalidNodeUrl, rawUrl)
+	case parsed.Host == "" || parsed.Hostname() == "":
+		return "", fmt.Errorf("%w %q: host is missing", ErrInvalidNodeUrl, rawUrl)
+	case parsed.User != nil:
+		return "", fmt.Errorf("%w %q: user info is not allowed", ErrInvalidNodeUrl, rawUrl)
+	case (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "":
+		return "", fmt.Errorf("%w %q: path, query and fragment are not allowed", ErrInvalidNodeUrl, rawUrl)
+	}
+	return scheme + "://" + strings.ToLower(parsed.Host), nil
+}
+
+// newNodeId creates a random node id. A node gets a new id each time it starts
+func newNodeId() string {
+	var id []byte = make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// nodeInfo returns the handshake of this node
+func (c *Controller) nodeInfo() NodeInfo {
+	return NodeInfo{
+		NodeId:          c.nodeId,
+		ProtocolVersion: ProtocolVersion,
+		ChainId:         c.chainId,
+		GenesisHash:     c.blockChain.GetGenesisBlock().Hash,
+		PublicUrl:       c.currentNodeUrl,
+		ChainHeight:     c.blockChain.GetLastBlock().Index,
+	}
+}
+
+// checkNodeInfo checks the handshake of another node, and returns it with its url normalized
+func (c *Controller) checkNodeInfo(info NodeInfo) (NodeInfo, error) {
+	nodeUrl, err := normalizeNodeUrl(info.PublicUrl)
+	if err != nil {
+		return info, err
+	}
+	info.PublicUrl = nodeUrl
+	switch {
+	case info.NodeId == c.nodeId || info.PublicUrl == c.currentNodeUrl:
+		return info, ErrSelfRegistration
+	case info.NodeId == "":
+		return info, fmt.Errorf("%w: node_id is missing", ErrIncompatibleNode)
+	case info.ProtocolVersion != ProtocolVersion:
+		return info, fmt.Errorf("%w: protocol version %d, expected %d", ErrIncompatibleNode, info.ProtocolVersion, ProtocolVersion)
+	case info.ChainId != c.chainId:
+		return info, fmt.Errorf("%w: chain %q, expected %q", ErrIncompatibleNode, info.ChainId, c.chainId)
+	case info.GenesisHash != c.blockChain.GetGenesisBlock().Hash:
+		return info, fmt.Errorf("%w: genesis block %s, expected %s (another genesis file or difficulty rules?)",
+			ErrIncompatibleNode, info.GenesisHash, c.blockChain.GetGenesisBlock().Hash)
+	}
+	return info, nil
+}
+
+// handshakeStatus returns the HTTP status of a refused handshake
+func handshakeStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidNodeUrl):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSelfRegistration), errors.Is(err, ErrUnverifiedNode):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrIncompatibleNode):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// registerNodeUrls handshakes the given nodes that are not known yet, concurrently, and adds those whose
+// handshake is accepted (see handshake). This node, invalid urls and nodes that refuse or fail the
+// handshake are skipped. Nodes whose chain is higher than ours are synced with (see syncWithPeersAhead).
+// Returns how many nodes were added, were already known and were skipped
+func (c *Controller) registerNodeUrls(nodes []string) (added int, known int, skipped int) {
+	var newNodes map[string]bool = map[string]bool{}
+	for _, node := range nodes {
+		nodeUrl, err := normalizeNodeUrl(node)
+		switch {
+		case err != nil || nodeUrl == c.currentNodeUrl:
+			skipped++
+		case c.isKnownNode(nodeUrl) || newNodes[nodeUrl]:
+			known++
+		default:
+			newNodes[nodeUrl] = true
+		}
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var accepted []NodeInfo = []NodeInfo{}
+	for nodeUrl := range newNodes {
+		wg.Add(1)
+		go func(nodeUrl string) {
+			defer wg.Done()
+			info, err := c.handshake(nodeUrl)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.Printf("Not adding node %s: %s", nodeUrl, err)
+				skipped++
+				return
+			}
+			accepted = append(accepted, info)
+		}(nodeUrl)
+	}
+	wg.Wait()
+
+	for _, info := range accepted {
+		if c.blockChain.RegisterPeer(info) {
+			added++
+		} else {
+			known++
+		}
+	}
+	c.syncWithPeersAhead(accepted...)
+	return added, known, skipped
+}
+
+// handshake sends our handshake to a node (POST /register-node), which registers this node, and checks
+// the handshake it answers with. The node must answer with the url it was reached at, so that a node
+// cannot be added under the url of another
+func (c *Controller) handshake(nodeUrl string) (NodeInfo, error) {
+	var info NodeInfo
+	if err := c.postHandshake(nodeUrl+"/register-node", &info); err != nil {
+		return info, err
+	}
+	info, err := c.checkNodeInfo(info)
+	if err != nil {
+		return info, err
+	}
+	if info.PublicUrl != nodeUrl {
+		return info, fmt.Errorf("%w: node at %s answered as %s", ErrIncompatibleNode, nodeUrl, info.PublicUrl)
+	}
+	return info, nil
+}
+
+// verifyNode checks that the node that sent us a handshake is at the url it claims: the handshake
+// served at that url (GET /) must pass the same checks and have the same node id. Anyone can post a
+// handshake, so without this any url (an internal host, say) could be put in the known nodes of the
+// whole network, which would then probe it, gossip to it and sync from it. Returns the handshake served
+// at the url, whose chain height is more recent
+func (c *Controller) verifyNode(claimed NodeInfo) (NodeInfo, error) {
+	var client *http.Client = &http.Client{Timeout: joinTimeout}
+	httpResponse, err := client.Get(claimed.PublicUrl + "/")
+	if err != nil {
+		return claimed, fmt.Errorf("%w: node %s cannot be reached: %s", ErrUnverifiedNode, claimed.PublicUrl, err)
+	}
+	defer httpResponse.Body.Close()
+	var info NodeInfo
+	if httpResponse.StatusCode != http.StatusOK || json.NewDecoder(httpResponse.Body).Decode(&info) != nil {
+		return claimed, fmt.Errorf("%w: no handshake at %s (status %d)", ErrUnverifiedNode, claimed.PublicUrl, httpResponse.StatusCode)
+	}
+	if info, err = c.checkNodeInfo(info); err != nil {
+		return claimed, err
+	}
+	if info.NodeId != claimed.NodeId || info.PublicUrl != claimed.PublicUrl {
+		return claimed, fmt.Errorf("%w: node %s at %s claims to be node %s", ErrUnverifiedNode,
+			info.NodeId, info.PublicUrl, claimed.NodeId)
+	}
+	return info, nil
+}
+
+// postHandshake sends our handshake to the given api of a node, and reads its answer into response
+func (c *Controller) postHandshake(apiUrl string, response interface{}) error {
+	body, _ := json.Marshal(c.nodeInfo())
+	var client *http.Client = &http.Client{Timeout: joinTimeout}
+	request, err := http.NewRequest(http.MethodPost, apiUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	request.Header.Set(nodeUrlHeader, c.currentNodeUrl)
+	httpResponse, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	data, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		var refusal ApiResponse
+		json.Unmarshal(data, &refusal)
+		return fmt.Errorf("handshake refused with status %d: %s", httpResponse.StatusCode, refusal.Status)
+	}
+	if err = json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("invalid handshake response: %w", err)
+	}
+	return nil
+}
+
+// syncWithPeersAhead syncs with the given peers whose chain is higher than ours, as advertised in their
+// handshake, in the background. The sync only switches to a chain that has more work (see sync.go)
+func (c *Controller) syncWithPeersAhead(peers ...NodeInfo) {
+	var height int = c.blockChain.GetLastBlock().Index
+	var ahead []string = []string{}
+	for _, peer := range peers {
+		if peer.ChainHeight > height {
+			ahead = append(ahead, peer.PublicUrl)
+		}
+	}
+	if len(ahead) == 0 {
+		return
+	}
+	go func() {
+		log.Printf("Nodes %v are ahead of our chain height %d: syncing", ahead, height)
+		if _, err := c.syncer.Sync(ahead); err != nil && !errors.Is(err, ErrSyncInProgress) {
+			log.Printf("Sync with nodes %v stopped: %s", ahead, err)
+		}
+	}()
+}
+
+// joinNetwork joins the network through the given seeds (see the doc comment of this file). All seeds
+// are tried; if none answers, they are tried again after joinRetryDelay, up to joinAttempts times or
+// until the node shuts down
+func (c *Controller) joinNetwork(seeds []string) {
+	if len(seeds) == 0 {
+		return
+	}
+	for attempt := 1; attempt <= joinAttempts; attempt++ {
+		var joined bool = false
+		for _, seed := range seeds {
+			if err := c.joinThrough(seed); err != nil {
+				log.Printf("Failed to join the network through %s: %s", seed, err)
+				continue
+			}
+			joined = true
+		}
+		if joined {
+			return
+		}
+		select {
+		case <-c.quit:
+			return
+		case <-time.After(joinRetryDelay):
+		}
+	}
+	log.Printf("Could not join the network: no seed answered after %d attempts", joinAttempts)
+}
+
+// joinThrough sends our handshake to a seed, then adds the seed and handshakes the nodes it knows. If
+// the seed is ahead of us, we sync with it
+func (c *Controller) joinThrough(seed string) error {
+	var joinResponse JoinResponse
+	if err := c.postHandshake(seed+"/register-and-broadcast-node", &joinResponse); err != nil {
+		return err
+	}
+	seedInfo, err := c.checkNodeInfo(joinResponse.Node)
+	if err != nil {
+		return err
+	}
+	var added int = 0
+	if c.blockChain.RegisterPeer(seedInfo) {
+		added++
+	}
+	c.syncWithPeersAhead(seedInfo)
+	peersAdded, _, _ := c.registerNodeUrls(joinResponse.Peers)
+	log.Printf("Joined the network through %s (chain height %d): %d new nodes", seedInfo.PublicUrl, seedInfo.ChainHeight, added+peersAdded)
+	return nil
+}
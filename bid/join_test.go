@@ -0,0 +1,84 @@
+package bid
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestController returns the controller of a node reached at the given url, with a test chain
+func newTestController(t *testing.T, url string) *Controller {
+	t.Helper()
+	var b *BlockChain = newTestChain(t)
+	return &Controller{
+		blockChain:     b,
+		gossip:         NewGossip(url, b.GetNetworkNodes),
+		currentNodeUrl: url,
+		nodeId:         newNodeId(),
+		chainId:        DefaultChainId,
+		events:         NewEventBus(),
+	}
+}
+
+// A handshake is only accepted from the node that serves it at the claimed url: a client cannot put the
+// url of another node, or of a host that is not a node, in the known nodes of the network
+func TestRegisterNodeVerifiesClaimedUrl(t *testing.T) {
+	var handlers [2]http.Handler
+	var servers [2]*httptest.Server
+	var controllers [2]*Controller
+	for i := range servers {
+		var i int = i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			handlers[i].ServeHTTP(writer, request)
+		}))
+		defer servers[i].Close()
+	}
+	for i := range controllers {
+		controllers[i] = newTestController(t, servers[i].URL)
+		defer controllers[i].gossip.Stop()
+		var mux *http.ServeMux = http.NewServeMux()
+		mux.HandleFunc("/", controllers[i].Index)
+		mux.HandleFunc("/register-node", controllers[i].RegisterNode)
+		mux.HandleFunc("/register-and-broadcast-node", controllers[i].RegisterAndBroadcastNode)
+		handlers[i] = mux
+	}
+	// Some host that is not a node
+	var other *httptest.Server = httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	var genuine NodeInfo = controllers[1].nodeInfo()
+	var impostor NodeInfo = genuine
+	impostor.NodeId = newNodeId()
+	var notANode NodeInfo = genuine
+	notANode.PublicUrl = other.URL
+	var tests = []struct {
+		name   string
+		info   NodeInfo
+		status int
+	}{
+		{"another node id", impostor, http.StatusUnprocessableEntity},
+		{"not a node", notANode, http.StatusUnprocessableEntity},
+		{"genuine", genuine, http.StatusOK},
+	}
+	for _, test := range tests {
+		for _, api := range []string{"/register-node", "/register-and-broadcast-node"} {
+			body, _ := json.Marshal(test.info)
+			response, err := http.Post(servers[0].URL+api, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("%s %s: %v", test.name, api, err)
+				continue
+			}
+			response.Body.Close()
+			if response.StatusCode != test.status {
+				t.Errorf("%s %s: got status %d, expected %d", test.name, api, response.StatusCode, test.status)
+			}
+		}
+	}
+
+	var peers []string = controllers[0].blockChain.GetNetworkNodes()
+	if len(peers) != 1 || peers[0] != servers[1].URL {
+		t.Fatalf("known nodes %v, expected only %s", peers, servers[1].URL)
+	}
+}
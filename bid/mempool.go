@@ -0,0 +1,227 @@
+/* Mempool: the bids waiting to be mined. Bids are keyed by hash, so a bid received twice (i.e.,
+broadcast by several nodes) is only kept once, and every bid is checked when it enters (see
+RegisterBid). The mempool is bounded: bids that waited longer than maxPendingBidAge are evicted, and a
+new bid is refused with ErrMempoolFull while the mempool holds maxPendingBids bids or
+maxPendingBidBytes bytes (the size of the bids' canonical encoding).
+Bids leave the mempool when a block confirms them, or when a block makes them invalid (i.e., a
+replayed sequence number, or a bid for an auction that was closed); other pending bids stay */
+package bid
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Limits of the mempool
+const (
+	maxPendingBidAge   = 24 * time.Hour
+	maxPendingBids     = 50000
+	maxPendingBidBytes = 16 << 20
+)
+
+// mempoolEntry is a pending bid, with its hash, when it was received and its encoded size
+type mempoolEntry struct {
+	bid        Bid
+	hash       string
+	receivedAt time.Time
+	size       int
+	arrival    uint64
+}
+
+// mempool holds the pending bids by hash, with indexes by bidder sequence number, by auction and by
+// bidder (public key)
+type mempool struct {
+	entries    map[string]*mempoolEntry
+	bySequence map[string]string
+	byAuction  map[int]map[string]bool
+	byBidder   map[string]map[string]bool
+	bytes      int
+	arrivals   uint64
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		entries:    map[string]*mempoolEntry{},
+		bySequence: map[string]string{},
+		byAuction:  map[int]map[string]bool{},
+		byBidder:   map[string]map[string]bool{},
+	}
+}
+
+// sequenceKey identifies a bid by bidder and sequence number
+func sequenceKey(publicKey string, sequence uint64) string {
+	return fmt.Sprintf("%s/%d", publicKey, sequence)
+}
+
+// add adds a bid received at the given time. A bid that is already in the mempool is ignored
+func (pool *mempool) add(bid Bid, receivedAt time.Time) {
+	var hash string = bid.Hash()
+	if _, exists := pool.entries[hash]; exists {
+		return
+	}
+	pool.arrivals++
+	var entry *mempoolEntry = &mempoolEntry{
+		bid:        bid,
+		hash:       hash,
+		receivedAt: receivedAt,
+		size:       len(bid.canonicalBytes()),
+		arrival:    pool.arrivals,
+	}
+	pool.entries[hash] = entry
+	pool.bySequence[sequenceKey(bid.PublicKey, bid.Sequence)] = hash
+	if pool.byAuction[bid.AuctionId] == nil {
+		pool.byAuction[bid.AuctionId] = map[string]bool{}
+	}
+	pool.byAuction[bid.AuctionId][hash] = true
+	if pool.byBidder[bid.PublicKey] == nil {
+		pool.byBidder[bid.PublicKey] = map[string]bool{}
+	}
+	pool.byBidder[bid.PublicKey][hash] = true
+	pool.bytes += entry.size
+}
+
+// remove removes the bid with the given hash, if it is in the mempool
+func (pool *mempool) remove(hash string) {
+	entry, exists := pool.entries[hash]
+	if !exists {
+		return
+	}
+	delete(pool.entries, hash)
+	if pool.bySequence[sequenceKey(entry.bid.PublicKey, entry.bid.Sequence)] == hash {
+		delete(pool.bySequence, sequenceKey(entry.bid.PublicKey, entry.bid.Sequence))
+	}
+	delete(pool.byAuction[entry.bid.AuctionId], hash)
+	if len(pool.byAuction[entry.bid.AuctionId]) == 0 {
+		delete(pool.byAuction, entry.bid.AuctionId)
+	}
+	delete(pool.byBidder[entry.bid.PublicKey], hash)
+	if len(pool.byBidder[entry.bid.PublicKey]) == 0 {
+		delete(pool.byBidder, entry.bid.PublicKey)
+	}
+	pool.bytes -= entry.size
+}
+
+// has checks if the bid with the given hash is in the mempool
+func (pool *mempool) has(hash string) bool {
+	_, exists := pool.entries[hash]
+	return exists
+}
+
+// hasSequence checks if the mempool holds a bid of the given bidder with the given sequence number
+func (pool *mempool) hasSequence(publicKey string, sequence uint64) bool {
+	_, exists := pool.bySequence[sequenceKey(publicKey, sequence)]
+	return exists
+}
+
+// isFull checks if a bid of the given encoded size would exceed the limits of the mempool
+func (pool *mempool) isFull(size int) bool {
+	return len(pool.entries) >= maxPendingBids || pool.bytes+size > maxPendingBidBytes
+}
+
+// expire removes the bids received before the given time and returns how many were removed
+func (pool *mempool) expire(receivedBefore time.Time) int {
+	var expired int = 0
+	for hash, entry := range pool.entries {
+		if entry.receivedAt.Before(receivedBefore) {
+			pool.remove(hash)
+			expired++
+		}
+	}
+	return expired
+}
+
+// sortedEntries returns the given entries in arrival order
+func sortedEntries(entries []*mempoolEntry) []*mempoolEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].arrival < entries[j].arrival
+	})
+	return entries
+}
+
+// list returns the entries of the mempool in arrival order
+func (pool *mempool) list() []*mempoolEntry {
+	var entries []*mempoolEntry = make([]*mempoolEntry, 0, len(pool.entries))
+	for _, entry := range pool.entries {
+		entries = append(entries, entry)
+	}
+	return sortedEntries(entries)
+}
+
+// listForAuction returns the entries of the bids for an auction in arrival order
+func (pool *mempool) listForAuction(auctionId int) []*mempoolEntry {
+	return pool.listHashes(pool.byAuction[auctionId])
+}
+
+// listForBidder returns the entries of the bids of a bidder (public key) in arrival order
+func (pool *mempool) listForBidder(publicKey string) []*mempoolEntry {
+	return pool.listHashes(pool.byBidder[publicKey])
+}
+
+// listHashes returns the entries of the given bid hashes in arrival order
+func (pool *mempool) listHashes(hashes map[string]bool) []*mempoolEntry {
+	var entries []*mempoolEntry = make([]*mempoolEntry, 0, len(hashes))
+	for hash := range hashes {
+		entries = append(entries, pool.entries[hash])
+	}
+	return sortedEntries(entries)
+}
+
+// bids returns the bids of the mempool in arrival order
+func (pool *mempool) bids() Bids {
+	var bids Bids = Bids{}
+	for _, entry := range pool.list() {
+		bids = append(bids, entry.bid)
+	}
+	return bids
+}
+
+// pendingBidRecords converts mempool entries for GET /mempool/bids
+func pendingBidRecords(entries []*mempoolEntry) []PendingBidRecord {
+	var records []PendingBidRecord = make([]PendingBidRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, PendingBidRecord{Bid: entry.bid, BidHash: entry.hash, ReceivedAt: entry.receivedAt})
+	}
+	return records
+}
+
+// GetPendingBids gets the pending bids in arrival order
+func (b *BlockChain) GetPendingBids() Bids {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.mempool.bids()
+}
+
+// GetPendingBidRecords gets the pending bids in arrival order, with their hash and arrival time. If
+// auctionId is not nil, only the bids for that auction are returned
+func (b *BlockChain) GetPendingBidRecords(auctionId *int) []PendingBidRecord {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if auctionId != nil {
+		return pendingBidRecords(b.mempool.listForAuction(*auctionId))
+	}
+	return pendingBidRecords(b.mempool.list())
+}
+
+// GetMempoolStatus gets the size and limits of the mempool
+func (b *BlockChain) GetMempoolStatus() MempoolStatus {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var status MempoolStatus = MempoolStatus{
+		Count:    len(b.mempool.entries),
+		Bytes:    b.mempool.bytes,
+		Auctions: len(b.mempool.byAuction),
+		MaxCount: maxPendingBids,
+		MaxBytes: maxPendingBidBytes,
+		MaxAge:   maxPendingBidAge.String(),
+	}
+	for _, entry := range b.mempool.entries {
+		if status.OldestReceivedAt == nil || entry.receivedAt.Before(*status.OldestReceivedAt) {
+			var receivedAt time.Time = entry.receivedAt
+			status.OldestReceivedAt = &receivedAt
+		}
+	}
+	return status
+}
@@ -0,0 +1,191 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMempoolIndexes(t *testing.T) {
+	var pool *mempool = newMempool()
+	var bids Bids = Bids{
+		{AuctionId: 1, PublicKey: "alice", Sequence: 1},
+		{AuctionId: 2, PublicKey: "bob", Sequence: 1},
+		{AuctionId: 1, PublicKey: "bob", Sequence: 2},
+		{AuctionId: 2, PublicKey: "alice", Sequence: 2},
+	}
+	var now time.Time = time.Now()
+	for _, bid := range bids {
+		pool.add(bid, now)
+	}
+	var bytes int = pool.bytes
+	pool.add(bids[0], now.Add(time.Minute))
+	if len(pool.entries) != len(bids) || pool.bytes != bytes || pool.entries[bids[0].Hash()].receivedAt != now {
+		t.Fatalf("adding a pending bid again changed the mempool: %d bids, %d bytes", len(pool.entries), pool.bytes)
+	}
+
+	var expectEntries = func(name string, entries []*mempoolEntry, expected ...Bid) {
+		t.Helper()
+		if len(entries) != len(expected) {
+			t.Fatalf("%s: got %d bids, expected %d", name, len(entries), len(expected))
+		}
+		for i, entry := range entries {
+			if entry.bid != expected[i] || entry.hash != expected[i].Hash() {
+				t.Fatalf("%s: bid %d is %+v, expected %+v", name, i, entry.bid, expected[i])
+			}
+		}
+	}
+	expectEntries("all", pool.list(), bids...)
+	expectEntries("auction 1", pool.listForAuction(1), bids[0], bids[2])
+	expectEntries("alice", pool.listForBidder("alice"), bids[0], bids[3])
+	expectEntries("unknown bidder", pool.listForBidder("carol"))
+	if !pool.hasSequence("bob", 2) || pool.hasSequence("bob", 3) {
+		t.Fatalf("wrong sequence index")
+	}
+
+	for _, bid := range bids {
+		pool.remove(bid.Hash())
+	}
+	pool.remove(bids[0].Hash())
+	if len(pool.entries) != 0 || len(pool.bySequence) != 0 || len(pool.byAuction) != 0 || len(pool.byBidder) != 0 || pool.bytes != 0 {
+		t.Fatalf("mempool not empty after removing all bids: %+v", pool)
+	}
+}
+
+func TestMempoolExpire(t *testing.T) {
+	var pool *mempool = newMempool()
+	var now time.Time = time.Now()
+	for age := 0; age < 5; age++ {
+		pool.add(Bid{AuctionId: 1, PublicKey: "alice", Sequence: uint64(age + 1)}, now.Add(-time.Duration(age)*time.Hour))
+	}
+	if expired := pool.expire(now.Add(-150 * time.Minute)); expired != 2 {
+		t.Fatalf("expired %d bids, expected 2", expired)
+	}
+	for _, entry := range pool.list() {
+		if entry.bid.Sequence > 3 {
+			t.Fatalf("bid %d should have expired", entry.bid.Sequence)
+		}
+	}
+	if !pool.hasSequence("alice", 3) || pool.hasSequence("alice", 4) {
+		t.Fatalf("sequence index not updated")
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	// By count
+	var pool *mempool = newMempool()
+	var now time.Time = time.Now()
+	for i := 0; i < maxPendingBids; i++ {
+		if pool.isFull(100) {
+			t.Fatalf("full after %d bids", i)
+		}
+		pool.add(Bid{AuctionId: 1, PublicKey: "alice", Sequence: uint64(i + 1)}, now)
+	}
+	if !pool.isFull(100) {
+		t.Fatalf("not full with %d bids", len(pool.entries))
+	}
+
+	// By size
+	pool = newMempool()
+	var name string = strings.Repeat("x", 1<<20)
+	for i := 0; !pool.isFull(1 << 20); i++ {
+		pool.add(Bid{AuctionId: 1, BidderName: name, PublicKey: "alice", Sequence: uint64(i + 1)}, now)
+	}
+	if pool.bytes > maxPendingBidBytes || pool.bytes+(1<<20) <= maxPendingBidBytes || len(pool.entries) >= maxPendingBids {
+		t.Fatalf("full with %d bids of %d bytes", len(pool.entries), pool.bytes)
+	}
+	if pool.isFull(maxPendingBidBytes - pool.bytes) {
+		t.Fatalf("a bid that fits exactly is refused")
+	}
+}
+
+// RegisterBid refuses bids already pending and bids that do not fit, and evicts old bids
+func TestRegisterBidMempool(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var seller, alice, bob ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	if err := b.RegisterAuctionRecord(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	var first Bid = testBid(alice, 1, "10.00", 1)
+	if err := b.RegisterBid(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RegisterBid(first); !errors.Is(err, ErrDuplicateBid) {
+		t.Fatalf("bid registered twice: got %v", err)
+	}
+
+	// Bids that waited too long are evicted when a bid comes in
+	b.mempool.entries[first.Hash()].receivedAt = time.Now().Add(-maxPendingBidAge - time.Second)
+	if err := b.RegisterBid(testBid(bob, 1, "20.00", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if b.mempool.has(first.Hash()) || len(b.GetPendingBids()) != 1 {
+		t.Fatalf("old bid not evicted: %d pending bids", len(b.GetPendingBids()))
+	}
+	if err := b.RegisterBid(first); err != nil {
+		t.Fatalf("evicted bid cannot be sent again: %v", err)
+	}
+
+	// Big bids fill the mempool
+	var err error
+	var name string = strings.Repeat("x", 1<<20)
+	for sequence := uint64(2); err == nil; sequence++ {
+		var bid Bid = Bid{ChainId: DefaultChainId, BidderName: name + strconv.Itoa(int(sequence)), AuctionId: 1,
+			BidValue: MustParseMoney("1.00"), Sequence: sequence}
+		SignBid(&bid, alice)
+		err = b.RegisterBid(bid)
+	}
+	if !errors.Is(err, ErrMempoolFull) || b.GetMempoolStatus().Bytes > maxPendingBidBytes {
+		t.Fatalf("got error %v with %d bytes pending", err, b.GetMempoolStatus().Bytes)
+	}
+}
+
+// A block drops the pending bids it confirms and those it makes invalid, and keeps the others
+func TestMempoolAfterBlock(t *testing.T) {
+	var b, other *BlockChain = newTestChain(t), newTestChain(t)
+	var seller, alice, bob ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	var create AuctionRecord = testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})
+	if err := b.RegisterAuctionRecord(create); err != nil {
+		t.Fatal(err)
+	}
+	block, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other node confirms another bid of alice with the same sequence number
+	var confirmed, replaced, kept Bid = testBid(bob, 1, "10.00", 1), testBid(alice, 1, "20.00", 1), testBid(bob, 1, "30.00", 2)
+	for _, bid := range []Bid{confirmed, testBid(alice, 1, "25.00", 1)} {
+		if err = other.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bid := range []Bid{confirmed, replaced, kept} {
+		if err = b.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if block, err = mineBlock(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	var pending Bids = b.GetPendingBids()
+	if len(pending) != 1 || pending[0] != kept {
+		t.Fatalf("pending bids %+v, expected only %+v", pending, kept)
+	}
+}
@@ -0,0 +1,156 @@
+/* Merkle tree of the bids of a block. Each block stores the root of a Merkle tree built over the
+hashes of its bids, and the block hash covers that root. A Merkle inclusion proof for a bid is the
+list of sibling hashes on the path from the bid to the root: with the bid, the proof and the root
+(and the block hash that covers the root), anyone can check that the bid was recorded in the block
+without downloading the block or the chain.
+
+The tree is built as follows (all hashes are SHA-256, written as hex strings):
+1. The leaves are the bid hashes (see Bid.Hash), in the order of the bids in the block
+2. Leaf node hash = SHA-256(0x00 || bid hash bytes)
+3. Inner node hash = SHA-256(0x01 || left child hash bytes || right child hash bytes)
+4. When a level has an odd number of nodes, the last node moves up to the next level unchanged
+5. The root of a block with no bids is 64 zeros
+The 0x00 and 0x01 prefixes make it impossible to pass an inner node off as a leaf.
+
+The auction records of a block are covered the same way: the block stores the root of a second tree,
+built with the same rules over the hashes of its auction records (see ComputeAuctionRoot) */
+package bid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefixes that separate leaf hashes from inner node hashes
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// emptyMerkleRoot is the Merkle root of a block with no bids
+var emptyMerkleRoot string = strings.Repeat("0", 2*sha256.Size)
+
+// ComputeMerkleRoot computes the Merkle root of the given bids
+func ComputeMerkleRoot(bids Bids) string {
+	var digests [][]byte = make([][]byte, len(bids))
+	for i, bid := range bids {
+		digests[i] = bid.digest()
+	}
+	return merkleRootOf(digests)
+}
+
+// ComputeAuctionRoot computes the Merkle root of the given auction records
+func ComputeAuctionRoot(records AuctionRecords) string {
+	var digests [][]byte = make([][]byte, len(records))
+	for i, record := range records {
+		digests[i] = record.digest()
+	}
+	return merkleRootOf(digests)
+}
+
+// merkleRootOf computes the root of the Merkle tree whose leaves are the given digests
+func merkleRootOf(digests [][]byte) string {
+	if len(digests) == 0 {
+		return emptyMerkleRoot
+	}
+
+	var level [][]byte = make([][]byte, len(digests))
+	for i, digest := range digests {
+		level[i] = hashMerkleLeaf(digest)
+	}
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// BuildMerkleProof builds the inclusion proof of the bid at the given position among bids. The block
+// fields of the proof (index and hash) are left for the caller to fill in
+func BuildMerkleProof(bids Bids, position int) MerkleProof {
+	var proof MerkleProof = MerkleProof{
+		BidHash:    bids[position].Hash(),
+		Position:   position,
+		MerkleRoot: ComputeMerkleRoot(bids),
+		Path:       []MerkleProofStep{},
+	}
+
+	// Walk up the tree, recording the sibling of the current node at each level (if it has one)
+	var level [][]byte = merkleLeaves(bids)
+	var index int = position
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof.Path = append(proof.Path, MerkleProofStep{Hash: hex.EncodeToString(level[index-1]), Left: true})
+		} else if index+1 < len(level) {
+			proof.Path = append(proof.Path, MerkleProofStep{Hash: hex.EncodeToString(level[index+1]), Left: false})
+		}
+		level = nextMerkleLevel(level)
+		index = index / 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that a bid is included under the Merkle root of a proof: the bid hash
+// is recomputed from the bid, then combined with each hash of the proof's path in turn, and the
+// result must be the proof's Merkle root. To trust the root itself, check that it is the Merkle
+// root of the block with the proof's block hash
+func VerifyMerkleProof(bid Bid, proof MerkleProof) bool {
+	if bid.Hash() != proof.BidHash {
+		return false
+	}
+	bidDigest, err := hex.DecodeString(proof.BidHash)
+	if err != nil {
+		return false
+	}
+
+	var current []byte = hashMerkleLeaf(bidDigest)
+	for _, step := range proof.Path {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			current = hashMerkleNode(sibling, current)
+		} else {
+			current = hashMerkleNode(current, sibling)
+		}
+	}
+	return hex.EncodeToString(current) == proof.MerkleRoot
+}
+
+// merkleLeaves returns the leaf node hashes for the given bids
+func merkleLeaves(bids Bids) [][]byte {
+	var leaves [][]byte = make([][]byte, len(bids))
+	for i, bid := range bids {
+		leaves[i] = hashMerkleLeaf(bid.digest())
+	}
+	return leaves
+}
+
+// nextMerkleLevel combines the nodes of one level pairwise into the nodes of the level above
+func nextMerkleLevel(level [][]byte) [][]byte {
+	var next [][]byte = make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, hashMerkleNode(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i]) // odd node out moves up unchanged
+		}
+	}
+	return next
+}
+
+func hashMerkleLeaf(bidDigest []byte) []byte {
+	var hash = sha256.New()
+	hash.Write([]byte{merkleLeafPrefix})
+	hash.Write(bidDigest)
+	return hash.Sum(nil)
+}
+
+func hashMerkleNode(left []byte, right []byte) []byte {
+	var hash = sha256.New()
+	hash.Write([]byte{merkleNodePrefix})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
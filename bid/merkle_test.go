@@ -0,0 +1,151 @@
+package bid
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// merkleTestBids returns count different bids
+func merkleTestBids(count int) Bids {
+	var bids Bids = Bids{}
+	for i := 0; i < count; i++ {
+		bids = append(bids, Bid{BidderName: "bidder " + strconv.Itoa(i), AuctionId: 1})
+	}
+	return bids
+}
+
+func TestMerkleRoot(t *testing.T) {
+	if root := ComputeMerkleRoot(Bids{}); root != emptyMerkleRoot {
+		t.Fatalf("root of no bids: got %s", root)
+	}
+
+	// A single bid: the root is its leaf hash
+	var bids Bids = merkleTestBids(5)
+	if root := ComputeMerkleRoot(bids[:1]); root != hex.EncodeToString(hashMerkleLeaf(bids[0].digest())) {
+		t.Fatalf("root of one bid: got %s", root)
+	}
+
+	// An odd bid out moves up unchanged: with 3 bids, the root combines the node of the first two
+	// bids with the leaf of the third, which is not paired with itself
+	var leaves [][]byte = merkleLeaves(bids[:3])
+	var expected string = hex.EncodeToString(hashMerkleNode(hashMerkleNode(leaves[0], leaves[1]), leaves[2]))
+	if root := ComputeMerkleRoot(bids[:3]); root != expected {
+		t.Fatalf("root of 3 bids: got %s, expected %s", root, expected)
+	}
+	var duplicated Bids = append(append(Bids{}, bids[:3]...), bids[2])
+	if ComputeMerkleRoot(duplicated) == expected {
+		t.Fatalf("duplicating the last bid gives the same root")
+	}
+
+	// The order of the bids matters
+	if ComputeMerkleRoot(Bids{bids[1], bids[0]}) == ComputeMerkleRoot(bids[:2]) {
+		t.Fatalf("swapping bids gives the same root")
+	}
+}
+
+// The leaf and node prefixes keep an inner node from passing as a leaf: a leaf whose data is the
+// concatenation of two child hashes does not hash to their parent
+func TestMerkleDomainSeparation(t *testing.T) {
+	var leaves [][]byte = merkleLeaves(merkleTestBids(2))
+	var parent []byte = hashMerkleNode(leaves[0], leaves[1])
+	var forged []byte = hashMerkleLeaf(append(append([]byte{}, leaves[0]...), leaves[1]...))
+	if hex.EncodeToString(forged) == hex.EncodeToString(parent) {
+		t.Fatalf("an inner node hashes like a leaf")
+	}
+	if merkleRootOf([][]byte{append(append([]byte{}, leaves[0]...), leaves[1]...)}) == ComputeMerkleRoot(merkleTestBids(2)) {
+		t.Fatalf("a one leaf tree has the root of a two leaf tree")
+	}
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		var bids Bids = merkleTestBids(count)
+		var root string = ComputeMerkleRoot(bids)
+		for position := range bids {
+			var proof MerkleProof = BuildMerkleProof(bids, position)
+			if proof.MerkleRoot != root || proof.Position != position || proof.BidHash != bids[position].Hash() {
+				t.Fatalf("%d bids, position %d: got proof %+v", count, position, proof)
+			}
+			if !VerifyMerkleProof(bids[position], proof) {
+				t.Fatalf("%d bids, position %d: proof does not verify", count, position)
+			}
+			// The proof of one bid does not prove another
+			if count > 1 {
+				var other int = (position + 1) % count
+				var wrong MerkleProof = BuildMerkleProof(bids, other)
+				wrong.BidHash = bids[position].Hash()
+				if VerifyMerkleProof(bids[position], wrong) {
+					t.Fatalf("%d bids: proof of position %d verifies the bid at position %d", count, other, position)
+				}
+			}
+		}
+	}
+
+	var bids Bids = merkleTestBids(5)
+	var proof MerkleProof = BuildMerkleProof(bids, 2)
+	var tests = []struct {
+		name   string
+		bid    Bid
+		tamper func(proof *MerkleProof)
+	}{
+		{"other bid", bids[3], func(proof *MerkleProof) {}},
+		{"other bid with its hash", bids[3], func(proof *MerkleProof) { proof.BidHash = bids[3].Hash() }},
+		{"tampered sibling", bids[2], func(proof *MerkleProof) {
+			proof.Path[0].Hash = hex.EncodeToString(hashMerkleLeaf([]byte("tampered")))
+		}},
+		{"sibling on the wrong side", bids[2], func(proof *MerkleProof) { proof.Path[0].Left = !proof.Path[0].Left }},
+		{"short sibling", bids[2], func(proof *MerkleProof) { proof.Path[0].Hash = proof.Path[0].Hash[:62] }},
+		{"missing step", bids[2], func(proof *MerkleProof) { proof.Path = proof.Path[1:] }},
+		{"extra step", bids[2], func(proof *MerkleProof) { proof.Path = append(proof.Path, proof.Path[0]) }},
+		{"other root", bids[2], func(proof *MerkleProof) { proof.MerkleRoot = ComputeMerkleRoot(bids[:4]) }},
+	}
+	for _, test := range tests {
+		var tampered MerkleProof = proof
+		tampered.Path = append([]MerkleProofStep{}, proof.Path...)
+		test.tamper(&tampered)
+		if VerifyMerkleProof(test.bid, tampered) {
+			t.Errorf("%s: proof verifies", test.name)
+		}
+	}
+}
+
+// The proofs served by the chain verify against the Merkle root of the block they name
+func TestGetMerkleProof(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var now int64 = time.Now().UnixNano()
+	if err := b.RegisterAuctionRecord(testAuction(newTestKey(), 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	var bids Bids = Bids{}
+	for i := 1; i <= 3; i++ {
+		var bid Bid = Bid{ChainId: DefaultChainId, AuctionId: 1, BidValue: Money{Units: int64(i * 100)}, Sequence: 1}
+		SignBid(&bid, newTestKey())
+		if err := b.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+		bids = append(bids, bid)
+	}
+	if _, found := b.GetMerkleProof(bids[0].Hash()); found {
+		t.Fatalf("proof of a pending bid")
+	}
+	block, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bid := range bids {
+		proof, found := b.GetMerkleProof(bid.Hash())
+		if !found || proof.BlockIndex != block.Index || proof.BlockHash != block.Hash || proof.MerkleRoot != block.MerkleRoot {
+			t.Fatalf("proof %+v (found %v) does not name block %d (%s, root %s)", proof, found, block.Index, block.Hash, block.MerkleRoot)
+		}
+		if !VerifyMerkleProof(bid, proof) {
+			t.Fatalf("proof of bid %s does not verify", bid.Hash())
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/* Mining runs as a background job rather than inside the HTTP request: GET /mine starts a job and
+returns its id straight away, and the job's progress can be followed with GET /mine/jobs/{jobId}.
+Only one job runs at a time. A job ends when a block is mined, when it is cancelled through the API,
+or when the last block of the chain changes (a block was received or the chain was replaced), in
+which case the block being mined would no longer extend the chain */
+package bid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Possible values of MiningJobStatus.Status
+const (
+	MiningJobRunning   = "running"
+	MiningJobMined     = "mined"
+	MiningJobCancelled = "cancelled"
+	MiningJobFailed    = "failed"
+)
+
+// Number of finished jobs whose status is kept
+const maxFinishedMiningJobs = 100
+
+// Miner runs mining jobs on a blockchain. onMined is called with each block mined by a job
+type Miner struct {
+	blockChain *BlockChain
+	onMined    func(newBlock Block)
+
+	mutex       sync.Mutex
+	jobs        map[string]*miningJob
+	finishedIds []string
+	currentJob  *miningJob
+}
+
+// miningJob is the state of one mining job. attempts is updated by ProofOfWork while the job
+// runs, so it is read and written atomically; everything else is guarded by the miner's mutex
+type miningJob struct {
+	attempts int64
+	status   MiningJobStatus
+	cancel   context.CancelFunc
+}
+
+// NewMiner creates a miner for the given blockchain. Running jobs are cancelled whenever the last
+// block of the chain changes
+func NewMiner(blockChain *BlockChain, onMined func(newBlock Block)) *Miner {
+	var miner *Miner = &Miner{
+		blockChain: blockChain,
+		onMined:    onMined,
+		jobs:       map[string]*miningJob{},
+	}
+	blockChain.OnTipChanged(func(newTip Block) {
+		miner.CancelCurrentJob("chain tip changed")
+	})
+	return miner
+}
+
+// StartJob starts a new mining job and returns its status. If a job is already running, no new job
+// is started and the status of the running job is returned instead, with started set to false
+func (m *Miner) StartJob() (status MiningJobStatus, started bool) {
+	// The candidate is taken before locking the miner: the blockchain calls the miner (to cancel
+	// jobs) while it is locked, so the miner must never wait on the blockchain while it is locked.
+	// If the chain changes before the job is registered, CreateNewBlock will catch the stale block
+	var candidate MiningCandidate = m.blockChain.PrepareMiningCandidate()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.currentJob != nil {
+		return m.statusOf(m.currentJob), false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var job *miningJob = &miningJob{
+		status: MiningJobStatus{
+			JobId:     newMiningJobId(),
+			Status:    MiningJobRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	m.jobs[job.status.JobId] = job
+	m.currentJob = job
+
+	go m.run(ctx, job, candidate)
+
+	return m.statusOf(job), true
+}
+
+// GetJob gets the status of a job. Returns false if there is no job with the given id
+func (m *Miner) GetJob(jobId string) (MiningJobStatus, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[jobId]
+	if !ok {
+		return MiningJobStatus{}, false
+	}
+	return m.statusOf(job), true
+}
+
+// CancelJob cancels a running job. Returns false if there is no job with the given id. Cancelling a
+// job that has already finished does nothing
+func (m *Miner) CancelJob(jobId string, reason string) (MiningJobStatus, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[jobId]
+	if !ok {
+		return MiningJobStatus{}, false
+	}
+	if job == m.currentJob {
+		m.cancelJob(job, reason)
+	}
+	return m.statusOf(job), true
+}
+
+// CancelCurrentJob cancels the running job, if any
+func (m *Miner) CancelCurrentJob(reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.currentJob != nil {
+		m.cancelJob(m.currentJob, reason)
+	}
+}
+
+// run does the work of a job: proof of work on the candidate, then creation of the new block
+func (m *Miner) run(ctx context.Context, job *miningJob, candidate MiningCandidate) {
+	nonce, err := m.blockChain.ProofOfWork(ctx, candidate.Header(0), &job.attempts)
+	if err != nil {
+		m.finishJob(job, MiningJobCancelled, "", Block{})
+		return
+	}
+
+	// Now that we have the nonce, to create a new block we also need a hash for the new block
+	var hash string = m.blockChain.HashBlock(candidate.Header(nonce))
+	newBlock, err := m.blockChain.CreateNewBlock(candidate, nonce, hash)
+	if err == ErrStaleCandidate {
+		m.finishJob(job, MiningJobCancelled, "chain tip changed", Block{})
+		return
+	}
+	if err != nil {
+		m.finishJob(job, MiningJobFailed, err.Error(), Block{})
+		return
+	}
+
+	m.finishJob(job, MiningJobMined, "", newBlock)
+	m.onMined(newBlock)
+}
+
+// finishJob records the outcome of a job. A reason given when the job was cancelled is kept
+func (m *Miner) finishJob(job *miningJob, status string, reason string, newBlock Block) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var now time.Time = time.Now()
+	job.status.Status = status
+	job.status.FinishedAt = &now
+	if reason != "" && job.status.Reason == "" {
+		job.status.Reason = reason
+	}
+	if status == MiningJobMined {
+		job.status.Reason = ""
+		job.status.BlockIndex = newBlock.Index
+		job.status.BlockHash = newBlock.Hash
+	}
+	job.cancel()
+	if m.currentJob == job {
+		m.currentJob = nil
+	}
+
+	// Forget the oldest finished jobs
+	m.finishedIds = append(m.finishedIds, job.status.JobId)
+	if len(m.finishedIds) > maxFinishedMiningJobs {
+		delete(m.jobs, m.finishedIds[0])
+		m.finishedIds = m.finishedIds[1:]
+	}
+}
+
+// cancelJob stops the proof of work of a running job. The job goroutine then finishes the job.
+// Must be called with mutex held
+func (m *Miner) cancelJob(job *miningJob, reason string) {
+	if job.status.Reason == "" {
+		job.status.Reason = reason
+	}
+	job.cancel()
+}
+
+// statusOf returns a copy of a job's status with up to date attempts and hash rate. Must be called
+// with mutex held
+func (m *Miner) statusOf(job *miningJob) MiningJobStatus {
+	var status MiningJobStatus = job.status
+	status.Attempts = atomic.LoadInt64(&job.attempts)
+
+	var end time.Time = time.Now()
+	if status.FinishedAt != nil {
+		end = *status.FinishedAt
+	}
+	if elapsed := end.Sub(status.StartedAt).Seconds(); elapsed > 0 {
+		status.HashRate = float64(status.Attempts) / elapsed
+	}
+	return status
+}
+
+// newMiningJobId creates a random job id
+func newMiningJobId() string {
+	var id []byte = make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -1,50 +1,150 @@
 package bid
 
 import (
+	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
 )
 
-// Bid bid information
+// Bid bid information. The bidder is identified by PublicKey (ed25519, hex) and signs the bid with
+// the matching private key; BidderName is only a display label. Sequence must increase with each
+// bid of the same bidder, so that a signed bid cannot be replayed (see signature.go).
+// In sealed-bid auctions, Kind is BidKindCommit or BidKindReveal and Commitment and Salt hide the
+// value until the reveal window (see sealed.go); other bids leave the three fields empty
 type Bid struct {
+	ChainId    string		`json:"chain_id"`	// Chain the bid is made on (see genesis.go)
 	BidderName string 		`json:"bidder_name"`
 	AuctionId  int    		`json:"auction_id"`
-	BidValue   float32    	`json:"bid_value,string"`	//Note of use string
+	BidValue   Money    	`json:"bid_value"`	// Exact amount written as a string, i.e. "123.45" (see money.go)
+	Kind       string		`json:"kind,omitempty"`
+	Commitment string		`json:"commitment,omitempty"`
+	Salt       string		`json:"salt,omitempty"`
+	PublicKey  string		`json:"public_key"`
+	Sequence   uint64		`json:"sequence"`
+	Signature  string		`json:"signature"`
 }
 type Bids []Bid
 
+// AuctionRecord is an on-chain auction record, signed by the seller (see auction.go). Type is
+// AuctionRecordCreate or AuctionRecordClose; a close record only carries the auction id, the seller
+// and the signature, plus the reserve price and its salt when they were hidden. Mode is
+// AuctionModeOpen (the default) or AuctionModeSealed; sealed auctions also have a reveal window from
+// CloseTime to RevealTime. Rule is the settlement rule (see settlement.go). Currency is the currency
+// of the reserve price and of all bids (none if empty). The reserve price is either
+// public (ReservePrice) or hidden behind ReserveCommitment. Times are Unix times in nanoseconds
+type AuctionRecord struct {
+	Type              string	`json:"type"`
+	AuctionId         int		`json:"auction_id"`
+	Seller            string	`json:"seller"`		// Public key (ed25519, hex) of the seller
+	Item              string	`json:"item,omitempty"`
+	Mode              string	`json:"mode,omitempty"`
+	Rule              string	`json:"rule,omitempty"`
+	Currency          string	`json:"currency,omitempty"`	// ISO 4217 code of all amounts of the auction, if any
+	ReservePrice      Money		`json:"reserve_price"`
+	ReserveCommitment string	`json:"reserve_commitment,omitempty"`
+	ReserveSalt       string	`json:"reserve_salt,omitempty"`
+	OpenTime          int64		`json:"open_time,omitempty"`
+	CloseTime         int64		`json:"close_time,omitempty"`
+	RevealTime        int64		`json:"reveal_time,omitempty"`
+	Signature         string	`json:"signature"`
+}
+type AuctionRecords []AuctionRecord
+
 // Nodes is an alias for an array of strings where each string is the address of a node
 type Nodes []string
 
 // Block Basic structure of a blockchain block
 type  Block struct {
+	Version				int		`json:"version"`	// Encoding of the block (see blockencoding.go)
+	ChainId				string	`json:"chain_id"`	// Chain the block belongs to (see genesis.go)
 	Index 				int 	`json:"index"`
 	Timestamp 			int64	`json:"timestamp"`
 	Bids 				Bids	`json:"bids"`
+	Auctions			AuctionRecords	`json:"auctions"`
 	Nonce 				int		`json:"nonce"`
+	Difficulty			int		`json:"difficulty"`	// Leading zero bits required in Hash
+	MerkleRoot			string	`json:"merkle_root"`	// Root of the Merkle tree of Bids
+	AuctionRoot			string	`json:"auction_root"`	// Root of the Merkle tree of Auctions
 	Hash				string	`json:"hash"`
 	PreviousBlockHash	string 	`json:"previous_block_hash"`
 }
 type Blocks []Block
 
-// BlockData is used in mining to identify
-type BlockData struct {
-	Index string
-	Bids Bids
+// BlockChain basic structure of a blockchain consists of four collections:
+// blocks, pending bids (in the mempool), pending auction records, and available network nodes
+type BlockChain struct {
+	Chain           Blocks   			`json:"chain"`
+	PendingAuctions AuctionRecords		`json:"pending_auctions"`
+	NetworkNodes    map[string]*PeerRecord	`json:"network_nodes"`
+
+	// Pending bids, by hash (serialized as pending_bids, see MarshalJSON)
+	mempool *mempool
+
+	// Proof of work difficulty rules, and the genesis block all chains must start with (not serialized)
+	difficulty DifficultyConfig
+	genesis    Block
+
+	// Secondary indexes of the bids in Chain, kept up to date as blocks are added (not serialized)
+	bidIndex *bidIndex
+
+	// Auctions and bidder sequence numbers after the last block of Chain (not serialized)
+	ledger *ledger
+
+	// Blocks of all known branches and orphan blocks, and the last reorganizations (not serialized)
+	tree   *blockTree
+	reorgs []ReorgEvent
+
+	// Durable copy of the chain, pending bids and network nodes (not serialized)
+	store Storage
+
+	// Functions called when the last block of the chain changes (not serialized)
+	tipListeners []func(newTip Block)
+
+	// mutex guards Chain, mempool, PendingAuctions, bidIndex, ledger, tree, reorgs and tipListeners;
+	// nodesMutex guards NetworkNodes
+	mutex      sync.RWMutex
+	nodesMutex sync.RWMutex
 }
 
-// BlockChain basic structure of a blockchain consists of three collections:
-// blocks, pending bids, and available network nodes
-type BlockChain struct {
-	Chain        Blocks   			`json:"chain"`
-	PendingBids  Bids     			`json:"pending_bids"`
-	NetworkNodes map[string]bool 	`json:"network_nodes"`
+// BlockChainSnapshot is the JSON form of a blockchain, as returned by GET /blockchain. Network nodes
+// are listed by url, with their health (see peers.go)
+type BlockChainSnapshot struct {
+	Chain           Blocks          `json:"chain"`
+	PendingBids     Bids            `json:"pending_bids"`
+	PendingAuctions AuctionRecords  `json:"pending_auctions"`
+	NetworkNodes    []PeerRecord    `json:"network_nodes"`
+}
+
+// MiningCandidate is a snapshot of the data needed to mine the next block: the block's version and
+// index, the hash of the block it follows, its timestamp, the bids and auction records it will contain
+// and the required difficulty. Proof of work hashes its header (see Header)
+type MiningCandidate struct {
+	Version           int
+	ChainId           string
+	Index             int
+	PreviousBlockHash string
+	Timestamp         int64
+	Bids              Bids
+	Auctions          AuctionRecords
+	MerkleRoot        string
+	AuctionRoot       string
+	Difficulty        int
 }
 
 // Controller corresponds to a web api controller with methods to handle all available routes
 type Controller struct {
 	blockChain *BlockChain
-	currentNodeUrl string
+	miner *Miner
+	gossip *Gossip
+	peerMonitor *PeerMonitor
+	syncer *Syncer
+	events *EventBus
+	webhooks *Webhooks
+	quit chan struct{}	// Closed when the node shuts down (see node.go)
+	currentNodeUrl string	// Public url of this node (see PeerConfig.PublicUrl)
+	nodeId string
+	chainId string
 }
 
 // Route struct models the concept of route by specifying route name, http method,
@@ -64,6 +164,438 @@ type ApiResponse struct {
 	Time time.Time
 }
 
-type NewNode struct {
-	url string `json:"new_node_url"`
+// BidLocation identifies where a confirmed bid is stored: the block that contains it and the
+// position of the bid within that block's bids
+type BidLocation struct {
+	BlockIndex int    `json:"block_index,omitempty"`
+	BlockHash  string `json:"block_hash,omitempty"`
+	Position   int    `json:"position"`
+}
+
+// BidRecord is a bid returned by a bid query. Confirmed bids carry the location and timestamp of
+// their block, and the confirmation depth of that block (see explorer.go). Pending bids have no block,
+// and their position is their position in the pending bids (among those of the auction or player, in a
+// bid query)
+type BidRecord struct {
+	Bid
+	BidLocation
+	BidHash       string `json:"bid_hash"`
+	Confirmed     bool   `json:"confirmed"`
+	Confirmations int    `json:"confirmations"`
+	Timestamp     int64  `json:"timestamp,omitempty"`
+}
+
+// BlockRecord is a block returned by the explorer (see explorer.go), with its confirmation depth: the
+// number of blocks of the main chain from the block to the tip, the block included. Blocks on side
+// branches are not on the main chain and have no confirmations
+type BlockRecord struct {
+	Block
+	MainChain     bool `json:"main_chain"`
+	Confirmations int  `json:"confirmations"`
+}
+
+// BlockPage is a page of blocks of the main chain, as returned by GET /blocks. Next is the index to
+// start the next page from (0 if this is the last page)
+type BlockPage struct {
+	TipIndex int           `json:"tip_index"`
+	Blocks   []BlockRecord `json:"blocks"`
+	Next     int           `json:"next,omitempty"`
+}
+
+// BidQuery holds the options of a bid query: whether to include pending bids, how to sort
+// (SortBidsByTime or SortBidsByValue, ascending unless Descending is set) and which page to return
+type BidQuery struct {
+	IncludePending bool
+	SortBy         string
+	Descending     bool
+	Page           int
+	PageSize       int
+}
+
+// BidQueryResult is one page of the result of a bid query. Total is the number of matching bids
+// across all pages
+type BidQueryResult struct {
+	Total    int         `json:"total"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+	Bids     []BidRecord `json:"bids"`
+}
+
+// MerkleProof proves that a bid is included in a block: combining the bid hash with each step of
+// Path in turn gives MerkleRoot (see VerifyMerkleProof). Position is the position of the bid in the block
+type MerkleProof struct {
+	BidHash    string            `json:"bid_hash"`
+	BlockIndex int               `json:"block_index"`
+	BlockHash  string            `json:"block_hash"`
+	MerkleRoot string            `json:"merkle_root"`
+	Position   int               `json:"position"`
+	Path       []MerkleProofStep `json:"path"`
+}
+
+// MerkleProofStep is one step of a Merkle proof: the hash of the sibling node, and whether the
+// sibling is on the left (hash = sibling + current) or on the right (hash = current + sibling)
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// ChainValidationReport is the result of validating a chain. When the chain is not valid, BlockIndex
+// and BlockHash identify the first bad block and Reason explains why the block was rejected
+type ChainValidationReport struct {
+	Valid      bool   `json:"valid"`
+	BlockIndex int    `json:"block_index,omitempty"`
+	BlockHash  string `json:"block_hash,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// MiningJobStatus describes a mining job, as returned by GET /mine and GET /mine/jobs/{jobId}.
+// Status is one of the MiningJob* constants. Attempts is the number of hashes computed so far and
+// HashRate the number of hashes per second. The block fields are set once the job has mined a block
+type MiningJobStatus struct {
+	JobId      string     `json:"job_id"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	Attempts   int64      `json:"attempts"`
+	HashRate   float64    `json:"hash_rate"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	BlockIndex int        `json:"block_index,omitempty"`
+	BlockHash  string     `json:"block_hash,omitempty"`
+}
+
+// ConsensusResponse is returned by GET /consensus. It says whether the local chain was
+// replaced and, if so, which node the new chain came from, how many blocks were downloaded and how
+// many of that node's pending bids were added to ours
+type ConsensusResponse struct {
+	ApiResponse
+	Replaced         bool   `json:"replaced"`
+	SourceNode       string `json:"source_node"`
+	ChainLength      int    `json:"chain_length"`
+	BlocksDownloaded int    `json:"blocks_downloaded"`
+	PendingBidsAdded int    `json:"pending_bids_added"`
+}
+
+// HeaderRecord is the header of a block with its hash, as returned by GET /sync/headers (see sync.go).
+// The fields are those of Block
+type HeaderRecord struct {
+	Version           int    `json:"version"`
+	ChainId           string `json:"chain_id"`
+	Index             int    `json:"index"`
+	Timestamp         int64  `json:"timestamp"`
+	PreviousBlockHash string `json:"previous_block_hash"`
+	MerkleRoot        string `json:"merkle_root"`
+	AuctionRoot       string `json:"auction_root"`
+	Difficulty        int    `json:"difficulty"`
+	Nonce             int    `json:"nonce"`
+	Hash              string `json:"hash"`
+}
+
+// HeadersResponse is returned by GET /sync/headers: the chain id and tip of the node's main chain, and
+// the requested headers
+type HeadersResponse struct {
+	ChainId  string         `json:"chain_id"`
+	TipIndex int            `json:"tip_index"`
+	TipHash  string         `json:"tip_hash"`
+	Headers  []HeaderRecord `json:"headers"`
+}
+
+// SyncStatus is returned by GET /sync: whether a sync is running, the peer it syncs from, the tip of that
+// peer's chain and the last block both chains had in common, the number of headers checked and of
+// blocks downloaded and added, whether synced blocks became our chain, the number of the peer's pending
+// bids taken over, the number of downloaded blocks waiting to be added, and why the last sync stopped
+// early, if it did (see sync.go)
+type SyncStatus struct {
+	Running          bool       `json:"running"`
+	Peer             string     `json:"peer,omitempty"`
+	TargetIndex      int        `json:"target_index,omitempty"`
+	TargetHash       string     `json:"target_hash,omitempty"`
+	ForkIndex        int        `json:"fork_index,omitempty"`
+	Headers          int        `json:"headers"`
+	BlocksDownloaded int        `json:"blocks_downloaded"`
+	BlocksAdded      int        `json:"blocks_added"`
+	Replaced         bool       `json:"replaced"`
+	PendingBidsAdded int        `json:"pending_bids_added"`
+	CachedBlocks     int        `json:"cached_blocks"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+}
+
+// PendingBidRecord is a pending bid as returned by GET /mempool/bids: the bid, its hash and when it
+// entered the mempool
+type PendingBidRecord struct {
+	Bid
+	BidHash    string    `json:"bid_hash"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// MempoolStatus is returned by GET /mempool: the number of pending bids, their total encoded size, the
+// number of auctions they are for, the limits of the mempool and when the oldest bid was received
+type MempoolStatus struct {
+	Count            int        `json:"count"`
+	Bytes            int        `json:"bytes"`
+	Auctions         int        `json:"auctions"`
+	MaxCount         int        `json:"max_count"`
+	MaxBytes         int        `json:"max_bytes"`
+	MaxAge           string     `json:"max_age"`
+	OldestReceivedAt *time.Time `json:"oldest_received_at,omitempty"`
+}
+
+// PeerRecord is the health of a known node (see peers.go): its id if it was registered with a
+// handshake (see join.go), when it was added, last answered and last
+// failed a probe, its consecutive and total failures, the latency of its last answer, the chain height
+// it advertised, its last error and its status ("unknown", "healthy" or "failing")
+type PeerRecord struct {
+	Url                 string     `json:"url"`
+	NodeId              string     `json:"node_id,omitempty"`
+	AddedAt             time.Time  `json:"added_at"`
+	LastSeen            *time.Time `json:"last_seen,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	TotalFailures       int        `json:"total_failures"`
+	LatencyMs           float64    `json:"latency_ms"`
+	ChainHeight         int        `json:"chain_height"`
+	LastError           string     `json:"last_error,omitempty"`
+	Status              string     `json:"status"`
+}
+
+// NodeHealth is returned by GET /health, which other nodes call to probe this node: its url, the
+// height and tip of its chain, and the time
+type NodeHealth struct {
+	Status      string    `json:"status"`
+	NodeUrl     string    `json:"node_url"`
+	ChainHeight int       `json:"chain_height"`
+	TipHash     string    `json:"tip_hash"`
+	Time        time.Time `json:"time"`
+}
+
+// GossipMetrics is returned by GET /gossip: the settings of the gossip layer (see gossip.go), the
+// length of its queue, the size of its seen set, and counters of messages published (by this node or
+// relayed), not relayed because already seen, sent, failed, retried and dropped because the queue was
+// full, with counters per peer
+type GossipMetrics struct {
+	Fanout        int                          `json:"fanout"`
+	TTL           int                          `json:"ttl"`
+	Workers       int                          `json:"workers"`
+	QueueLength   int                          `json:"queue_length"`
+	QueueCapacity int                          `json:"queue_capacity"`
+	SeenMessages  int                          `json:"seen_messages"`
+	Published     uint64                       `json:"published"`
+	Duplicates    uint64                       `json:"duplicates"`
+	Sent          uint64                       `json:"sent"`
+	Failed        uint64                       `json:"failed"`
+	Retried       uint64                       `json:"retried"`
+	Dropped       uint64                       `json:"dropped"`
+	Peers         map[string]PeerGossipMetrics `json:"peers"`
+}
+
+// PeerGossipMetrics counts the deliveries to one peer, with the average time of a successful delivery
+// and the last error
+type PeerGossipMetrics struct {
+	Sent             uint64     `json:"sent"`
+	Failed           uint64     `json:"failed"`
+	AverageLatencyMs float64    `json:"average_latency_ms"`
+	LastSentAt       *time.Time `json:"last_sent_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	totalLatency     time.Duration
+}
+
+// ReorgEvent describes a reorganization of the chain to a branch with more work (see forks.go): the
+// last block both branches have in common, the old and new tips, the number of blocks disconnected
+// from the old branch and connected from the new one, and how many bids and auction records of the
+// disconnected blocks went back to the pending ones
+type ReorgEvent struct {
+	Time                   time.Time `json:"time"`
+	ForkIndex              int       `json:"fork_index"`
+	ForkHash               string    `json:"fork_hash"`
+	OldTipIndex            int       `json:"old_tip_index"`
+	OldTipHash             string    `json:"old_tip_hash"`
+	NewTipIndex            int       `json:"new_tip_index"`
+	NewTipHash             string    `json:"new_tip_hash"`
+	DisconnectedBlocks     int       `json:"disconnected_blocks"`
+	ConnectedBlocks        int       `json:"connected_blocks"`
+	ReturnedBids           int       `json:"returned_bids"`
+	ReturnedAuctionRecords int       `json:"returned_auction_records"`
+}
+
+// ForkStatus is returned by GET /reorgs: the tip of the main chain and its cumulative work (a decimal
+// string, as it can exceed 64 bits), the number of known blocks on side branches and in the orphan
+// pool, and the last reorganizations, oldest first
+type ForkStatus struct {
+	TipIndex         int          `json:"tip_index"`
+	TipHash          string       `json:"tip_hash"`
+	ChainWork        string       `json:"chain_work"`
+	SideBranchBlocks int          `json:"side_branch_blocks"`
+	OrphanBlocks     int          `json:"orphan_blocks"`
+	Reorgs           []ReorgEvent `json:"reorgs"`
+}
+
+// AuctionState is the state of an auction, as returned by GET /auction/{auctionId}/state: the record
+// that created it, its status (one of the Auction* status constants), its bids so far and, once
+// closed, its settlement and winning bid (none if no bid reached the reserve price). On equal values
+// the earliest bid leads. BidCount counts the bids with a value: all bids of an open auction, only
+// the valid reveals of a sealed auction
+type AuctionState struct {
+	Auction         AuctionRecord `json:"auction"`
+	Status          string        `json:"status"`
+	CreatedInBlock  int           `json:"created_in_block"`
+	ClosedInBlock   int           `json:"closed_in_block,omitempty"`
+	BidCount        int           `json:"bid_count"`
+	CommitmentCount int           `json:"commitment_count,omitempty"`
+	LeadingBid      *Bid          `json:"leading_bid,omitempty"`
+	WinningBid      *Bid          `json:"winning_bid,omitempty"`
+	Settlement      *Settlement   `json:"settlement,omitempty"`
+
+	// Bids with a value, in chain order, used for settlement (not serialized)
+	bids Bids
+
+	// Commitments of a sealed auction, by commitment hash (not serialized)
+	commitments map[string]sealedCommitment
+}
+
+// Settlement is the outcome of a closed auction, as computed by its settlement rule and returned by
+// GET /auction/{auctionId}/settlement: the winning bid (none if no bid reached the reserve price) and
+// the price the winner pays. ReservePrice is the reserve price, revealed at close when it was hidden
+type Settlement struct {
+	AuctionId     int     `json:"auction_id"`
+	Rule          string  `json:"rule"`
+	ClosedInBlock int     `json:"closed_in_block"`
+	BidCount      int     `json:"bid_count"`
+	ReservePrice  Money   `json:"reserve_price"`
+	WinningBid    *Bid    `json:"winning_bid,omitempty"`
+	ClearingPrice Money   `json:"clearing_price"`
+}
+
+// NodeInfo is the handshake of the join protocol (see join.go): the id of a node, the protocol version
+// it speaks, the id and genesis hash of the chain it follows, the url other nodes reach it at and the
+// height of its chain
+type NodeInfo struct {
+	NodeId          string `json:"node_id"`
+	ProtocolVersion int    `json:"protocol_version"`
+	ChainId         string `json:"chain_id"`
+	GenesisHash     string `json:"genesis_hash"`
+	PublicUrl       string `json:"public_url"`
+	ChainHeight     int    `json:"chain_height"`
+}
+
+// JoinResponse is returned by POST /register-and-broadcast-node: the handshake of the node that was
+// joined, and the other nodes it knows
+type JoinResponse struct {
+	Node  NodeInfo `json:"node"`
+	Peers []string `json:"peers"`
+}
+
+// Event is an event of the event stream (see events.go): its id, type and time, the auctions and bidders
+// it is about (none for events about the whole chain) and its data, which depends on the type
+type Event struct {
+	Id         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	Time       time.Time   `json:"time"`
+	AuctionIds []int       `json:"auction_ids,omitempty"`
+	Bidders    []string    `json:"bidders,omitempty"`
+	Data       interface{} `json:"data"`
+}
+
+// BlockEvent is the data of block_mined and block_received events: the block, what adding it did (one
+// of the Block* outcomes of AddBlock) and the node that sent it, if it was received
+type BlockEvent struct {
+	Block      Block  `json:"block"`
+	Status     string `json:"status"`
+	SourceNode string `json:"source_node,omitempty"`
+}
+
+// ChainReplacedEvent is the data of chain_replaced events: the node whose chain consensus switched to,
+// the last block both chains had in common, and the old and new tips
+type ChainReplacedEvent struct {
+	SourceNode  string `json:"source_node"`
+	ForkIndex   int    `json:"fork_index"`
+	OldTipIndex int    `json:"old_tip_index"`
+	OldTipHash  string `json:"old_tip_hash"`
+	NewTipIndex int    `json:"new_tip_index"`
+	NewTipHash  string `json:"new_tip_hash"`
+}
+
+// OutbidEvent is the data of outbid webhook deliveries (see webhooks.go): the bid that led the auction,
+// confirmed or pending, and the new bid that beat it
+type OutbidEvent struct {
+	AuctionId   int       `json:"auction_id"`
+	PreviousBid BidRecord `json:"previous_bid"`
+	NewBid      BidRecord `json:"new_bid"`
+}
+
+// WebhookRequest is the body of POST /webhooks (see webhooks.go): the url deliveries are sent to, the
+// events to send (WebhookOutbid, WebhookAuctionClosed, WebhookBidConfirmed), the auctions and bidders
+// they must be about (all if empty) and the confirmations of bid_confirmed (1 if 0)
+type WebhookRequest struct {
+	Url           string   `json:"url"`
+	Events        []string `json:"events"`
+	AuctionIds    []int    `json:"auction_ids"`
+	Bidders       []string `json:"bidders"`
+	Confirmations int      `json:"confirmations"`
+}
+
+// WebhookSubscription is a registered webhook. Secret signs the deliveries; it is only returned when
+// the webhook is created. ConfirmedThrough is the last block whose bids were checked for bid_confirmed
+type WebhookSubscription struct {
+	Id               string    `json:"id"`
+	Url              string    `json:"url"`
+	Secret           string    `json:"secret,omitempty"`
+	Events           []string  `json:"events"`
+	AuctionIds       []int     `json:"auction_ids"`
+	Bidders          []string  `json:"bidders"`
+	Confirmations    int       `json:"confirmations"`
+	CreatedAt        time.Time `json:"created_at"`
+	ConfirmedThrough int       `json:"confirmed_through"`
+}
+
+// WebhookPayload is the body of a webhook delivery: its id, the webhook it is sent to, the type of
+// event, when it happened and its data, which depends on the type
+type WebhookPayload struct {
+	Id        string      `json:"id"`
+	WebhookId string      `json:"webhook_id"`
+	Event     string      `json:"event"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDelivery is a delivery waiting in the outbox: its encoded WebhookPayload, the attempts made so
+// far, when the next one is due and the error of the last one
+type WebhookDelivery struct {
+	Id          string          `json:"id"`
+	WebhookId   string          `json:"webhook_id"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// WebhookLogEntry is an attempt of the delivery log: its outcome (one of the Delivery* outcomes), the
+// status code of the response (0 if there was none) and the error, if it failed
+type WebhookLogEntry struct {
+	Time       time.Time `json:"time"`
+	DeliveryId string    `json:"delivery_id"`
+	WebhookId  string    `json:"webhook_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	Outcome    string    `json:"outcome"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// WebhookState is what is stored of webhooks: the subscriptions, the outbox and the delivery log
+type WebhookState struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+	Outbox        []WebhookDelivery     `json:"outbox"`
+	Log           []WebhookLogEntry     `json:"log"`
+}
+
+// WebhookDeliveries is returned by GET /webhooks/{webhookId}/deliveries: the pending deliveries of a
+// webhook and its last delivery attempts
+type WebhookDeliveries struct {
+	WebhookId string            `json:"webhook_id"`
+	Pending   []WebhookDelivery `json:"pending"`
+	Log       []WebhookLogEntry `json:"log"`
 }
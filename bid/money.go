@@ -0,0 +1,216 @@
+/* Exact amounts of money. Bid values and reserve prices used to be float32, which cannot represent
+123.45 exactly and loses precision on large values. Money stores an integer number of minor units
+(i.e. cents) and a currency code, so amounts compare and add exactly, and the hash of a bid covers the
+integer rather than some decimal formatting of a float.
+
+Amounts are written as a decimal number optionally followed by a space and an ISO 4217 currency code:
+	"123.45"		123.45 in no particular currency (the amounts of older clients)
+	"123.45 EUR"	123.45 euros
+	"1000 JPY"		1000 yen (the yen has no minor unit)
+Amounts are always formatted with all decimals of their currency ("123.40 EUR"), so each amount has a
+single canonical form, and parsing only accepts that form: exactly as many decimals as the currency has
+(2 unless listed in currencyScales), no leading zeros ("0.50", not "00.50"), no negative zero, no
+exponent, no thousands separators, and a currency code of 3 upper case letters. Formatting a parsed
+amount gives back the same text */
+package bid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number of decimals of the currencies whose minor unit is not a hundredth
+var currencyScales map[string]int = map[string]int{
+	"JPY": 0, "KRW": 0, "ISK": 0, "CLP": 0, "VND": 0,
+	"BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+// Number of decimals of all other currencies
+const defaultCurrencyScale = 2
+
+// Errors returned by Money arithmetic
+var (
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+	ErrMoneyOverflow    = errors.New("amount is too large")
+)
+
+// Money is an exact amount: Units minor units (i.e. cents) of Currency. An empty Currency means the
+// amount has no currency (as written by older clients)
+type Money struct {
+	Units    int64
+	Currency string
+}
+
+// ParseMoney parses an amount in canonical form, written as "123.45" or "123.45 EUR"
+func ParseMoney(text string) (Money, error) {
+	var amount string = text
+	var currency string = ""
+	if separator := strings.IndexByte(text, ' '); separator >= 0 {
+		amount, currency = text[:separator], text[separator+1:]
+		if !isCurrencyCode(currency) {
+			return Money{}, fmt.Errorf("invalid amount %q: currency must be 3 upper case letters", text)
+		}
+	}
+
+	var negative bool = strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	var whole string = amount
+	var fraction string = ""
+	if point := strings.IndexByte(amount, '.'); point >= 0 {
+		whole, fraction = amount[:point], amount[point+1:]
+		if fraction == "" {
+			return Money{}, fmt.Errorf("invalid amount %q: no digits after the decimal point", text)
+		}
+	}
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("invalid amount %q: expected a decimal number like \"123.45\"", text)
+	}
+	if len(whole) > 1 && whole[0] == '0' {
+		return Money{}, fmt.Errorf("invalid amount %q: leading zeros", text)
+	}
+
+	var scale int = currencyScale(currency)
+	if len(fraction) != scale {
+		return Money{}, fmt.Errorf("invalid amount %q: expected exactly %d decimals", text, scale)
+	}
+	// The sign is parsed with the digits, so that the lowest amount (math.MinInt64 units) parses too
+	var sign string = ""
+	if negative {
+		sign = "-"
+	}
+	units, err := strconv.ParseInt(sign+whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", text, ErrMoneyOverflow)
+	}
+	if negative && units == 0 {
+		return Money{}, fmt.Errorf("invalid amount %q: negative zero", text)
+	}
+	return Money{Units: units, Currency: currency}, nil
+}
+
+// MustParseMoney is ParseMoney for amounts known to be valid. It panics if the amount is not valid
+func MustParseMoney(text string) Money {
+	money, err := ParseMoney(text)
+	if err != nil {
+		panic(err)
+	}
+	return money
+}
+
+// String formats an amount in its canonical form: all decimals of the currency, then the currency
+func (m Money) String() string {
+	var scale int = currencyScale(m.Currency)
+	var units uint64 = uint64(m.Units)
+	var sign string = ""
+	if m.Units < 0 {
+		sign = "-"
+		units = uint64(-(m.Units + 1)) + 1 // no overflow on math.MinInt64
+	}
+
+	var digits string = strconv.FormatUint(units, 10)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	var text string = sign + digits
+	if scale > 0 {
+		text = sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if m.Currency != "" {
+		text += " " + m.Currency
+	}
+	return text
+}
+
+// MarshalJSON writes an amount as a JSON string in canonical form
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON reads an amount from a JSON string (see ParseMoney). Numbers are refused: they are
+// read as floating point values by most JSON libraries, which is what Money avoids
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("amount must be a string like \"123.45\" or \"123.45 EUR\"")
+	}
+	money, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = money
+	return nil
+}
+
+// IsZero checks if an amount is zero (in any currency)
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+// Cmp compares two amounts: -1 if m < other, 0 if they are equal, +1 if m > other. Amounts in
+// different currencies are ordered by currency code, so that the order is always the same on every
+// node; callers check currencies first when that matters
+func (m Money) Cmp(other Money) int {
+	switch {
+	case m.Currency < other.Currency:
+		return -1
+	case m.Currency > other.Currency:
+		return 1
+	case m.Units < other.Units:
+		return -1
+	case m.Units > other.Units:
+		return 1
+	}
+	return 0
+}
+
+// Add returns m + other. Both amounts must be in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	if (other.Units > 0 && m.Units > math.MaxInt64-other.Units) || (other.Units < 0 && m.Units < math.MinInt64-other.Units) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Units: m.Units + other.Units, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other. Both amounts must be in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Units == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Units: -other.Units, Currency: other.Currency})
+}
+
+// currencyScale returns the number of decimals of a currency
+func currencyScale(currency string) int {
+	if scale, listed := currencyScales[currency]; listed {
+		return scale
+	}
+	return defaultCurrencyScale
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, letter := range code {
+		if letter < 'A' || letter > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(text string) bool {
+	for _, digit := range text {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+	}
+	return true
+}
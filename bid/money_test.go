@@ -0,0 +1,167 @@
+package bid
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	var tests = []struct {
+		text     string
+		expected Money
+		valid    bool
+	}{
+		{"123.45", Money{Units: 12345}, true},
+		{"123.45 EUR", Money{Units: 12345, Currency: "EUR"}, true},
+		{"0.50", Money{Units: 50}, true},
+		{"0.00", Money{Units: 0}, true},
+		{"-1.25 USD", Money{Units: -125, Currency: "USD"}, true},
+		{"1000 JPY", Money{Units: 1000, Currency: "JPY"}, true},
+		{"1.500 KWD", Money{Units: 1500, Currency: "KWD"}, true},
+		{"92233720368547758.07", Money{Units: math.MaxInt64}, true},
+		{"-92233720368547758.08", Money{Units: math.MinInt64}, true},
+
+		// Amounts that older versions accepted: the number of decimals must match the currency
+		{"100", Money{}, false},
+		{"123.4", Money{}, false},
+		{"123.456", Money{}, false},
+		{"1000.00 JPY", Money{}, false},
+		{"1.50 KWD", Money{}, false},
+		{"1.", Money{}, false},
+		{".50", Money{}, false},
+
+		// Only one way to write each amount
+		{"00.50", Money{}, false},
+		{"01.00", Money{}, false},
+		{"-0.00", Money{}, false},
+		{"+1.00", Money{}, false},
+		{"1e2", Money{}, false},
+		{"1,000.00", Money{}, false},
+		{" 1.00", Money{}, false},
+		{"1.00  EUR", Money{}, false},
+		{"1.00 eur", Money{}, false},
+		{"1.00 EURO", Money{}, false},
+		{"1.00 ", Money{}, false},
+		{"", Money{}, false},
+		{"-", Money{}, false},
+
+		// Out of the range of int64 units
+		{"92233720368547758.08", Money{}, false},
+		{"-92233720368547758.09", Money{}, false},
+		{"100000000000000000000.00", Money{}, false},
+	}
+	for _, test := range tests {
+		money, err := ParseMoney(test.text)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseMoney(%q): got error %v, expected valid %v", test.text, err, test.valid)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if money != test.expected {
+			t.Errorf("ParseMoney(%q) = %+v, expected %+v", test.text, money, test.expected)
+		}
+		// The canonical form formats back to the same text
+		if text := money.String(); text != test.text {
+			t.Errorf("ParseMoney(%q).String() = %q", test.text, text)
+		}
+	}
+
+	if _, err := ParseMoney("92233720368547758.08"); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("overflow: got error %v, expected ErrMoneyOverflow", err)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	var tests = []struct {
+		money    Money
+		expected string
+	}{
+		{Money{Units: 0}, "0.00"},
+		{Money{Units: 5}, "0.05"},
+		{Money{Units: -5, Currency: "EUR"}, "-0.05 EUR"},
+		{Money{Units: 12340, Currency: "EUR"}, "123.40 EUR"},
+		{Money{Units: 7, Currency: "JPY"}, "7 JPY"},
+		{Money{Units: 7, Currency: "BHD"}, "0.007 BHD"},
+		{Money{Units: math.MinInt64}, "-92233720368547758.08"},
+	}
+	for _, test := range tests {
+		if text := test.money.String(); text != test.expected {
+			t.Errorf("%+v.String() = %q, expected %q", test.money, text, test.expected)
+		}
+		parsed, err := ParseMoney(test.expected)
+		if err != nil || parsed != test.money {
+			t.Errorf("ParseMoney(%q) = %+v, %v, expected %+v", test.expected, parsed, err, test.money)
+		}
+	}
+}
+
+func TestMoneyJson(t *testing.T) {
+	var money Money = MustParseMoney("123.45 EUR")
+	data, err := json.Marshal(money)
+	if err != nil || string(data) != `"123.45 EUR"` {
+		t.Fatalf("marshal: got %s, %v", data, err)
+	}
+	var decoded Money
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded != money {
+		t.Fatalf("unmarshal: got %+v, %v", decoded, err)
+	}
+	// Numbers are refused, as are amounts not in canonical form
+	for _, data := range []string{`123.45`, `"123.4"`, `null`} {
+		if err = json.Unmarshal([]byte(data), &decoded); err == nil {
+			t.Errorf("unmarshal %s: no error", data)
+		}
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	var tests = []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.00", "2.00", -1},
+		{"2.00", "1.00", 1},
+		{"1.00 EUR", "1.00 EUR", 0},
+		{"-1.00 EUR", "0.00 EUR", -1},
+		// Different currencies are ordered by currency code, whatever the amounts
+		{"100.00 EUR", "1.00 USD", -1},
+		{"1.00 USD", "100.00 EUR", 1},
+		{"1.00", "1.00 EUR", -1},
+	}
+	for _, test := range tests {
+		if result := MustParseMoney(test.a).Cmp(MustParseMoney(test.b)); result != test.expected {
+			t.Errorf("%s Cmp %s = %d, expected %d", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	var max Money = Money{Units: math.MaxInt64, Currency: "EUR"}
+	var min Money = Money{Units: math.MinInt64, Currency: "EUR"}
+	var one Money = Money{Units: 1, Currency: "EUR"}
+	var tests = []struct {
+		name     string
+		operate  func() (Money, error)
+		expected Money
+		err      error
+	}{
+		{"add", func() (Money, error) { return one.Add(one) }, Money{Units: 2, Currency: "EUR"}, nil},
+		{"sub", func() (Money, error) { return one.Sub(max) }, Money{Units: 1 - math.MaxInt64, Currency: "EUR"}, nil},
+		{"add to max", func() (Money, error) { return max.Add(one) }, Money{}, ErrMoneyOverflow},
+		{"sub from min", func() (Money, error) { return min.Sub(one) }, Money{}, ErrMoneyOverflow},
+		{"add to min", func() (Money, error) { return min.Add(min) }, Money{}, ErrMoneyOverflow},
+		{"sub min", func() (Money, error) { return one.Sub(min) }, Money{}, ErrMoneyOverflow},
+		{"max plus min", func() (Money, error) { return max.Add(min) }, Money{Units: -1, Currency: "EUR"}, nil},
+		{"other currency", func() (Money, error) { return one.Add(Money{Units: 1, Currency: "USD"}) }, Money{}, ErrCurrencyMismatch},
+		{"no currency", func() (Money, error) { return one.Sub(Money{Units: 1}) }, Money{}, ErrCurrencyMismatch},
+	}
+	for _, test := range tests {
+		result, err := test.operate()
+		if !errors.Is(err, test.err) || result != test.expected {
+			t.Errorf("%s: got %+v, %v, expected %+v, %v", test.name, result, err, test.expected, test.err)
+		}
+	}
+}
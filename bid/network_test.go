@@ -0,0 +1,199 @@
+package bid
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestNode creates a node with its state in memory, the test difficulty and no peer probes
+func newTestNode(t *testing.T, publicUrl string, seeds ...string) *Node {
+	t.Helper()
+	var config Config = DefaultConfig("0")
+	config.Genesis = TestGenesisConfig()
+	config.Peers.ProbeInterval = 0
+	config.Peers.PublicUrl = publicUrl
+	config.Peers.Seeds = seeds
+	node, err := NewNode(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// callJson sends a request to a node and decodes its JSON answer into value (if not nil). Returns the
+// status code
+func callJson(method string, url string, body interface{}, value interface{}) (int, error) {
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	request, err := http.NewRequest(method, url, bytes.NewReader(encoded))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if value != nil {
+		if err = json.NewDecoder(response.Body).Decode(value); err != nil {
+			return response.StatusCode, err
+		}
+	}
+	return response.StatusCode, nil
+}
+
+// mineOverHttp starts a mining job on a node (GET /mine) and waits until it is over. Returns the final
+// status of the job: a job is cancelled when the chain changes while it runs
+func mineOverHttp(nodeUrl string) (MiningJobStatus, error) {
+	var job MiningJobStatus
+	if _, err := callJson("GET", nodeUrl+"/mine", nil, &job); err != nil {
+		return job, err
+	}
+	for job.Status == MiningJobRunning {
+		time.Sleep(5 * time.Millisecond)
+		if _, err := callJson("GET", nodeUrl+"/mine/jobs/"+job.JobId, nil, &job); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
+
+// sameChain checks if all nodes have the same main chain
+func sameChain(nodes []*Node) bool {
+	var chain Blocks = nodes[0].Chain()
+	for _, node := range nodes[1:] {
+		var other Blocks = node.Chain()
+		if len(other) != len(chain) {
+			return false
+		}
+		for i := range chain {
+			if other[i].Hash != chain[i].Hash {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestNetworkConverges runs five nodes in one process, each served by an httptest server from Handler:
+// four nodes join through the first one and mine competing blocks while an auction and a bid are
+// broadcast, then a fifth node joins the network late. All nodes must end up knowing each other, with
+// the same chain, which holds the bid
+func TestNetworkConverges(t *testing.T) {
+	const nodeCount = 5
+	var handlers [nodeCount]http.Handler
+	var servers [nodeCount]*httptest.Server
+	var nodes []*Node = make([]*Node, nodeCount)
+
+	// The servers are started first, since a node needs its public url when it is created
+	for i := range servers {
+		var i int = i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			handlers[i].ServeHTTP(writer, request)
+		}))
+		defer servers[i].Close()
+	}
+	for i := range nodes {
+		var seeds []string = []string{}
+		if i > 0 {
+			seeds = append(seeds, servers[0].URL)
+		}
+		nodes[i] = newTestNode(t, servers[i].URL, seeds...)
+		handlers[i] = nodes[i].Handler()
+		defer nodes[i].Shutdown(context.Background())
+	}
+
+	// The first four nodes join and find each other
+	var early []*Node = nodes[:nodeCount-1]
+	for _, node := range early {
+		node.Join()
+	}
+	waitFor(t, 10*time.Second, "the first nodes to know each other", func() bool {
+		for _, node := range early {
+			if len(node.Peers()) != len(early)-1 {
+				return false
+			}
+		}
+		return true
+	})
+
+	// They mine at the same time, so their chains fork. Meanwhile an auction and a bid for it are
+	// broadcast through one of them
+	var mining sync.WaitGroup
+	for i := range early {
+		mining.Add(1)
+		go func(nodeUrl string) {
+			defer mining.Done()
+			for round := 0; round < 3; round++ {
+				if job, err := mineOverHttp(nodeUrl); err != nil || job.Status == MiningJobFailed {
+					t.Errorf("mining on %s: %v %s", nodeUrl, err, job.Reason)
+					return
+				}
+			}
+		}(servers[i].URL)
+	}
+	_, seller, _ := ed25519.GenerateKey(nil)
+	var now int64 = time.Now().UnixNano()
+	var create AuctionRecord = AuctionRecord{Type: AuctionRecordCreate, AuctionId: 1, Item: "network", OpenTime: now, CloseTime: now + int64(time.Hour)}
+	SignAuctionRecord(&create, seller)
+	if status, err := callJson("POST", servers[2].URL+"/auction/broadcast", create, nil); err != nil || status != http.StatusCreated {
+		t.Fatalf("auction: %d %v", status, err)
+	}
+	_, bidder, _ := ed25519.GenerateKey(nil)
+	var bid Bid = Bid{ChainId: DefaultChainId, AuctionId: 1, BidValue: Money{Units: 1000}, Sequence: 1}
+	SignBid(&bid, bidder)
+	if status, err := callJson("POST", servers[2].URL+"/bid/broadcast", bid, nil); err != nil || status != http.StatusCreated {
+		t.Fatalf("bid: %d %v", status, err)
+	}
+	mining.Wait()
+
+	// The last node joins once the others have a chain, and syncs with them
+	nodes[nodeCount-1].Join()
+
+	// Branches of equal work stay split until one of them gets a block more: the first node mines until
+	// every node follows the same chain and the bid is in it
+	var bidInChain = func() bool {
+		for _, block := range nodes[0].Chain() {
+			for _, confirmed := range block.Bids {
+				if confirmed.Hash() == bid.Hash() {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var deadline time.Time = time.Now().Add(30 * time.Second)
+	for !(sameChain(nodes) && bidInChain()) {
+		if time.Now().After(deadline) {
+			for _, node := range nodes {
+				t.Logf("node %s: height %d, tip %s", node.Url(), node.BlockChain().GetLastBlock().Index, node.BlockChain().GetLastBlock().Hash)
+			}
+			t.Fatalf("nodes did not converge (bid in chain: %v)", bidInChain())
+		}
+		if _, err := mineOverHttp(servers[0].URL); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, node := range nodes {
+		if len(node.Peers()) != nodeCount-1 {
+			t.Errorf("node %s knows %d nodes, expected %d", node.Url(), len(node.Peers()), nodeCount-1)
+		}
+	}
+	var tip Block = nodes[0].BlockChain().GetLastBlock()
+	t.Logf("%d nodes converged on block %d (%s)", nodeCount, tip.Index, tip.Hash)
+	if tip.Index < 4 {
+		t.Errorf("chain height %d, expected at least 4", tip.Index)
+	}
+}
@@ -0,0 +1,277 @@
+/* Node. A Node is one member of the network: its blockchain, miner, gossip, peer monitor, syncer, event
+bus and webhooks, and the http api that serves them. Each Node has its own state, so a program can run
+several of them (i.e., a test with five nodes in one process):
+	node, err := bid.NewNode(bid.DefaultConfig("9000"))
+	if err != nil { ... }
+	if err = node.Start(); err != nil { ... }
+	...
+	node.Shutdown(ctx)
+Start listens on the port of the Config. A program that serves the api itself (i.e., with httptest)
+serves Handler instead, with Peers.PublicUrl set to where it is served, then calls Join */
+package bid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/handlers"
+)
+
+// ErrNodeStarted is returned by Start when the node was already started or was shut down
+var ErrNodeStarted = errors.New("node was already started or shut down")
+
+// Config configures a node
+type Config struct {
+	// Port is the port the node listens on
+	Port string
+	// ListenAddress is the address Start listens on (":<port>" if empty)
+	ListenAddress string
+	// Storage keeps the state of the node (in memory only if nil). It is not closed by Shutdown
+	Storage Storage
+	// Peers configures how the node joins the network and monitors its peers
+	Peers PeerConfig
+	// Genesis is the genesis block, chain id and difficulty rules, which must be the same on all nodes
+	Genesis GenesisConfig
+	// AllowPrivateWebhooks lets webhooks target loopback, link-local and private addresses (see
+	// webhooks.go). Off by default, so that api clients cannot make the node call internal services
+	AllowPrivateWebhooks bool
+}
+
+// DefaultConfig returns the settings of a node listening on the given port unless configured otherwise:
+// state in memory, the default peer and genesis settings (with the default difficulty rules)
+func DefaultConfig(port string) Config {
+	return Config{
+		Port:    port,
+		Peers:   DefaultPeerConfig(),
+		Genesis: DefaultGenesisConfig(),
+	}
+}
+
+// Node is a node of the network (see the doc comment of this file)
+type Node struct {
+	config     Config
+	controller *Controller
+	handler    http.Handler
+	seeds      []string
+
+	mutex    sync.Mutex
+	server   *http.Server
+	started  bool
+	joined   bool
+	stopped  bool
+	stopOnce sync.Once
+}
+
+// NewNode creates a node. Its blockchain is loaded from config.Storage (the genesis block of
+// config.Genesis is created if the storage is empty); an error is returned if the stored chain cannot be
+// loaded, is not valid or does not start with that genesis block. Nothing runs in the background until
+// the node is started (or joins, see Join), so a node that is never started needs no Shutdown
+func NewNode(config Config) (*Node, error) {
+	// The public url of this node, and the seeds, must be valid node urls (see join.go)
+	if config.Peers.PublicUrl == "" {
+		config.Peers.PublicUrl = "http://localhost:" + config.Port
+	}
+	publicUrl, err := normalizeNodeUrl(config.Peers.PublicUrl)
+	if err != nil {
+		return nil, fmt.Errorf("public url: %w", err)
+	}
+	var seeds []string = []string{}
+	for _, seed := range config.Peers.Seeds {
+		seedUrl, err := normalizeNodeUrl(seed)
+		if err != nil {
+			return nil, fmt.Errorf("seed: %w", err)
+		}
+		if seedUrl != publicUrl {
+			seeds = append(seeds, seedUrl)
+		}
+	}
+	if config.Storage == nil {
+		config.Storage = NewMemoryStorage()
+	}
+	if config.ListenAddress == "" {
+		config.ListenAddress = ":" + config.Port
+	}
+
+	// The controller holds the blockchain and the url of the node on which it is running
+	blockChain, err := NewBlockChain(config.Storage, config.Genesis)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Following chain %q, genesis block %s", blockChain.ChainId(), blockChain.GetGenesisBlock().Hash)
+	webhooks, err := NewWebhooks(blockChain, config.Storage, config.AllowPrivateWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	var controller *Controller = &Controller{
+		blockChain:     blockChain,
+		currentNodeUrl: publicUrl,
+		nodeId:         newNodeId(),
+		chainId:        blockChain.ChainId(),
+		events:         NewEventBus(),
+		webhooks:       webhooks,
+		quit:           make(chan struct{}),
+	}
+	controller.miner = NewMiner(blockChain, controller.broadcastNewBlock)
+	controller.gossip = NewGossip(publicUrl, blockChain.GetNetworkNodes)
+	controller.peerMonitor = NewPeerMonitor(blockChain, publicUrl, config.Peers)
+	controller.syncer = NewSyncer(blockChain, publicUrl)
+	controller.events.Observe(webhooks.handleEvent)
+
+	return &Node{
+		config:     config,
+		controller: controller,
+		handler:    withCORS(newRouter(controller)),
+		seeds:      seeds,
+	}, nil
+}
+
+// Start serves the api on the listen address of the node, in the background, then joins the network.
+// Returns an error if the address cannot be listened on, or if the node was already started
+func (n *Node) Start() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.started || n.stopped {
+		return ErrNodeStarted
+	}
+	listener, err := net.Listen("tcp", n.config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	n.started = true
+	n.server = &http.Server{Handler: n.handler}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Node %s stopped serving: %s", n.Url(), err)
+		}
+	}(n.server)
+	n.join()
+	return nil
+}
+
+// Join starts the background work of the node (peer probes, gossip and webhook deliveries), then joins
+// the network through the seeds of the node, in the background. Start calls it; a program that serves
+// Handler itself calls it once the node is served. Later calls, and calls after Shutdown, do nothing
+func (n *Node) Join() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.join()
+}
+
+// join is Join without locking. Must be called with mutex held
+func (n *Node) join() {
+	if n.joined || n.stopped {
+		return
+	}
+	n.joined = true
+	var c *Controller = n.controller
+	c.peerMonitor.Start()
+	c.gossip.Start()
+	c.webhooks.Start()
+	go c.joinNetwork(n.seeds)
+}
+
+// Shutdown stops the node: open event streams are closed, the mining job is cancelled, the api stops
+// serving once the requests in progress are over (or ctx is done), and the peer monitor, gossip and
+// webhooks stop (if they were started, see Join). Pending webhook deliveries stay stored. The storage is
+// left open
+func (n *Node) Shutdown(ctx context.Context) error {
+	var err error
+	n.stopOnce.Do(func() {
+		var c *Controller = n.controller
+		close(c.quit)
+		c.events.Close()
+		c.miner.CancelCurrentJob("node is shutting down")
+
+		n.mutex.Lock()
+		n.stopped = true
+		var server *http.Server = n.server
+		n.mutex.Unlock()
+		if server != nil {
+			err = server.Shutdown(ctx)
+		}
+		c.peerMonitor.Stop()
+		c.gossip.Stop()
+		c.webhooks.Stop()
+	})
+	return err
+}
+
+// Handler returns the api of the node, with CORS headers: the handler Start serves
+func (n *Node) Handler() http.Handler {
+	return n.handler
+}
+
+// Url returns the public url of the node
+func (n *Node) Url() string {
+	return n.controller.currentNodeUrl
+}
+
+// Id returns the id of the node, sent in its handshake (see join.go)
+func (n *Node) Id() string {
+	return n.controller.nodeId
+}
+
+// BlockChain returns the blockchain of the node: its chain, mempool, auctions and peer table
+func (n *Node) BlockChain() *BlockChain {
+	return n.controller.blockChain
+}
+
+// Chain returns the blocks of the main chain of the node
+func (n *Node) Chain() Blocks {
+	return n.controller.blockChain.getMainChain()
+}
+
+// PendingBids returns the bids in the mempool of the node, in arrival order
+func (n *Node) PendingBids() Bids {
+	return n.controller.blockChain.GetPendingBids()
+}
+
+// Mempool returns the size and limits of the mempool of the node
+func (n *Node) Mempool() MempoolStatus {
+	return n.controller.blockChain.GetMempoolStatus()
+}
+
+// Peers returns the nodes known to the node, with their health
+func (n *Node) Peers() []PeerRecord {
+	return n.controller.blockChain.GetPeers()
+}
+
+/* The 'handlers' package from guerilla is a collection of handlers (aka "HTTP middleware")
+for use with Go's net/http package. This package includes handlers for logging in standardised
+formats, compressing HTTP responses, validating content types and other useful tools for
+manipulating requests and responses.
+
+handlers.AllowedOrigins sets the allowed origins for CORS requests, as used in the
+Allow-Access-Control-Origin' HTTP header. Passing in a "*" will allow any domain.
+handles.AllowedMethods explicitly allows methods in the Access-Control-Allow-Methods header.
+
+Note the following definitions:
+type CORSOption func(*cors) error		// CORSOption is a function type
+type cors struct {
+	h                      http.Handler
+	allowedHeaders         []string
+	allowedMethods         []string
+	allowedOrigins         []string
+	allowedOriginValidator OriginValidator
+	exposedHeaders         []string
+	maxAge                 int
+	ignoreOptions          bool
+	allowCredentials       bool
+	optionStatusCode       int
+} */
+func withCORS(handler http.Handler) http.Handler {
+	var allowedOrigins handlers.CORSOption = handlers.AllowedOrigins([]string{"*"})
+	var allowedMethods handlers.CORSOption = handlers.AllowedMethods([]string{"GET","POST","DELETE"})
+	var allowedHeaders handlers.CORSOption = handlers.AllowedHeaders([]string{"Content-Type", "If-None-Match"})
+	var exposedHeaders handlers.CORSOption = handlers.ExposedHeaders([]string{"ETag"})
+
+	// Initialize headers: accept calls from any origin and work with GET, POST and DELETE requests.
+	// Browser dashboards can read the ETag of explorer responses and send it back in If-None-Match
+	var funcHandler func(http.Handler) http.Handler = handlers.CORS(allowedMethods, allowedOrigins, allowedHeaders, exposedHeaders)
+	return funcHandler(handler)
+}
@@ -0,0 +1,290 @@
+/* Peer health. Each known node has a PeerRecord: when it was added and last seen, its consecutive and
+total failures, the latency of the last successful probe and the chain height it advertised. A
+PeerMonitor probes every peer each ProbeInterval (GET /health) and records the result; a peer that fails
+MaxFailures probes in a row is evicted, so that gossip and consensus stop trying nodes that are gone.
+Peers can also be removed by hand (DELETE /peers?url=...). The peer table is stored (see Storage.SavePeers)
+after every change and every probe round, so health survives a restart */
+package bid
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Possible values of PeerRecord.Status
+const (
+	PeerUnknown = "unknown" // not probed yet
+	PeerHealthy = "healthy" // the last probe succeeded
+	PeerFailing = "failing" // the last probe failed
+)
+
+// PeerConfig configures how a node joins the network and monitors the health of its peers
+type PeerConfig struct {
+	// ProbeInterval is the time between two probe rounds
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long a probe waits for a peer to answer
+	ProbeTimeout time.Duration
+	// MaxFailures is the number of consecutive failed probes after which a peer is evicted. 0 never
+	// evicts peers
+	MaxFailures int
+	// PublicUrl is the url other nodes reach this node at (http://localhost:<port> if empty)
+	PublicUrl string
+	// Seeds are the urls of the nodes this node joins when it starts (see join.go)
+	Seeds []string
+}
+
+// DefaultPeerConfig returns the peer settings used by a node unless configured otherwise: a probe every
+// 30 seconds, eviction after 10 failures in a row (5 minutes without an answer), and no seeds
+func DefaultPeerConfig() PeerConfig {
+	return PeerConfig{
+		ProbeInterval: 30 * time.Second,
+		ProbeTimeout:  5 * time.Second,
+		MaxFailures:   10,
+	}
+}
+
+/* Peer table */
+
+// RegisterNode registers a node in the blockchain if it does not already exist. Returns true if the
+// node was added
+func (b *BlockChain) RegisterNode(node string) bool {
+	b.nodesMutex.Lock()
+	defer b.nodesMutex.Unlock()
+
+	// Add node if it does not exist, else do nothing
+	if _, exists := b.NetworkNodes[node]; exists {
+		return false // node already exists
+	}
+	b.NetworkNodes[node] = &PeerRecord{Url: node, AddedAt: time.Now(), Status: PeerUnknown}
+	b.savePeers()
+	return true // node added
+}
+
+// RegisterPeer registers a node from its handshake (see join.go), or updates the id and chain height
+// of a known node. Returns true if the node was added
+func (b *BlockChain) RegisterPeer(info NodeInfo) bool {
+	b.nodesMutex.Lock()
+	defer b.nodesMutex.Unlock()
+
+	peer, exists := b.NetworkNodes[info.PublicUrl]
+	if !exists {
+		peer = &PeerRecord{Url: info.PublicUrl, AddedAt: time.Now(), Status: PeerUnknown}
+		b.NetworkNodes[info.PublicUrl] = peer
+	}
+	peer.NodeId = info.NodeId
+	peer.ChainHeight = info.ChainHeight
+	b.savePeers()
+	return !exists
+}
+
+// RemoveNode removes a node from the known nodes. Returns false if the node is not known
+func (b *BlockChain) RemoveNode(node string) bool {
+	b.nodesMutex.Lock()
+	defer b.nodesMutex.Unlock()
+
+	if _, exists := b.NetworkNodes[node]; !exists {
+		return false
+	}
+	delete(b.NetworkNodes, node)
+	b.savePeers()
+	return true
+}
+
+// GetNetworkNodes gets a copy of the list of known nodes, safe to use while nodes are registered
+func (b *BlockChain) GetNetworkNodes() []string {
+	b.nodesMutex.RLock()
+	defer b.nodesMutex.RUnlock()
+	return b.nodeList()
+}
+
+// GetPeers gets a copy of the records of the known nodes, sorted by url
+func (b *BlockChain) GetPeers() []PeerRecord {
+	b.nodesMutex.RLock()
+	defer b.nodesMutex.RUnlock()
+	return b.peerRecords()
+}
+
+// RecordPeerSuccess records that a node answered a probe in the given time, advertising the given
+// chain height
+func (b *BlockChain) RecordPeerSuccess(node string, latency time.Duration, chainHeight int) {
+	b.nodesMutex.Lock()
+	defer b.nodesMutex.Unlock()
+
+	peer, exists := b.NetworkNodes[node]
+	if !exists {
+		return // removed while it was probed
+	}
+	var now time.Time = time.Now()
+	peer.LastSeen = &now
+	peer.ConsecutiveFailures = 0
+	peer.LatencyMs = float64(latency.Microseconds()) / 1000
+	peer.ChainHeight = chainHeight
+	peer.LastError = ""
+	peer.Status = PeerHealthy
+}
+
+// RecordPeerFailure records that a node failed a probe. The node is evicted once it has failed
+// maxFailures probes in a row (never if maxFailures is 0); returns true if it was evicted
+func (b *BlockChain) RecordPeerFailure(node string, failure error, maxFailures int) bool {
+	b.nodesMutex.Lock()
+	defer b.nodesMutex.Unlock()
+
+	peer, exists := b.NetworkNodes[node]
+	if !exists {
+		return false
+	}
+	var now time.Time = time.Now()
+	peer.LastFailure = &now
+	peer.ConsecutiveFailures++
+	peer.TotalFailures++
+	peer.LastError = failure.Error()
+	peer.Status = PeerFailing
+	if maxFailures > 0 && peer.ConsecutiveFailures >= maxFailures {
+		delete(b.NetworkNodes, node)
+		b.savePeers()
+		return true
+	}
+	return false
+}
+
+// SavePeers stores the peer table (i.e., after a probe round has updated it)
+func (b *BlockChain) SavePeers() {
+	b.nodesMutex.RLock()
+	defer b.nodesMutex.RUnlock()
+	b.savePeers()
+}
+
+// savePeers stores the peer table. Losing it is not fatal (nodes register again), so failures are
+// only logged. Must be called with nodesMutex held
+func (b *BlockChain) savePeers() {
+	if err := b.store.SavePeers(b.peerRecords()); err != nil {
+		log.Printf("Failed to store network nodes: %s", err)
+	}
+}
+
+// nodeList returns the known network nodes as a list. Must be called with nodesMutex held
+func (b *BlockChain) nodeList() []string {
+	var nodes []string = make([]string, 0, len(b.NetworkNodes))
+	for node := range b.NetworkNodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// peerRecords returns copies of the peer records, sorted by url. Must be called with nodesMutex held
+func (b *BlockChain) peerRecords() []PeerRecord {
+	var records []PeerRecord = make([]PeerRecord, 0, len(b.NetworkNodes))
+	for _, node := range b.nodeList() {
+		records = append(records, *b.NetworkNodes[node])
+	}
+	return records
+}
+
+/* Probes */
+
+// PeerMonitor probes the known nodes of a blockchain in the background
+type PeerMonitor struct {
+	blockChain *BlockChain
+	selfUrl    string
+	config     PeerConfig
+	client     *http.Client
+	stop       chan struct{}
+	done       chan struct{}
+	startOnce  sync.Once
+	stopOnce   sync.Once
+}
+
+// NewPeerMonitor creates a monitor for the known nodes of a blockchain. It probes them once started.
+// selfUrl is never probed
+func NewPeerMonitor(blockChain *BlockChain, selfUrl string, config PeerConfig) *PeerMonitor {
+	var monitor *PeerMonitor = &PeerMonitor{
+		blockChain: blockChain,
+		selfUrl:    selfUrl,
+		config:     config,
+		client:     &http.Client{Timeout: config.ProbeTimeout},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	return monitor
+}
+
+// Start starts probing in the background. Later calls, and calls after Stop, do nothing
+func (m *PeerMonitor) Start() {
+	m.startOnce.Do(func() { go m.run() })
+}
+
+// Stop stops probing, once the current probe round is over. A monitor that was not started never starts
+func (m *PeerMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.startOnce.Do(func() { close(m.done) })
+	<-m.done
+}
+
+func (m *PeerMonitor) run() {
+	defer close(m.done)
+	if m.config.ProbeInterval <= 0 {
+		return // monitoring disabled
+	}
+	var ticker *time.Ticker = time.NewTicker(m.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.ProbeAll()
+		}
+	}
+}
+
+// ProbeAll probes every known node, concurrently, and stores the updated peer table
+func (m *PeerMonitor) ProbeAll() {
+	var probes sync.WaitGroup
+	for _, peer := range m.blockChain.GetNetworkNodes() {
+		if peer == m.selfUrl {
+			continue
+		}
+		probes.Add(1)
+		go func(peer string) {
+			defer probes.Done()
+			m.probe(peer)
+		}(peer)
+	}
+	probes.Wait()
+	m.blockChain.SavePeers()
+}
+
+// probe calls GET /health on a peer and records the result
+func (m *PeerMonitor) probe(peer string) {
+	var start time.Time = time.Now()
+	health, err := m.getHealth(peer)
+	if err != nil {
+		if m.blockChain.RecordPeerFailure(peer, err, m.config.MaxFailures) {
+			log.Printf("Node %s failed %d probes in a row and was removed: %s", peer, m.config.MaxFailures, err)
+		}
+		return
+	}
+	m.blockChain.RecordPeerSuccess(peer, time.Since(start), health.ChainHeight)
+}
+
+func (m *PeerMonitor) getHealth(peer string) (NodeHealth, error) {
+	var health NodeHealth
+	response, err := m.client.Get(peer + "/health")
+	if err != nil {
+		return health, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return health, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	if err = json.NewDecoder(response.Body).Decode(&health); err != nil {
+		return health, fmt.Errorf("invalid health response: %w", err)
+	}
+	return health, nil
+}
@@ -0,0 +1,75 @@
+package bid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Probes record the health of each peer; a peer that fails MaxFailures probes in a row is evicted, and
+// the peer table is stored
+func TestPeerProbes(t *testing.T) {
+	var healthy *Controller = &Controller{blockChain: newTestChain(t), currentNodeUrl: "http://localhost:9101"}
+	var healthyServer *httptest.Server = httptest.NewServer(http.HandlerFunc(healthy.GetHealth))
+	defer healthyServer.Close()
+	var failing *httptest.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	if _, err := mineBlock(healthy.blockChain); err != nil {
+		t.Fatal(err)
+	}
+
+	var store Storage = NewMemoryStorage()
+	b, err := NewBlockChain(store, TestGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.RegisterNode(healthyServer.URL)
+	b.RegisterNode(failing.URL)
+	var config PeerConfig = DefaultPeerConfig()
+	config.MaxFailures = 2
+	var monitor *PeerMonitor = NewPeerMonitor(b, "http://localhost:9100", config)
+
+	monitor.ProbeAll()
+	var peers []PeerRecord = b.GetPeers()
+	if len(peers) != 2 {
+		t.Fatalf("%d peers after one probe", len(peers))
+	}
+	for _, peer := range peers {
+		switch peer.Url {
+		case healthyServer.URL:
+			if peer.Status != PeerHealthy || peer.LastSeen == nil || peer.ChainHeight != 2 || peer.ConsecutiveFailures != 0 {
+				t.Fatalf("healthy peer %+v", peer)
+			}
+		case failing.URL:
+			if peer.Status != PeerFailing || peer.LastFailure == nil || peer.ConsecutiveFailures != 1 || peer.LastError == "" {
+				t.Fatalf("failing peer %+v", peer)
+			}
+		}
+	}
+
+	monitor.ProbeAll()
+	if peers = b.GetPeers(); len(peers) != 1 || peers[0].Url != healthyServer.URL {
+		t.Fatalf("failing peer not evicted: %+v", peers)
+	}
+	stored, err := store.LoadPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Url != healthyServer.URL || stored[0].Status != PeerHealthy {
+		t.Fatalf("stored peers %+v", stored)
+	}
+	reloaded, err := NewBlockChain(store, TestGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers = reloaded.GetPeers(); len(peers) != 1 || peers[0].Url != healthyServer.URL {
+		t.Fatalf("reloaded peers %+v", peers)
+	}
+
+	// Peers can also be removed by hand
+	if !reloaded.RemoveNode(healthyServer.URL) || reloaded.RemoveNode(healthyServer.URL) || len(reloaded.GetPeers()) != 0 {
+		t.Fatalf("peer not removed")
+	}
+}
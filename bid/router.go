@@ -9,109 +9,271 @@ import (
 	"time"
 )
 
-// Instantiate a controller object so that routes can be initialized
-var controller *Controller = &Controller{
-	blockChain:     &BlockChain{
-		Chain:        Blocks{},
-		PendingBids:  Bids{},
-		NetworkNodes: map[string]bool{},
-	},
-	currentNodeUrl: "",
-}
-
-// Define all routes (name, http method, path, and controller api)
-var routes []Route = []Route{
-	Route{
-		Name:        "Index",
-		Method:      "GET",
-		Path:        "/",
-		HandlerFunc: controller.Index,
-	},
-	Route{
-		Name:        "GetBlockChain",
-		Method:      "GET",
-		Path:        "/blockchain",
-		HandlerFunc: controller.GetBlockChain,
-	},
-	Route{
-		Name:        "RegisterAndBroadcastBid",
-		Method:      "POST",
-		Path:        "/bid/broadcast",
-		HandlerFunc: controller.RegisterAndBroadcastBid,
-	},
-	Route{
-		Name:        "RegisterBid",
-		Method:      "POST",
-		Path:        "/bid",
-		HandlerFunc: controller.RegisterBid,
-	},
-	Route{
-		Name:        "RegisterAndBroadcastNode",
-		Method:      "POST",
-		Path:        "/register-and-broadcast-node",
-		HandlerFunc: controller.RegisterAndBroadcastNode,
-	},
-	Route{
-		Name:        "RegisterNode",
-		Method:      "POST",
-		Path:        "/register-node",
-		HandlerFunc: controller.RegisterNode,
-	},
-	Route{
-		Name:        " RegisterNodesBulk",
-		Method:      "POST",
-		Path:        "/register-nodes-bulk",
-		HandlerFunc: controller.RegisterNodesBulk,
-	},
-	Route{
-		Name:        "Mine",
-		Method:      "GET",
-		Path:        "/mine",
-		HandlerFunc: controller.Mine,
-	},
-	Route{
-		Name:        "ReceiveNewBlock",
-		Method:      "POST",
-		Path:        "/receive-new-block",
-		HandlerFunc: controller.ReceiveNewBlock,
-	},
-	Route{
-		Name:        "Consensus",
-		Method:      "GET",
-		Path:        "/consensus",
-		HandlerFunc: controller.Consensus,
-	},
-	Route{
-		Name:        "GetBidsForAuction",
-		Method:      "GET",
-		Path:        "/auction/{auctionId}",
-		HandlerFunc: controller.GetBidsForAuction,
-	},
-	Route{
-		Name:        "GetBidsForPlayer",
-		Method:      "GET",
-		Path:        "/player/{playerId}",
-		HandlerFunc: controller.GetBidsForPlayer,
-	},
+// routes defines all routes of a node (name, http method, path, and controller api)
+func (c *Controller) routes() []Route {
+	return []Route{
+		Route{
+			Name:        "Index",
+			Method:      "GET",
+			Path:        "/",
+			HandlerFunc: c.Index,
+		},
+		Route{
+			Name:        "GetBlockChain",
+			Method:      "GET",
+			Path:        "/blockchain",
+			HandlerFunc: c.GetBlockChain,
+		},
+		Route{
+			Name:        "RegisterAndBroadcastBid",
+			Method:      "POST",
+			Path:        "/bid/broadcast",
+			HandlerFunc: c.RegisterAndBroadcastBid,
+		},
+		Route{
+			Name:        "RegisterBid",
+			Method:      "POST",
+			Path:        "/bid",
+			HandlerFunc: c.RegisterBid,
+		},
+		Route{
+			Name:        "GetBidProof",
+			Method:      "GET",
+			Path:        "/bid/{bidHash}/proof",
+			HandlerFunc: c.GetBidProof,
+		},
+		Route{
+			Name:        "RegisterAndBroadcastNode",
+			Method:      "POST",
+			Path:        "/register-and-broadcast-node",
+			HandlerFunc: c.RegisterAndBroadcastNode,
+		},
+		Route{
+			Name:        "RegisterNode",
+			Method:      "POST",
+			Path:        "/register-node",
+			HandlerFunc: c.RegisterNode,
+		},
+		Route{
+			Name:        "RegisterNodesBulk",
+			Method:      "POST",
+			Path:        "/register-nodes-bulk",
+			HandlerFunc: c.RegisterNodesBulk,
+		},
+		Route{
+			Name:        "Mine",
+			Method:      "GET",
+			Path:        "/mine",
+			HandlerFunc: c.Mine,
+		},
+		Route{
+			Name:        "GetMiningJob",
+			Method:      "GET",
+			Path:        "/mine/jobs/{jobId}",
+			HandlerFunc: c.GetMiningJob,
+		},
+		Route{
+			Name:        "CancelMiningJob",
+			Method:      "POST",
+			Path:        "/mine/jobs/{jobId}/cancel",
+			HandlerFunc: c.CancelMiningJob,
+		},
+		Route{
+			Name:        "ReceiveNewBlock",
+			Method:      "POST",
+			Path:        "/receive-new-block",
+			HandlerFunc: c.ReceiveNewBlock,
+		},
+		Route{
+			Name:        "Consensus",
+			Method:      "GET",
+			Path:        "/consensus",
+			HandlerFunc: c.Consensus,
+		},
+		Route{
+			Name:        "GetBidsForAuction",
+			Method:      "GET",
+			Path:        "/auction/{auctionId}",
+			HandlerFunc: c.GetBidsForAuction,
+		},
+		Route{
+			Name:        "RegisterAndBroadcastAuctionRecord",
+			Method:      "POST",
+			Path:        "/auction/broadcast",
+			HandlerFunc: c.RegisterAndBroadcastAuctionRecord,
+		},
+		Route{
+			Name:        "RegisterAuctionRecord",
+			Method:      "POST",
+			Path:        "/auction",
+			HandlerFunc: c.RegisterAuctionRecord,
+		},
+		Route{
+			Name:        "GetAuction",
+			Method:      "GET",
+			Path:        "/auction/{auctionId}/state",
+			HandlerFunc: c.GetAuction,
+		},
+		Route{
+			Name:        "GetAuctionSettlement",
+			Method:      "GET",
+			Path:        "/auction/{auctionId}/settlement",
+			HandlerFunc: c.GetAuctionSettlement,
+		},
+		Route{
+			Name:        "GetAuctions",
+			Method:      "GET",
+			Path:        "/auctions",
+			HandlerFunc: c.GetAuctions,
+		},
+		Route{
+			Name:        "GetBid",
+			Method:      "GET",
+			Path:        "/bid/{bidHash}",
+			HandlerFunc: c.GetBid,
+		},
+		Route{
+			Name:        "GetTip",
+			Method:      "GET",
+			Path:        "/block/tip",
+			HandlerFunc: c.GetTip,
+		},
+		Route{
+			Name:        "GetBlockByIndex",
+			Method:      "GET",
+			Path:        "/block/{index:[0-9]+}",
+			HandlerFunc: c.GetBlockByIndex,
+		},
+		Route{
+			Name:        "GetBlocks",
+			Method:      "GET",
+			Path:        "/blocks",
+			HandlerFunc: c.GetBlocks,
+		},
+		Route{
+			Name:        "GetBlockByHash",
+			Method:      "GET",
+			Path:        "/block/hash/{blockHash}",
+			HandlerFunc: c.GetBlockByHash,
+		},
+		Route{
+			Name:        "GetHeaders",
+			Method:      "GET",
+			Path:        "/sync/headers",
+			HandlerFunc: c.GetHeaders,
+		},
+		Route{
+			Name:        "GetSyncBlocks",
+			Method:      "GET",
+			Path:        "/sync/blocks",
+			HandlerFunc: c.GetSyncBlocks,
+		},
+		Route{
+			Name:        "GetSyncStatus",
+			Method:      "GET",
+			Path:        "/sync",
+			HandlerFunc: c.GetSyncStatus,
+		},
+		Route{
+			Name:        "GetMempool",
+			Method:      "GET",
+			Path:        "/mempool",
+			HandlerFunc: c.GetMempool,
+		},
+		Route{
+			Name:        "GetPendingBids",
+			Method:      "GET",
+			Path:        "/mempool/bids",
+			HandlerFunc: c.GetPendingBids,
+		},
+		Route{
+			Name:        "GetPendingBidsForAuction",
+			Method:      "GET",
+			Path:        "/auction/{auctionId}/pending-bids",
+			HandlerFunc: c.GetPendingBidsForAuction,
+		},
+		Route{
+			Name:        "GetReorgs",
+			Method:      "GET",
+			Path:        "/reorgs",
+			HandlerFunc: c.GetReorgs,
+		},
+		Route{
+			Name:        "GetEvents",
+			Method:      "GET",
+			Path:        "/events",
+			HandlerFunc: c.GetEvents,
+		},
+		Route{
+			Name:        "RegisterWebhook",
+			Method:      "POST",
+			Path:        "/webhooks",
+			HandlerFunc: c.RegisterWebhook,
+		},
+		Route{
+			Name:        "GetWebhooks",
+			Method:      "GET",
+			Path:        "/webhooks",
+			HandlerFunc: c.GetWebhooks,
+		},
+		Route{
+			Name:        "RemoveWebhook",
+			Method:      "DELETE",
+			Path:        "/webhooks/{webhookId}",
+			HandlerFunc: c.RemoveWebhook,
+		},
+		Route{
+			Name:        "GetWebhookDeliveries",
+			Method:      "GET",
+			Path:        "/webhooks/{webhookId}/deliveries",
+			HandlerFunc: c.GetWebhookDeliveries,
+		},
+		Route{
+			Name:        "GetGossipMetrics",
+			Method:      "GET",
+			Path:        "/gossip",
+			HandlerFunc: c.GetGossipMetrics,
+		},
+		Route{
+			Name:        "GetHealth",
+			Method:      "GET",
+			Path:        "/health",
+			HandlerFunc: c.GetHealth,
+		},
+		Route{
+			Name:        "GetPeers",
+			Method:      "GET",
+			Path:        "/peers",
+			HandlerFunc: c.GetPeers,
+		},
+		Route{
+			Name:        "RemovePeer",
+			Method:      "DELETE",
+			Path:        "/peers",
+			HandlerFunc: c.RemovePeer,
+		},
+		Route{
+			Name:        "GetBidsForPlayer",
+			Method:      "GET",
+			Path:        "/player/{playerId}",
+			HandlerFunc: c.GetBidsForPlayer,
+		},
+	}
 }
 
-func NewRouter(port string) *mux.Router {
-	// Initialize a controller object that holds the blockchain and the url
-	// of the node on which this controller  is running
-	controller.currentNodeUrl  = "http://localhost" + port
-	controller.blockChain.CreateNewBlock(100, "0", "0")	// genesis block
-
+// newRouter creates the router of a node: the routes of its controller, with request logging
+func newRouter(c *Controller) *mux.Router {
 	/* mux.Router matches incoming requests against a list of registered routes and calls
 	a handler for the route that matches the URL or other condition. It implements the
 	http.Handler interface so it is compatible with the standard http.ServeMux.
 	*/
 	var router *mux.Router = mux.NewRouter().StrictSlash(true)
 
-	// Configure the router with all route elements in the routes array. For example, this code:
+	// Configure the router with all route elements of the controller. For example, this code:
 	// router.Methods("GET").Path("/consensus").Handler(controller.Consensus).Name("Consensus")
 	// means that any GET method sent to /consensus will be routed to controller.Consensus method
 	// The Name() method has no effect on the path; it allows us to easily locate a route by name
-	for _, route := range routes {
+	for _, route := range c.routes() {
 		router.
 			Methods(route.Method).
 			Path(route.Path).
@@ -133,16 +295,6 @@ func NewRouter(port string) *mux.Router {
 		})
 	})
 
-	/*//type MiddlewareFunc func(http.Handler) http.Handler
-	var loggingHandlerFunc http.HandlerFunc = func(writer http.ResponseWriter, request *http.Request) {
-		start := time.Now()
-		log.Printf("[%s] [%s] [Ellapsed: %s]", request.Method, request.RequestURI, time.Since(start))
-	}
-	var mwLogging mux.MiddlewareFunc = func(handler http.Handler) http.Handler {
-		return loggingHandlerFunc
-	}
-	router.Use( mwLogging )*/
-
 	// Return the fully configured router
 	return router
 }
\ No newline at end of file
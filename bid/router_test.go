@@ -0,0 +1,17 @@
+package bid
+
+import (
+	"strings"
+	"testing"
+)
+
+// Route names locate routes (see newRouter): each must be unique, without surrounding spaces
+func TestRouteNames(t *testing.T) {
+	var names map[string]bool = map[string]bool{}
+	for _, route := range (&Controller{}).routes() {
+		if route.Name == "" || route.Name != strings.TrimSpace(route.Name) || names[route.Name] {
+			t.Errorf("route %s %s: bad or duplicate name %q", route.Method, route.Path, route.Name)
+		}
+		names[route.Name] = true
+	}
+}
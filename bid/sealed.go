@@ -0,0 +1,128 @@
+/* Sealed-bid auctions. In an open auction every bid value is public as soon as the bid is posted, so
+a later bidder can always outbid by a cent. In a sealed auction (Mode "sealed") bidding happens in
+two windows:
+1. Bidding window, from OpenTime to CloseTime: bidders post commit bids (Kind "commit") that carry
+   only a commitment to their value, and no value
+2. Reveal window, from CloseTime to RevealTime: bidders post reveal bids (Kind "reveal") that carry
+   the commitment, the value and the salt. The reveal must come from the bidder who made the
+   commitment, and the commitment must match the value and salt (see ComputeCommitment)
+
+Every node checks reveals against the commitments in the chain, so a bidder cannot change the value
+after seeing other bids. Each commitment can be revealed once. Commitments that are never revealed are
+ignored: only valid reveals take part in winner determination. The seller closes the auction once
+RevealTime is past.
+
+The commitment is the SHA-256, as a hex string, of the canonical encoding (see encoding.go) of:
+	auction id, bidder public key, bid value, salt
+Binding the auction and the bidder to the commitment prevents copying someone else's commitment. The
+salt must be random and long enough (at least minSaltLength characters) that the value cannot be
+found by trying all likely values */
+package bid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Auction modes
+const (
+	AuctionModeOpen   = "open"
+	AuctionModeSealed = "sealed"
+)
+
+// Kinds of bids in sealed auctions. Bids in open auctions have no kind
+const (
+	BidKindCommit = "commit"
+	BidKindReveal = "reveal"
+)
+
+// Minimum length of the salt of a sealed bid
+const minSaltLength = 16
+
+// sealedCommitment is a commitment made in a sealed auction: who made it and whether it was revealed
+type sealedCommitment struct {
+	publicKey string
+	revealed  bool
+}
+
+// ComputeCommitment computes the commitment to a sealed bid's value
+func ComputeCommitment(auctionId int, publicKey string, value Money, salt string) string {
+	var buffer bytes.Buffer
+	writeCanonicalInt(&buffer, int64(auctionId))
+	writeCanonicalString(&buffer, publicKey)
+	writeCanonicalMoney(&buffer, value)
+	writeCanonicalString(&buffer, salt)
+	var digest [sha256.Size]byte = sha256.Sum256(buffer.Bytes())
+	return hex.EncodeToString(digest[:])
+}
+
+// checkSealedBidFields returns the reason why the sealed bid fields of a bid do not match its kind,
+// or "" if they do. Whether the bid fits the auction is checked by the ledger
+func checkSealedBidFields(bid Bid) string {
+	switch bid.Kind {
+	case "":
+		if bid.Commitment != "" || bid.Salt != "" {
+			return "commitment and salt are only used by commit and reveal bids"
+		}
+		if bid.BidValue.Units <= 0 {
+			return "bid_value must be positive"
+		}
+	case BidKindCommit:
+		if bid.BidValue != (Money{}) || bid.Salt != "" {
+			return "a commit bid must not carry its value or salt"
+		}
+		if decoded, err := hex.DecodeString(bid.Commitment); err != nil || len(decoded) != sha256.Size ||
+			hex.EncodeToString(decoded) != bid.Commitment {
+			return fmt.Sprintf("commitment must be %d bytes written as lowercase hex", sha256.Size)
+		}
+	case BidKindReveal:
+		if len(bid.Salt) < minSaltLength {
+			return fmt.Sprintf("salt must be at least %d characters", minSaltLength)
+		}
+		if bid.BidValue.Units <= 0 {
+			return "bid_value must be positive"
+		}
+		if ComputeCommitment(bid.AuctionId, bid.PublicKey, bid.BidValue, bid.Salt) != bid.Commitment {
+			return "value and salt do not match the commitment"
+		}
+	default:
+		return fmt.Sprintf("kind must be empty, %q or %q", BidKindCommit, BidKindReveal)
+	}
+	return ""
+}
+
+// applySealedBid records a commit or reveal bid in a sealed auction, at the given block time. The
+// auction is only changed when "" is returned
+func (auction *AuctionState) applySealedBid(bid Bid, timestamp int64) string {
+	var status string = auction.statusAt(timestamp)
+	switch bid.Kind {
+	case BidKindCommit:
+		if status != AuctionOpen {
+			return fmt.Sprintf("auction %d is not open for commitments (%s) at block time %d", bid.AuctionId, status, timestamp)
+		}
+		if _, exists := auction.commitments[bid.Commitment]; exists {
+			return fmt.Sprintf("commitment %s is already used in auction %d", bid.Commitment, bid.AuctionId)
+		}
+		auction.commitments[bid.Commitment] = sealedCommitment{publicKey: bid.PublicKey}
+		auction.CommitmentCount++
+		return ""
+
+	case BidKindReveal:
+		if status != AuctionRevealing {
+			return fmt.Sprintf("auction %d is not open for reveals (%s) at block time %d", bid.AuctionId, status, timestamp)
+		}
+		commitment, exists := auction.commitments[bid.Commitment]
+		if !exists || commitment.publicKey != bid.PublicKey {
+			return fmt.Sprintf("no commitment %s of the bidder in auction %d", bid.Commitment, bid.AuctionId)
+		}
+		if commitment.revealed {
+			return fmt.Sprintf("commitment %s is already revealed", bid.Commitment)
+		}
+		auction.commitments[bid.Commitment] = sealedCommitment{publicKey: bid.PublicKey, revealed: true}
+		auction.recordValue(bid)
+		return ""
+	}
+	return fmt.Sprintf("auction %d is sealed: bids must be %q or %q bids", bid.AuctionId, BidKindCommit, BidKindReveal)
+}
@@ -0,0 +1,100 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// sealedTestBid returns a commit or reveal bid of bidder for the given value and salt
+func sealedTestBid(bidder ed25519.PrivateKey, auctionId int, kind string, value string, salt string, sequence uint64) Bid {
+	var publicKey string = hex.EncodeToString(bidder.Public().(ed25519.PublicKey))
+	var bid Bid = Bid{ChainId: DefaultChainId, AuctionId: auctionId, Kind: kind, Sequence: sequence,
+		Commitment: ComputeCommitment(auctionId, publicKey, MustParseMoney(value), salt)}
+	if kind == BidKindReveal {
+		bid.BidValue, bid.Salt = MustParseMoney(value), salt
+	}
+	SignBid(&bid, bidder)
+	return bid
+}
+
+func TestSealedBidWindows(t *testing.T) {
+	const salt = "0123456789abcdef"
+	var seller, alice, bob, carol ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey(), newTestKey()
+	var state *ledger = newLedger()
+	var sealed = func(record *AuctionRecord) { record.Mode, record.RevealTime = AuctionModeSealed, auctionReveal }
+	expectReason(t, "create", state.applyCreate(testAuction(seller, 1, sealed), testBlock(2, auctionOpen)), "")
+
+	// Bob reveals with another salt than he committed with
+	var badReveal Bid = sealedTestBid(bob, 1, BidKindReveal, "30.00", salt, 11)
+	badReveal.Salt = "fedcba9876543210"
+	SignBid(&badReveal, bob)
+	var tests = []struct {
+		name      string
+		bid       Bid
+		timestamp int64
+		reason    string
+	}{
+		{"commit before open time", sealedTestBid(alice, 1, BidKindCommit, "10.00", salt, 1), auctionOpen - 1, "not open for commitments (scheduled)"},
+		{"commit", sealedTestBid(alice, 1, BidKindCommit, "10.00", salt, 1), auctionOpen, ""},
+		{"same commitment again", sealedTestBid(alice, 1, BidKindCommit, "10.00", salt, 2), auctionOpen, "is already used"},
+		{"other commit", sealedTestBid(bob, 1, BidKindCommit, "30.00", salt, 1), auctionOpen, ""},
+		{"commit of carol", sealedTestBid(carol, 1, BidKindCommit, "20.00", salt, 1), auctionOpen, ""},
+		{"plain bid", testBid(alice, 1, "10.00", 3), auctionOpen, "is sealed"},
+		{"commit with its value", func() Bid {
+			var bid Bid = sealedTestBid(alice, 1, BidKindCommit, "15.00", salt, 3)
+			bid.BidValue = MustParseMoney("15.00")
+			SignBid(&bid, alice)
+			return bid
+		}(), auctionOpen, "must not carry its value"},
+		{"reveal before close time", sealedTestBid(alice, 1, BidKindReveal, "10.00", salt, 4), auctionClose - 1, "not open for reveals (open)"},
+		{"commit at close time", sealedTestBid(alice, 1, BidKindCommit, "15.00", salt, 4), auctionClose, "not open for commitments (revealing)"},
+		{"reveal", sealedTestBid(alice, 1, BidKindReveal, "10.00", salt, 4), auctionClose, ""},
+		{"reveal again", sealedTestBid(alice, 1, BidKindReveal, "10.00", salt, 5), auctionClose, "is already revealed"},
+		{"reveal of another value", sealedTestBid(bob, 1, BidKindReveal, "35.00", salt, 10), auctionClose, "no commitment"},
+		{"reveal with another salt", badReveal, auctionClose, "value and salt do not match the commitment"},
+		{"short salt", sealedTestBid(bob, 1, BidKindReveal, "30.00", "short", 10), auctionClose, "salt must be at least"},
+		{"reveal of someone else's commitment", func() Bid {
+			// Alice copies Bob's commitment, value and salt: the commitment binds Bob's public key
+			var bid Bid = sealedTestBid(bob, 1, BidKindReveal, "30.00", salt, 10)
+			SignBid(&bid, alice)
+			return bid
+		}(), auctionClose, "value and salt do not match the commitment"},
+		{"reveal at reveal time", sealedTestBid(bob, 1, BidKindReveal, "30.00", salt, 10), auctionReveal, "not open for reveals (ended)"},
+		{"reveal before reveal time", sealedTestBid(bob, 1, BidKindReveal, "30.00", salt, 10), auctionReveal - 1, ""},
+	}
+	for _, test := range tests {
+		expectReason(t, test.name, state.applyBid(test.bid, test.timestamp), test.reason)
+	}
+	var auction *AuctionState = state.auctions[1]
+	if auction.CommitmentCount != 3 || auction.BidCount != 2 {
+		t.Fatalf("got %d commitments and %d reveals, expected 3 and 2", auction.CommitmentCount, auction.BidCount)
+	}
+
+	// Sealed auctions close once the reveal window is over. Carol never revealed: her bid does not count
+	expectReason(t, "close before reveal time", state.applyClose(testClose(seller, 1), testBlock(5, auctionReveal-1)),
+		"cannot be closed (revealing)")
+	expectReason(t, "close", state.applyClose(testClose(seller, 1), testBlock(5, auctionReveal)), "")
+	var settlement *Settlement = auction.Settlement
+	if settlement == nil || settlement.BidCount != 2 || settlement.WinningBid == nil ||
+		settlement.WinningBid.PublicKey != hex.EncodeToString(bob.Public().(ed25519.PublicKey)) ||
+		settlement.ClearingPrice != MustParseMoney("30.00") {
+		t.Fatalf("got settlement %+v", settlement)
+	}
+}
+
+// A reveal can only be taken for a commitment made in the chain, so reveals ride on the ledger clone
+// like any bid: a reveal checked on a clone does not reveal the commitment in the original
+func TestSealedLedgerClone(t *testing.T) {
+	const salt = "0123456789abcdef"
+	var seller, alice ed25519.PrivateKey = newTestKey(), newTestKey()
+	var state *ledger = newLedger()
+	expectReason(t, "create", state.applyCreate(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.Mode, record.RevealTime = AuctionModeSealed, auctionReveal
+	}), testBlock(2, auctionOpen)), "")
+	expectReason(t, "commit", state.applyBid(sealedTestBid(alice, 1, BidKindCommit, "10.00", salt, 1), auctionOpen), "")
+
+	var reveal Bid = sealedTestBid(alice, 1, BidKindReveal, "10.00", salt, 2)
+	expectReason(t, "reveal on a clone", state.clone().applyBid(reveal, auctionClose), "")
+	expectReason(t, "reveal", state.applyBid(reveal, auctionClose), "")
+}
@@ -0,0 +1,128 @@
+/* Settlement of closed auctions. When the close record of an auction is applied, the auction's
+settlement rule computes the winner and the price the winner pays (the clearing price) from the bids
+recorded in the chain. Each auction names its rule in its create record, and rules only use the bids
+(in chain order) and the reserve price, so every node computes the same settlement.
+
+Rules are pluggable: a rule implements SettlementRule and is added to settlementRules under its name.
+The available rules are:
+	first-price		the highest bid wins and pays its own value (the default)
+	second-price	the highest bid wins and pays the second highest value (Vickrey auction), or the
+					reserve price if that is higher (or if there is no other bid)
+With both rules, the earliest bid wins among equal values, and a winning bid must reach the reserve
+price, otherwise there is no winner.
+
+The reserve price is either public (reserve_price in the create record) or hidden: the create record
+then only carries reserve_commitment, a commitment to the reserve price computed like the commitment of
+a sealed bid (see ComputeCommitment, with the seller's public key). The close record reveals the
+reserve price and its salt, and is only valid if they match the commitment */
+package bid
+
+import (
+	"fmt"
+)
+
+// Names of the settlement rules
+const (
+	SettlementFirstPrice  = "first-price"
+	SettlementSecondPrice = "second-price"
+)
+
+// SettlementRule computes the outcome of an auction. bids are the auction's bids with a value (all
+// bids of an open auction, the valid reveals of a sealed auction) in chain order. The result must only
+// depend on the arguments, so that all nodes agree
+type SettlementRule interface {
+	Settle(bids Bids, reservePrice Money) Settlement
+}
+
+// settlementRules are the rules auctions can use, by name
+var settlementRules map[string]SettlementRule = map[string]SettlementRule{
+	SettlementFirstPrice:  firstPriceRule{},
+	SettlementSecondPrice: secondPriceRule{},
+}
+
+// settlementRuleOf returns the name of the rule used by an auction
+func settlementRuleOf(record AuctionRecord) string {
+	if record.Rule == "" {
+		return SettlementFirstPrice
+	}
+	return record.Rule
+}
+
+// firstPriceRule: the highest bid wins and pays its value
+type firstPriceRule struct{}
+
+func (firstPriceRule) Settle(bids Bids, reservePrice Money) Settlement {
+	var settlement Settlement = Settlement{BidCount: len(bids), ReservePrice: reservePrice}
+	highest, _ := highestBids(bids)
+	if highest == nil || highest.BidValue.Cmp(reservePrice) < 0 {
+		return settlement
+	}
+	settlement.WinningBid = highest
+	settlement.ClearingPrice = highest.BidValue
+	return settlement
+}
+
+// secondPriceRule: the highest bid wins and pays the second highest value, but at least the reserve price
+type secondPriceRule struct{}
+
+func (secondPriceRule) Settle(bids Bids, reservePrice Money) Settlement {
+	var settlement Settlement = Settlement{BidCount: len(bids), ReservePrice: reservePrice}
+	highest, second := highestBids(bids)
+	if highest == nil || highest.BidValue.Cmp(reservePrice) < 0 {
+		return settlement
+	}
+	settlement.WinningBid = highest
+	settlement.ClearingPrice = reservePrice
+	if second != nil && second.BidValue.Cmp(reservePrice) > 0 {
+		settlement.ClearingPrice = second.BidValue
+	}
+	return settlement
+}
+
+// highestBids returns the highest and second highest bids (nil if there are not enough bids). Among
+// equal values the earliest bid ranks first
+func highestBids(bids Bids) (highest *Bid, second *Bid) {
+	for i := range bids {
+		var bid *Bid = &bids[i]
+		switch {
+		case highest == nil || bid.BidValue.Cmp(highest.BidValue) > 0:
+			highest, second = bid, highest
+		case second == nil || bid.BidValue.Cmp(second.BidValue) > 0:
+			second = bid
+		}
+	}
+	return highest, second
+}
+
+// checkSettlementRule returns the reason why an auction's rule is not supported, or "" if it is
+func checkSettlementRule(record AuctionRecord) string {
+	if _, exists := settlementRules[settlementRuleOf(record)]; !exists {
+		return fmt.Sprintf("unknown settlement rule %q", record.Rule)
+	}
+	return ""
+}
+
+// revealedReservePrice returns the reserve price of an auction as of its close record: the public
+// reserve price, or the hidden one revealed by the close record. Returns a reason if the close record
+// does not reveal the hidden reserve price correctly (or reveals a reserve price that is public)
+// The reserve price is returned in the currency of the auction, also when there is none (zero)
+func revealedReservePrice(auction AuctionRecord, close AuctionRecord) (Money, string) {
+	if auction.ReserveCommitment == "" {
+		if close.ReservePrice != (Money{}) || close.ReserveSalt != "" {
+			return Money{}, "the reserve price of this auction is public: the close record must not reveal it"
+		}
+		return Money{Units: auction.ReservePrice.Units, Currency: auction.Currency}, ""
+	}
+	if len(close.ReserveSalt) < minSaltLength {
+		return Money{}, fmt.Sprintf("the close record must reveal the hidden reserve price, with a salt of at least %d characters",
+			minSaltLength)
+	}
+	if ComputeCommitment(auction.AuctionId, auction.Seller, close.ReservePrice, close.ReserveSalt) != auction.ReserveCommitment {
+		return Money{}, "reserve price and salt do not match the reserve commitment"
+	}
+	if close.ReservePrice.Units < 0 || close.ReservePrice.Currency != auction.Currency {
+		return Money{}, fmt.Sprintf("the hidden reserve price must not be negative and must be in the currency of the auction (%q)",
+			auction.Currency)
+	}
+	return close.ReservePrice, ""
+}
@@ -0,0 +1,193 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// settlementTestBids returns bids with the given values, in that order, each from another bidder
+func settlementTestBids(values ...string) Bids {
+	var bids Bids = Bids{}
+	for i, value := range values {
+		bids = append(bids, Bid{BidderName: string(rune('a' + i)), BidValue: MustParseMoney(value)})
+	}
+	return bids
+}
+
+func TestHighestBids(t *testing.T) {
+	var tests = []struct {
+		values          []string
+		highest, second int // positions, -1 for none
+	}{
+		{[]string{}, -1, -1},
+		{[]string{"10.00"}, 0, -1},
+		{[]string{"10.00", "20.00"}, 1, 0},
+		{[]string{"30.00", "10.00", "20.00"}, 0, 2},
+		// The earliest bid ranks first among equal values
+		{[]string{"20.00", "20.00"}, 0, 1},
+		{[]string{"10.00", "20.00", "20.00", "20.00"}, 1, 2},
+		{[]string{"20.00", "10.00", "10.00"}, 0, 1},
+	}
+	for _, test := range tests {
+		var bids Bids = settlementTestBids(test.values...)
+		highest, second := highestBids(bids)
+		if position(bids, highest) != test.highest || position(bids, second) != test.second {
+			t.Errorf("%v: got positions %d and %d, expected %d and %d", test.values,
+				position(bids, highest), position(bids, second), test.highest, test.second)
+		}
+	}
+}
+
+// position returns the position of a bid among bids, or -1 for nil
+func position(bids Bids, bid *Bid) int {
+	for i := range bids {
+		if &bids[i] == bid {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestSettlementRules(t *testing.T) {
+	var tests = []struct {
+		rule    string
+		values  []string
+		reserve string
+		winner  int // position of the winning bid, -1 for none
+		price   string
+	}{
+		{SettlementFirstPrice, []string{}, "0.00", -1, "0.00"},
+		{SettlementFirstPrice, []string{"10.00", "30.00", "20.00"}, "0.00", 1, "30.00"},
+		{SettlementFirstPrice, []string{"10.00", "30.00"}, "30.00", 1, "30.00"},
+		{SettlementFirstPrice, []string{"10.00", "30.00"}, "30.01", -1, "0.00"},
+		{SettlementFirstPrice, []string{"30.00", "10.00", "30.00"}, "0.00", 0, "30.00"},
+
+		{SettlementSecondPrice, []string{}, "0.00", -1, "0.00"},
+		{SettlementSecondPrice, []string{"10.00", "30.00", "20.00"}, "0.00", 1, "20.00"},
+		// A single bid, or a second bid under the reserve price, pays the reserve price
+		{SettlementSecondPrice, []string{"30.00"}, "5.00", 0, "5.00"},
+		{SettlementSecondPrice, []string{"30.00"}, "0.00", 0, "0.00"},
+		{SettlementSecondPrice, []string{"10.00", "30.00"}, "15.00", 1, "15.00"},
+		{SettlementSecondPrice, []string{"10.00", "30.00"}, "30.01", -1, "0.00"},
+		// Equal highest values: the earliest wins and pays the same value
+		{SettlementSecondPrice, []string{"10.00", "30.00", "30.00"}, "0.00", 1, "30.00"},
+	}
+	for _, test := range tests {
+		var bids Bids = settlementTestBids(test.values...)
+		var reserve Money = MustParseMoney(test.reserve)
+		var settlement Settlement = settlementRules[test.rule].Settle(bids, reserve)
+		if position(bids, settlement.WinningBid) != test.winner || settlement.ClearingPrice != MustParseMoney(test.price) ||
+			settlement.BidCount != len(bids) || settlement.ReservePrice != reserve {
+			t.Errorf("%s %v reserve %s: got %+v, expected bid %d at %s", test.rule, test.values, test.reserve,
+				settlement, test.winner, test.price)
+		}
+	}
+}
+
+func TestRevealedReservePrice(t *testing.T) {
+	const salt = "0123456789abcdef"
+	var seller ed25519.PrivateKey = newTestKey()
+	var sellerKey string = hex.EncodeToString(seller.Public().(ed25519.PublicKey))
+	var public AuctionRecord = testAuction(seller, 1, func(record *AuctionRecord) {
+		record.Currency, record.ReservePrice = "EUR", MustParseMoney("10.00 EUR")
+	})
+	var hidden AuctionRecord = testAuction(seller, 2, func(record *AuctionRecord) {
+		record.Currency = "EUR"
+		record.ReserveCommitment = ComputeCommitment(2, sellerKey, MustParseMoney("25.00 EUR"), salt)
+	})
+	var noReserve AuctionRecord = testAuction(seller, 3, func(record *AuctionRecord) { record.Currency = "EUR" })
+	var negative AuctionRecord = testAuction(seller, 4, func(record *AuctionRecord) {
+		record.ReserveCommitment = ComputeCommitment(4, sellerKey, MustParseMoney("-1.00"), salt)
+	})
+	var closing = func(reserve string, salt string) AuctionRecord {
+		var record AuctionRecord = AuctionRecord{Type: AuctionRecordClose, ReserveSalt: salt}
+		if reserve != "" {
+			record.ReservePrice = MustParseMoney(reserve)
+		}
+		return record
+	}
+
+	var tests = []struct {
+		name    string
+		auction AuctionRecord
+		close   AuctionRecord
+		reserve Money
+		reason  string
+	}{
+		{"public", public, closing("", ""), MustParseMoney("10.00 EUR"), ""},
+		{"public revealed", public, closing("10.00 EUR", salt), Money{}, "is public"},
+		{"none", noReserve, closing("", ""), Money{Currency: "EUR"}, ""},
+		{"hidden", hidden, closing("25.00 EUR", salt), MustParseMoney("25.00 EUR"), ""},
+		{"hidden not revealed", hidden, closing("", ""), Money{}, "must reveal the hidden reserve price"},
+		{"hidden with another value", hidden, closing("5.00 EUR", salt), Money{}, "do not match the reserve commitment"},
+		{"hidden with another salt", hidden, closing("25.00 EUR", "fedcba9876543210"), Money{}, "do not match the reserve commitment"},
+		{"hidden in another currency", hidden, closing("25.00 USD", salt), Money{}, "do not match the reserve commitment"},
+		{"hidden with a short salt", hidden, closing("25.00 EUR", "short"), Money{}, "salt of at least"},
+		{"hidden negative", negative, closing("-1.00", salt), Money{}, "must not be negative"},
+	}
+	for _, test := range tests {
+		reserve, reason := revealedReservePrice(test.auction, test.close)
+		expectReason(t, test.name, reason, test.reason)
+		if reserve != test.reserve {
+			t.Errorf("%s: got reserve price %+v, expected %+v", test.name, reserve, test.reserve)
+		}
+	}
+}
+
+// A second-price auction with a hidden reserve price, settled on the ledger
+func TestSettleOnLedger(t *testing.T) {
+	const salt = "0123456789abcdef"
+	var seller, alice, bob, carol ed25519.PrivateKey = newTestKey(), newTestKey(), newTestKey(), newTestKey()
+	var sellerKey string = hex.EncodeToString(seller.Public().(ed25519.PublicKey))
+	var tests = []struct {
+		name    string
+		reserve string
+		winner  ed25519.PrivateKey
+		price   string
+	}{
+		{"reserve under the second bid", "15.00", bob, "20.00"},
+		{"reserve between the bids", "25.00", bob, "25.00"},
+		{"reserve above the bids", "40.00", nil, "0.00"},
+	}
+	for _, test := range tests {
+		var state *ledger = newLedger()
+		expectReason(t, test.name+": create", state.applyCreate(testAuction(seller, 1, func(record *AuctionRecord) {
+			record.Rule = SettlementSecondPrice
+			record.ReserveCommitment = ComputeCommitment(1, sellerKey, MustParseMoney(test.reserve), salt)
+		}), testBlock(2, auctionOpen)), "")
+		expectReason(t, test.name+": bid", state.applyBid(testBid(alice, 1, "20.00", 1), auctionOpen), "")
+		expectReason(t, test.name+": bid", state.applyBid(testBid(bob, 1, "30.00", 1), auctionOpen), "")
+		expectReason(t, test.name+": bid", state.applyBid(testBid(carol, 1, "20.00", 1), auctionOpen), "")
+
+		var close AuctionRecord = AuctionRecord{Type: AuctionRecordClose, AuctionId: 1,
+			ReservePrice: MustParseMoney(test.reserve), ReserveSalt: salt}
+		SignAuctionRecord(&close, seller)
+		expectReason(t, test.name+": close", state.applyClose(close, testBlock(3, auctionClose)), "")
+
+		var settlement *Settlement = state.auctions[1].Settlement
+		if settlement == nil || settlement.Rule != SettlementSecondPrice || settlement.ClosedInBlock != 3 ||
+			settlement.BidCount != 3 || settlement.ReservePrice != MustParseMoney(test.reserve) ||
+			settlement.ClearingPrice != MustParseMoney(test.price) {
+			t.Fatalf("%s: got settlement %+v", test.name, settlement)
+		}
+		if test.winner == nil {
+			if settlement.WinningBid != nil || state.auctions[1].WinningBid != nil {
+				t.Errorf("%s: got winning bid %+v, expected none", test.name, settlement.WinningBid)
+			}
+			continue
+		}
+		var winnerKey string = hex.EncodeToString(test.winner.Public().(ed25519.PublicKey))
+		if settlement.WinningBid == nil || settlement.WinningBid.PublicKey != winnerKey || state.auctions[1].WinningBid != settlement.WinningBid {
+			t.Errorf("%s: got winning bid %+v", test.name, settlement.WinningBid)
+		}
+	}
+}
+
+func TestCheckSettlementRule(t *testing.T) {
+	for rule, valid := range map[string]bool{"": true, SettlementFirstPrice: true, SettlementSecondPrice: true, "dutch": false} {
+		if reason := checkSettlementRule(AuctionRecord{Rule: rule}); (reason == "") != valid {
+			t.Errorf("rule %q: got %q, expected valid %v", rule, reason, valid)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/* Signed bids. Each bid carries the ed25519 public key of its bidder, a sequence number and a signature
+of the bid made with the bidder's private key. The signature covers the canonical encoding of every
+other field of the bid (see signingBytes), so a bid cannot be altered or posted in someone else's name.
+BidderName is only a display label; the bidder is identified by the public key.
+
+Sequence numbers prevent replays: each bid of a bidder must have a higher sequence number than all of
+that bidder's bids that are already in the chain (and, within a block, than the bidder's earlier bids
+in the block). Re-sending a signed bid that was already recorded is therefore rejected. Public keys and
+signatures are written as lowercase hex strings */
+package bid
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// SignBid fills in the public key and signature of a bid with the given private key. Any other field
+// (including the sequence number) must be set before signing
+func SignBid(bid *Bid, privateKey ed25519.PrivateKey) {
+	bid.PublicKey = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+	bid.Signature = hex.EncodeToString(ed25519.Sign(privateKey, bid.signingBytes()))
+}
+
+// checkBidSignature returns the reason why a bid is not properly signed, or "" if it is
+func checkBidSignature(bid Bid) string {
+	return checkSignature(bid.PublicKey, bid.Signature, bid.signingBytes())
+}
+
+// checkSignature returns the reason why signature (hex) is not a signature of message by the owner
+// of publicKey (hex), or "" if it is
+func checkSignature(publicKeyHex string, signatureHex string, message []byte) string {
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if publicKeyHex == "" {
+		return "not signed: public key is missing"
+	}
+	if err != nil || len(publicKey) != ed25519.PublicKeySize || hex.EncodeToString(publicKey) != publicKeyHex {
+		return fmt.Sprintf("public key must be %d bytes written as lowercase hex", ed25519.PublicKeySize)
+	}
+
+	signature, err := hex.DecodeString(signatureHex)
+	if signatureHex == "" {
+		return "not signed: signature is missing"
+	}
+	if err != nil || len(signature) != ed25519.SignatureSize || hex.EncodeToString(signature) != signatureHex {
+		return fmt.Sprintf("signature must be %d bytes written as lowercase hex", ed25519.SignatureSize)
+	}
+
+	if !ed25519.Verify(publicKey, message, signature) {
+		return "signature does not match the signed data and public key"
+	}
+	return ""
+}
+
+// sortBySequence orders bids so that the bids of each bidder are in sequence order, as required in a
+// block. The sort is stable, so bids with the same sequence number keep their arrival order
+func sortBySequence(bids Bids) {
+	sort.SliceStable(bids, func(i, j int) bool {
+		return bids[i].Sequence < bids[j].Sequence
+	})
+}
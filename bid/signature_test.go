@@ -0,0 +1,98 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectReason fails the test unless reason contains expected, or is empty when expected is
+func expectReason(t *testing.T, what string, reason string, expected string) {
+	t.Helper()
+	if (expected == "") != (reason == "") || !strings.Contains(reason, expected) {
+		t.Errorf("%s: got %q, expected %q", what, reason, expected)
+	}
+}
+
+func TestCheckBidSignature(t *testing.T) {
+	var bidder, other ed25519.PrivateKey = newTestKey(), newTestKey()
+	var bid Bid = testBid(bidder, 1, "10.00", 1)
+	var tests = []struct {
+		name   string
+		change func(bid *Bid)
+		reason string
+	}{
+		{"signed", func(bid *Bid) {}, ""},
+		{"other value", func(bid *Bid) { bid.BidValue = MustParseMoney("10.01") }, "signature does not match"},
+		{"other sequence", func(bid *Bid) { bid.Sequence++ }, "signature does not match"},
+		{"other chain", func(bid *Bid) { bid.ChainId = "other" }, "signature does not match"},
+		{"other name", func(bid *Bid) { bid.BidderName = "someone" }, "signature does not match"},
+		{"someone else's key", func(bid *Bid) { bid.PublicKey = testBid(other, 1, "10.00", 1).PublicKey }, "signature does not match"},
+		{"no public key", func(bid *Bid) { bid.PublicKey = "" }, "public key is missing"},
+		{"upper case key", func(bid *Bid) { bid.PublicKey = strings.ToUpper(bid.PublicKey) }, "lowercase hex"},
+		{"short key", func(bid *Bid) { bid.PublicKey = bid.PublicKey[:62] }, "lowercase hex"},
+		{"no signature", func(bid *Bid) { bid.Signature = "" }, "signature is missing"},
+		{"short signature", func(bid *Bid) { bid.Signature = bid.Signature[:126] }, "lowercase hex"},
+	}
+	for _, test := range tests {
+		var changed Bid = bid
+		test.change(&changed)
+		expectReason(t, test.name, checkBidSignature(changed), test.reason)
+	}
+}
+
+// A signed bid cannot be recorded twice, and each bid of a bidder needs a higher sequence number than
+// the bidder's bids in the chain
+func TestBidReplay(t *testing.T) {
+	var b *BlockChain = newTestChain(t)
+	var seller, bidder ed25519.PrivateKey = newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	if err := b.RegisterAuctionRecord(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	var first Bid = testBid(bidder, 1, "10.00", 2)
+	if err := b.RegisterBid(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mineBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name string
+		bid  Bid
+		err  error
+	}{
+		{"replay", first, ErrInvalidBid},
+		{"same sequence", testBid(bidder, 1, "20.00", 2), ErrInvalidBid},
+		{"lower sequence", testBid(bidder, 1, "20.00", 1), ErrInvalidBid},
+		{"higher sequence", testBid(bidder, 1, "20.00", 5), nil},
+		{"same pending sequence", testBid(bidder, 1, "30.00", 5), ErrInvalidBid},
+		{"lower than a pending sequence", testBid(bidder, 1, "30.00", 4), nil},
+	}
+	for _, test := range tests {
+		if err := b.RegisterBid(test.bid); !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, expected %v", test.name, err, test.err)
+		}
+	}
+
+	// The pending bids are mined in sequence order, whatever their arrival order
+	block, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Bids) != 2 || block.Bids[0].Sequence != 4 || block.Bids[1].Sequence != 5 {
+		t.Fatalf("got bids %+v, expected sequences 4 and 5", block.Bids)
+	}
+
+	// A block that replays a bid is refused
+	var state *ledger = rebuildLedger(b.Chain)
+	expectReason(t, "replay in a block", state.applyBid(first, now), "is not higher than last sequence 5")
+}
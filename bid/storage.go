@@ -0,0 +1,591 @@
+/* Durable storage for the state of a node: the chain, the pending bids and auction records, and the
+list of known nodes.
+The BlockChain writes every change to its Storage before applying it in memory, so that a node that
+crashes or restarts can reload its state instead of starting from a fresh genesis block.
+
+Two implementations are provided:
+1. FileStorage keeps everything in a data directory (one directory per node):
+	chain.log	append-only log of blocks, one record per block, fsync'd after each append
+	pending.wal	write-ahead log of pending bids: each new bid is appended (and fsync'd) before it is
+				accepted. When a block takes the pending bids, the log is rewritten with what is left
+	pending-auctions.wal	write-ahead log of pending auction records, handled like pending.wal
+	peers.json	records of known nodes with their health, rewritten as a whole each time it changes
+	webhooks.json	webhook subscriptions, outbox and delivery log, rewritten as a whole each time they change
+2. MemoryStorage keeps everything in memory. Used for tests and for throw-away nodes */
+package bid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Storage persists the state of a blockchain. All methods must be durable when they return
+type Storage interface {
+	// LoadChain returns all stored blocks in chain order (empty if nothing is stored yet)
+	LoadChain() (Blocks, error)
+	// AppendBlock adds a block at the end of the stored chain
+	AppendBlock(block Block) error
+	// ReplaceChain replaces the whole stored chain (i.e., after consensus)
+	ReplaceChain(chain Blocks) error
+
+	// LoadPendingBids returns the stored pending bids in arrival order
+	LoadPendingBids() (Bids, error)
+	// AppendPendingBid adds a new pending bid
+	AppendPendingBid(bid Bid) error
+	// ResetPendingBids replaces all stored pending bids with the given bids
+	ResetPendingBids(bids Bids) error
+
+	// LoadPendingAuctions returns the stored pending auction records in arrival order
+	LoadPendingAuctions() (AuctionRecords, error)
+	// AppendPendingAuction adds a new pending auction record
+	AppendPendingAuction(record AuctionRecord) error
+	// ResetPendingAuctions replaces all stored pending auction records with the given records
+	ResetPendingAuctions(records AuctionRecords) error
+
+	// LoadPeers returns the stored records of known nodes
+	LoadPeers() ([]PeerRecord, error)
+	// SavePeers replaces the stored records of known nodes
+	SavePeers(peers []PeerRecord) error
+
+	// LoadWebhooks returns the stored webhook subscriptions, outbox and delivery log (see webhooks.go)
+	LoadWebhooks() (WebhookState, error)
+	// SaveWebhooks replaces the stored webhook subscriptions, outbox and delivery log
+	SaveWebhooks(state WebhookState) error
+
+	// Close releases any resources (i.e., open files) held by the storage
+	Close() error
+}
+
+/* MemoryStorage */
+
+// MemoryStorage is a Storage that keeps everything in memory. Nothing survives a restart
+type MemoryStorage struct {
+	mutex           sync.Mutex
+	chain           Blocks
+	pendingBids     Bids
+	pendingAuctions AuctionRecords
+	peers           []PeerRecord
+	webhooks        []byte
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{chain: Blocks{}, pendingBids: Bids{}, pendingAuctions: AuctionRecords{}, peers: []PeerRecord{}}
+}
+
+func (m *MemoryStorage) LoadChain() (Blocks, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append(Blocks{}, m.chain...), nil
+}
+
+func (m *MemoryStorage) AppendBlock(block Block) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.chain = append(m.chain, block)
+	return nil
+}
+
+func (m *MemoryStorage) ReplaceChain(chain Blocks) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.chain = append(Blocks{}, chain...)
+	return nil
+}
+
+func (m *MemoryStorage) LoadPendingBids() (Bids, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append(Bids{}, m.pendingBids...), nil
+}
+
+func (m *MemoryStorage) AppendPendingBid(bid Bid) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pendingBids = append(m.pendingBids, bid)
+	return nil
+}
+
+func (m *MemoryStorage) ResetPendingBids(bids Bids) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pendingBids = append(Bids{}, bids...)
+	return nil
+}
+
+func (m *MemoryStorage) LoadPendingAuctions() (AuctionRecords, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append(AuctionRecords{}, m.pendingAuctions...), nil
+}
+
+func (m *MemoryStorage) AppendPendingAuction(record AuctionRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pendingAuctions = append(m.pendingAuctions, record)
+	return nil
+}
+
+func (m *MemoryStorage) ResetPendingAuctions(records AuctionRecords) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pendingAuctions = append(AuctionRecords{}, records...)
+	return nil
+}
+
+func (m *MemoryStorage) LoadPeers() ([]PeerRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]PeerRecord{}, m.peers...), nil
+}
+
+func (m *MemoryStorage) SavePeers(peers []PeerRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.peers = append([]PeerRecord{}, peers...)
+	return nil
+}
+
+// LoadWebhooks returns a copy of the stored webhook state (it is kept encoded, so that the caller
+// cannot change the stored copy)
+func (m *MemoryStorage) LoadWebhooks() (WebhookState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return decodeWebhookState(m.webhooks)
+}
+
+func (m *MemoryStorage) SaveWebhooks(state WebhookState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	m.webhooks = data
+	return nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+/* FileStorage */
+
+// Names of the files kept in the data directory of a FileStorage
+const (
+	chainFileName           = "chain.log"
+	pendingBidsFileName     = "pending.wal"
+	pendingAuctionsFileName = "pending-auctions.wal"
+	peersFileName           = "peers.json"
+	webhooksFileName        = "webhooks.json"
+)
+
+// FileStorage is a Storage backed by files in a data directory. Blocks, pending bids and pending auction
+// records are stored as records, one per line. Each record is the CRC32 checksum of its JSON data followed
+// by the JSON data:
+//	3a1f09c2 {"index":2,"timestamp":1627171722582903400,...}
+// A record that was only partially written when the node crashed fails its checksum (or has no
+// ending new line) and is dropped when the file is loaded, along with anything after it
+type FileStorage struct {
+	mutex               sync.Mutex
+	directory           string
+	chainFile           *os.File
+	pendingFile         *os.File
+	pendingAuctionsFile *os.File
+}
+
+// NewFileStorage opens (and creates if needed) a file storage in the given directory
+func NewFileStorage(directory string) (*FileStorage, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", directory, err)
+	}
+
+	var storage *FileStorage = &FileStorage{directory: directory}
+	if storage.chainFile, err = openAppendOnly(storage.path(chainFileName)); err != nil {
+		return nil, err
+	}
+	if storage.pendingFile, err = openAppendOnly(storage.path(pendingBidsFileName)); err != nil {
+		storage.chainFile.Close()
+		return nil, err
+	}
+	if storage.pendingAuctionsFile, err = openAppendOnly(storage.path(pendingAuctionsFileName)); err != nil {
+		storage.chainFile.Close()
+		storage.pendingFile.Close()
+		return nil, err
+	}
+	return storage, nil
+}
+
+func (f *FileStorage) LoadChain() (Blocks, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var chain Blocks = Blocks{}
+	err := readRecords(f.chainFile, func(data []byte) error {
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return err
+		}
+		chain = append(chain, block)
+		return nil
+	})
+	return chain, err
+}
+
+func (f *FileStorage) AppendBlock(block Block) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return appendRecord(f.chainFile, block)
+}
+
+func (f *FileStorage) ReplaceChain(chain Blocks) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var records []interface{} = make([]interface{}, len(chain))
+	for i, block := range chain {
+		records[i] = block
+	}
+	file, err := f.rewriteRecords(f.chainFile, chainFileName, records)
+	if err != nil {
+		return err
+	}
+	f.chainFile = file
+	return nil
+}
+
+func (f *FileStorage) LoadPendingBids() (Bids, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var bids Bids = Bids{}
+	err := readRecords(f.pendingFile, func(data []byte) error {
+		var bid Bid
+		if err := json.Unmarshal(data, &bid); err != nil {
+			return err
+		}
+		bids = append(bids, bid)
+		return nil
+	})
+	return bids, err
+}
+
+func (f *FileStorage) AppendPendingBid(bid Bid) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return appendRecord(f.pendingFile, bid)
+}
+
+func (f *FileStorage) ResetPendingBids(bids Bids) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var records []interface{} = make([]interface{}, len(bids))
+	for i, bid := range bids {
+		records[i] = bid
+	}
+	file, err := f.rewriteRecords(f.pendingFile, pendingBidsFileName, records)
+	if err != nil {
+		return err
+	}
+	f.pendingFile = file
+	return nil
+}
+
+func (f *FileStorage) LoadPendingAuctions() (AuctionRecords, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var records AuctionRecords = AuctionRecords{}
+	err := readRecords(f.pendingAuctionsFile, func(data []byte) error {
+		var record AuctionRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func (f *FileStorage) AppendPendingAuction(record AuctionRecord) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return appendRecord(f.pendingAuctionsFile, record)
+}
+
+func (f *FileStorage) ResetPendingAuctions(records AuctionRecords) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var values []interface{} = make([]interface{}, len(records))
+	for i, record := range records {
+		values[i] = record
+	}
+	file, err := f.rewriteRecords(f.pendingAuctionsFile, pendingAuctionsFileName, values)
+	if err != nil {
+		return err
+	}
+	f.pendingAuctionsFile = file
+	return nil
+}
+
+// LoadPeers reads peers.json. Files written before peers had records hold a list of urls: these peers
+// are loaded with an unknown status
+func (f *FileStorage) LoadPeers() ([]PeerRecord, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var peers []PeerRecord = []PeerRecord{}
+	data, err := ioutil.ReadFile(f.path(peersFileName))
+	if os.IsNotExist(err) {
+		return peers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &peers); err == nil {
+		return peers, nil
+	}
+	var urls []string
+	if json.Unmarshal(data, &urls) != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", peersFileName, err)
+	}
+	peers = []PeerRecord{}
+	for _, url := range urls {
+		peers = append(peers, PeerRecord{Url: url, AddedAt: time.Now(), Status: PeerUnknown})
+	}
+	return peers, nil
+}
+
+func (f *FileStorage) SavePeers(peers []PeerRecord) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(f.path(peersFileName), data)
+}
+
+// LoadWebhooks reads webhooks.json
+func (f *FileStorage) LoadWebhooks() (WebhookState, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(f.path(webhooksFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return WebhookState{}, err
+	}
+	state, err := decodeWebhookState(data)
+	if err != nil {
+		return state, fmt.Errorf("failed to read %s: %w", webhooksFileName, err)
+	}
+	return state, nil
+}
+
+func (f *FileStorage) SaveWebhooks(state WebhookState) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(f.path(webhooksFileName), data)
+}
+
+func (f *FileStorage) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var chainErr error = f.chainFile.Close()
+	var pendingErr error = f.pendingFile.Close()
+	var pendingAuctionsErr error = f.pendingAuctionsFile.Close()
+	if chainErr != nil {
+		return chainErr
+	}
+	if pendingErr != nil {
+		return pendingErr
+	}
+	return pendingAuctionsErr
+}
+
+func (f *FileStorage) path(fileName string) string {
+	return filepath.Join(f.directory, fileName)
+}
+
+// rewriteRecords replaces the content of a record file with the given records. The new content is
+// written to a temporary file which then replaces the old file, so a crash leaves either the old or
+// the new content. Returns the reopened file, ready for appends
+func (f *FileStorage) rewriteRecords(file *os.File, fileName string, records []interface{}) (*os.File, error) {
+	var buffer bytes.Buffer
+	for _, record := range records {
+		line, err := encodeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(line)
+	}
+	if err := writeFileAtomically(f.path(fileName), buffer.Bytes()); err != nil {
+		return nil, err
+	}
+
+	file.Close()
+	return openAppendOnly(f.path(fileName))
+}
+
+/* Helpers */
+
+// openAppendOnly opens a record file for reading and appending, creating it if needed
+func openAppendOnly(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// encodeRecord converts a value into a record line: checksum, space, JSON data, new line
+func encodeRecord(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var checksum string = fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+	return []byte(checksum + " " + string(data) + "\n"), nil
+}
+
+// appendRecord appends a record to the end of a record file and waits until it is on disk
+func appendRecord(file *os.File, value interface{}) error {
+	line, err := encodeRecord(value)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(line); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", file.Name(), err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+// readRecords reads all records of a record file from the start, calling process with the JSON data
+// of each record. Reading stops at the first damaged record (bad checksum or no ending new line),
+// which can only be the result of a crash while writing. The file is truncated at that point so
+// that new records are appended after the last good one
+func readRecords(file *os.File, process func(data []byte) error) error {
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	var reader *bufio.Reader = bufio.NewReader(file)
+	var goodLength int64 = 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 || err != nil {
+			break
+		}
+
+		data, ok := decodeRecord(line)
+		if !ok {
+			break
+		}
+		if err = process(data); err != nil {
+			return fmt.Errorf("failed to read record at offset %d of %s: %w", goodLength, file.Name(), err)
+		}
+		goodLength += int64(len(line))
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > goodLength {
+		log.Printf("Dropping %d bytes of damaged records at the end of %s", info.Size()-goodLength, file.Name())
+		if err = file.Truncate(goodLength); err != nil {
+			return err
+		}
+		return file.Sync()
+	}
+	return nil
+}
+
+// decodeRecord checks the checksum of a record line and returns its JSON data
+func decodeRecord(line []byte) ([]byte, bool) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	var separator int = bytes.IndexByte(line, ' ')
+	if separator < 0 {
+		return nil, false
+	}
+	checksum, err := strconv.ParseUint(string(line[:separator]), 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	var data []byte = line[separator+1:]
+	return data, uint32(checksum) == crc32.ChecksumIEEE(data)
+}
+
+// decodeWebhookState decodes a stored webhook state. Nothing stored gives an empty state
+func decodeWebhookState(data []byte) (WebhookState, error) {
+	var state WebhookState
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return WebhookState{}, err
+		}
+	}
+	if state.Subscriptions == nil {
+		state.Subscriptions = []WebhookSubscription{}
+	}
+	if state.Outbox == nil {
+		state.Outbox = []WebhookDelivery{}
+	}
+	if state.Log == nil {
+		state.Log = []WebhookLogEntry{}
+	}
+	return state, nil
+}
+
+// writeFileAtomically writes data to a temporary file, syncs it, and renames it over path
+func writeFileAtomically(path string, data []byte) error {
+	var temporaryPath string = path + ".tmp"
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(temporaryPath, path); err != nil {
+		return err
+	}
+
+	// Sync the directory so that the rename itself is on disk
+	directory, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
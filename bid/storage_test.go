@@ -0,0 +1,29 @@
+package bid
+
+import (
+	"strings"
+	"testing"
+)
+
+// A stored chain that is not valid is refused when the node starts
+func TestStoredChainIsValidated(t *testing.T) {
+	var store *MemoryStorage = NewMemoryStorage()
+	b, err := NewBlockChain(store, TestGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := mineBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewBlockChain(store, TestGenesisConfig()); err != nil {
+		t.Fatalf("valid stored chain refused: %s", err)
+	}
+	block.Nonce++
+	if err = store.AppendBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewBlockChain(store, TestGenesisConfig()); err == nil || !strings.Contains(err.Error(), "stored chain is not valid at block 2") {
+		t.Fatalf("got error %v", err)
+	}
+}
@@ -0,0 +1,611 @@
+/* Chain sync. Consensus used to download the whole chain of every peer (GET /blockchain) and validate it
+from the genesis block, which gets too slow once chains have thousands of blocks. A node now syncs
+headers first:
+	1. it asks each peer for the headers of its main chain from maxForkDepth blocks below our tip
+	   (GET /sync/headers), finds the last block both chains have in common, and checks the headers after
+	   it: linkage, chain id, timestamps, required difficulty and proof of work. Headers are small, so
+	   this is cheap even when a peer is far ahead. If the chains fork deeper, the headers are fetched
+	   again from the genesis block
+	2. the peer whose headers give the most work is the sync target, if that beats our chain
+	3. the bodies of the target's blocks (GET /sync/blocks?hash=...) are downloaded in windows of
+	   syncWindowSize blocks, in batches spread over all peers that advertised them, by syncDownloaders
+	   workers. A body is only accepted if it matches its header (hash and Merkle roots)
+	4. the blocks of each window are added in chain order like blocks received by gossip (see AddBlock),
+	   which validates them fully and reorganizes once their branch has the most work
+	5. if the target's blocks became our chain, the target's pending bids are added to ours, checked
+	   like received bids (GET /mempool/bids, see TakeOverPendingBids)
+Headers are only fetched up to maxSyncDistance blocks above our tip, and a page of headers that adds
+nothing ends the fetch, so a peer cannot make a node fetch headers forever. A peer further ahead is
+synced in several rounds, as long as each round adds blocks.
+Blocks that extend the main chain are stored as they are added, so a sync that is interrupted (a peer
+went away, the node restarted) resumes from there on the next round. Bodies that were downloaded but not
+added yet are kept in memory, so that a retry does not download them again. GET /sync shows the
+progress of the last sync */
+package bid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Settings of the sync protocol
+const (
+	maxHeadersPerRequest = 2000
+	maxBlocksPerRequest  = 100
+	syncWindowSize       = 500
+	syncBatchSize        = 50
+	syncDownloaders      = 4
+	syncMaxAttempts      = 3
+	syncRequestTimeout   = 30 * time.Second
+	maxSyncCachedBlocks  = 2 * syncWindowSize
+	maxSyncDistance      = 10000
+)
+
+// ErrSyncInProgress is returned when a sync is requested while another one is running
+var ErrSyncInProgress = errors.New("a sync is already running")
+
+/* Serving */
+
+// HeaderRecord returns the header of a block with its hash, as sent by GET /sync/headers
+func (block Block) HeaderRecord() HeaderRecord {
+	return HeaderRecord{
+		Version:           block.Version,
+		ChainId:           block.ChainId,
+		Index:             block.Index,
+		Timestamp:         block.Timestamp,
+		PreviousBlockHash: block.PreviousBlockHash,
+		MerkleRoot:        block.MerkleRoot,
+		AuctionRoot:       block.AuctionRoot,
+		Difficulty:        block.Difficulty,
+		Nonce:             block.Nonce,
+		Hash:              block.Hash,
+	}
+}
+
+// block returns a block with the fields of a header and no bids or auction records, so that headers
+// are checked with the same rules as blocks
+func (header HeaderRecord) block() Block {
+	return Block{
+		Version:           header.Version,
+		ChainId:           header.ChainId,
+		Index:             header.Index,
+		Timestamp:         header.Timestamp,
+		Nonce:             header.Nonce,
+		Difficulty:        header.Difficulty,
+		MerkleRoot:        header.MerkleRoot,
+		AuctionRoot:       header.AuctionRoot,
+		Hash:              header.Hash,
+		PreviousBlockHash: header.PreviousBlockHash,
+	}
+}
+
+// GetHeaders gets the headers of up to count blocks of the main chain, starting with the block at
+// index from (none if the chain is shorter), with the tip of the main chain
+func (b *BlockChain) GetHeaders(from int, count int) HeadersResponse {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var tip Block = b.Chain[len(b.Chain)-1]
+	var response HeadersResponse = HeadersResponse{
+		ChainId:  b.genesis.ChainId,
+		TipIndex: tip.Index,
+		TipHash:  tip.Hash,
+		Headers:  []HeaderRecord{},
+	}
+	for index := from; index <= len(b.Chain) && index < from+count; index++ {
+		response.Headers = append(response.Headers, b.Chain[index-1].HeaderRecord())
+	}
+	return response
+}
+
+// GetBlocksByHash gets the blocks of any known branch with the given hashes, in the same order.
+// Unknown hashes are skipped
+func (b *BlockChain) GetBlocksByHash(hashes []string) Blocks {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var blocks Blocks = Blocks{}
+	for _, hash := range hashes {
+		if node, exists := b.tree.nodes[hash]; exists {
+			blocks = append(blocks, node.block)
+		}
+	}
+	return blocks
+}
+
+// getMainChain returns the main chain. Blocks are never modified in place, so the result stays valid
+// while blocks are added; it must not be appended to
+func (b *BlockChain) getMainChain() Blocks {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.Chain[:len(b.Chain):len(b.Chain)]
+}
+
+/* Syncing */
+
+// Syncer syncs the chain of a node with the chains of its peers (see the doc comment of this file)
+type Syncer struct {
+	blockChain *BlockChain
+	selfUrl    string
+	client     *http.Client
+
+	// mutex guards running, status and cache
+	mutex   sync.Mutex
+	running bool
+	status  SyncStatus
+	cache   map[string]Block
+}
+
+// syncTarget is the branch of a peer that has more work than our chain: the headers after the last
+// block both chains have in common, and the work of the whole branch
+type syncTarget struct {
+	peer    string
+	work    *big.Int
+	headers []HeaderRecord
+	// partial is true if the peer's chain goes beyond the headers fetched (see maxSyncDistance)
+	partial bool
+}
+
+// NewSyncer creates the syncer of the node with the given url
+func NewSyncer(blockChain *BlockChain, selfUrl string) *Syncer {
+	return &Syncer{
+		blockChain: blockChain,
+		selfUrl:    selfUrl,
+		client:     &http.Client{Timeout: syncRequestTimeout},
+		cache:      map[string]Block{},
+	}
+}
+
+// GetStatus gets the progress of the running sync, or the outcome of the last one
+func (s *Syncer) GetStatus() SyncStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var status SyncStatus = s.status
+	status.Running = s.running
+	status.CachedBlocks = len(s.cache)
+	return status
+}
+
+// Sync syncs our chain with the peer (among the given ones) whose valid chain has the most work, if it
+// has more work than ours. Returns the final status of the sync; the error says why the sync stopped
+// early, if it did. ErrSyncInProgress is returned if a sync is already running
+func (s *Syncer) Sync(peers []string) (SyncStatus, error) {
+	s.mutex.Lock()
+	if s.running {
+		s.mutex.Unlock()
+		return s.GetStatus(), ErrSyncInProgress
+	}
+	s.running = true
+	var startedAt time.Time = time.Now()
+	s.status = SyncStatus{StartedAt: &startedAt}
+	if len(s.cache) > maxSyncCachedBlocks {
+		s.cache = map[string]Block{}
+	}
+	s.mutex.Unlock()
+
+	err := s.sync(peers)
+
+	s.mutex.Lock()
+	s.running = false
+	var finishedAt time.Time = time.Now()
+	s.status.FinishedAt = &finishedAt
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+	s.mutex.Unlock()
+	return s.GetStatus(), err
+}
+
+func (s *Syncer) sync(peers []string) error {
+	for {
+		target, sources := s.findTarget(peers)
+		if target == nil {
+			return nil // no peer has more work than us
+		}
+		var added int = s.GetStatus().BlocksAdded
+		if err := s.syncWith(target, sources); err != nil {
+			return err
+		}
+		// Another round only if the peer is still ahead and this round made progress
+		if !target.partial || s.GetStatus().BlocksAdded == added {
+			return nil
+		}
+	}
+}
+
+// syncWith downloads and adds the blocks of a sync target, then takes over the target's pending bids
+// if its blocks became our chain
+func (s *Syncer) syncWith(target *syncTarget, sources map[string]map[string]bool) error {
+	var tip HeaderRecord = target.headers[len(target.headers)-1]
+	s.updateStatus(func(status *SyncStatus) {
+		status.Peer = target.peer
+		status.TargetIndex = tip.Index
+		status.TargetHash = tip.Hash
+		status.ForkIndex = target.headers[0].Index - 1
+		status.Headers = len(target.headers)
+	})
+	log.Printf("Syncing %d blocks (%d to %d) from node %s", len(target.headers), target.headers[0].Index, tip.Index, target.peer)
+
+	for start := 0; start < len(target.headers); start += syncWindowSize {
+		var end int = start + syncWindowSize
+		if end > len(target.headers) {
+			end = len(target.headers)
+		}
+		if err := s.downloadWindow(target.headers[start:end], sources); err != nil {
+			return err
+		}
+		if err := s.addWindow(target.headers[start:end]); err != nil {
+			return err
+		}
+	}
+	log.Printf("Synced with node %s: chain height %d", target.peer, s.blockChain.GetLastBlock().Index)
+	if s.GetStatus().Replaced {
+		s.takeOverPendingBids(target.peer)
+	}
+	return nil
+}
+
+// takeOverPendingBids adds the pending bids of a peer to ours (see TakeOverPendingBids). A peer that
+// cannot be reached only means fewer pending bids, so failures are logged
+func (s *Syncer) takeOverPendingBids(peer string) {
+	var records []PendingBidRecord
+	if err := s.getJson(peer+"/mempool/bids", &records); err != nil {
+		log.Printf("Failed to get the pending bids of node %s: %s", peer, err)
+		return
+	}
+	var bids Bids = make(Bids, 0, len(records))
+	for _, record := range records {
+		bids = append(bids, record.Bid)
+	}
+	var added int = s.blockChain.TakeOverPendingBids(bids)
+	s.updateStatus(func(status *SyncStatus) {
+		status.PendingBidsAdded += added
+	})
+}
+
+// updateStatus changes the status of the running sync
+func (s *Syncer) updateStatus(update func(status *SyncStatus)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	update(&s.status)
+}
+
+// findTarget fetches and checks the headers of all peers, concurrently, and returns the branch with
+// the most work if it has more work than our chain, with the hashes of the new blocks each peer has
+func (s *Syncer) findTarget(peers []string) (*syncTarget, map[string]map[string]bool) {
+	var chain Blocks = s.blockChain.getMainChain()
+	var targets []*syncTarget = make([]*syncTarget, len(peers))
+	var fetches sync.WaitGroup
+	for i, peer := range peers {
+		if peer == s.selfUrl {
+			continue
+		}
+		fetches.Add(1)
+		go func(i int, peer string) {
+			defer fetches.Done()
+			target, err := s.fetchBranch(peer, chain)
+			if err != nil {
+				log.Printf("Failed to sync headers from node %s: %s", peer, err)
+				return
+			}
+			targets[i] = target // nil if the peer is far behind
+		}(i, peer)
+	}
+	fetches.Wait()
+
+	var best *syncTarget = nil
+	var bestWork *big.Int = s.blockChain.GetChainWork()
+	var sources map[string]map[string]bool = map[string]map[string]bool{}
+	for _, target := range targets {
+		if target == nil {
+			continue
+		}
+		var hashes map[string]bool = map[string]bool{}
+		for _, header := range target.headers {
+			hashes[header.Hash] = true
+		}
+		sources[target.peer] = hashes
+		if len(target.headers) > 0 && target.work.Cmp(bestWork) > 0 {
+			best = target
+			bestWork = target.work
+		}
+	}
+	return best, sources
+}
+
+// fetchBranch fetches the headers of a peer's main chain after the last block it has in common with
+// chain (our main chain), up to maxSyncDistance blocks above our tip, and checks them. The work of the
+// peer's chain is computed from the headers. Returns nil if the peer's chain ends more than maxForkDepth
+// blocks below our tip
+func (s *Syncer) fetchBranch(peer string, chain Blocks) (*syncTarget, error) {
+	var from int = len(chain) - maxForkDepth
+	if from < 1 {
+		from = 1
+	}
+	var until int = len(chain) + maxSyncDistance
+	headers, partial, err := s.fetchHeaders(peer, from, until)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		return nil, nil // the peer is more than maxForkDepth blocks behind us
+	}
+	if headers[0].Hash != chain[from-1].Hash {
+		// The chains fork more than maxForkDepth blocks below our tip
+		if headers, partial, err = s.fetchHeaders(peer, 1, until); err != nil {
+			return nil, err
+		}
+	}
+	if len(headers) == 0 || headers[0].Hash != chain[headers[0].Index-1].Hash {
+		return nil, fmt.Errorf("%w: the peer's chain does not start with our genesis block", ErrIncompatibleNode)
+	}
+
+	// Skip the blocks we have in common, then check the others as if they were added to our chain
+	var forkLength int = headers[0].Index
+	var position int = 1
+	for position < len(headers) && headers[position].Index <= len(chain) && headers[position].Hash == chain[headers[position].Index-1].Hash {
+		forkLength = headers[position].Index
+		position++
+	}
+	var branch Blocks = chain[:forkLength:forkLength]
+	for _, header := range headers[position:] {
+		var block Block = header.block()
+		if reason := checkHeader(block); reason != "" {
+			return nil, fmt.Errorf("%w: header %d: %s", ErrBlockRejected, header.Index, reason)
+		}
+		if reason := s.blockChain.checkBlockPlacement(branch, block); reason != "" {
+			return nil, fmt.Errorf("%w: header %d: %s", ErrBlockRejected, header.Index, reason)
+		}
+		branch = append(branch, block)
+	}
+	return &syncTarget{peer: peer, work: ChainWork(branch), headers: headers[position:], partial: partial}, nil
+}
+
+// fetchHeaders fetches the headers of a peer's main chain from index from up to its tip, or up to index
+// until if its tip is higher (then partial is true), page by page. A page without headers ends the fetch
+func (s *Syncer) fetchHeaders(peer string, from int, until int) (headers []HeaderRecord, partial bool, err error) {
+	headers = []HeaderRecord{}
+	for from <= until {
+		var count int = until - from + 1
+		if count > maxHeadersPerRequest {
+			count = maxHeadersPerRequest
+		}
+		var page HeadersResponse
+		var query string = fmt.Sprintf("/sync/headers?from=%d&count=%d", from, count)
+		if err = s.getJson(peer+query, &page); err != nil {
+			return nil, false, err
+		}
+		if len(page.Headers) > count {
+			return nil, false, fmt.Errorf("%d headers returned, %d requested", len(page.Headers), count)
+		}
+		for i, header := range page.Headers {
+			if header.Index != from+i {
+				return nil, false, fmt.Errorf("header %d is not at index %d", header.Index, from+i)
+			}
+		}
+		headers = append(headers, page.Headers...)
+		from += len(page.Headers)
+		if len(page.Headers) == 0 || from > page.TipIndex {
+			return headers, false, nil
+		}
+	}
+	return headers, true, nil
+}
+
+// checkHeader returns the reason why a header (see HeaderRecord.block) is not valid on its own, or ""
+// if it is valid: the version, the hash recomputed from the header, its proof of work and its timestamp
+func checkHeader(block Block) string {
+	if block.Version != BlockVersion {
+		return fmt.Sprintf("unsupported block version %d (this node supports version %d)", block.Version, BlockVersion)
+	}
+	if recomputedHash := block.Header().Hash(); recomputedHash != block.Hash {
+		return fmt.Sprintf("hash %q does not match recomputed hash %q", block.Hash, recomputedHash)
+	}
+	if !hashMeetsDifficulty(block.Hash, block.Difficulty) {
+		return fmt.Sprintf("hash %q does not have %d leading zero bits", block.Hash, block.Difficulty)
+	}
+	if block.Timestamp > time.Now().Add(maxBlockTimeDrift).UnixNano() {
+		return "timestamp is too far in the future"
+	}
+	return ""
+}
+
+// downloadWindow downloads the bodies of the given headers that are neither known nor in the cache, in
+// batches spread over the peers that have them, by syncDownloaders workers
+func (s *Syncer) downloadWindow(headers []HeaderRecord, sources map[string]map[string]bool) error {
+	var wanted []HeaderRecord = []HeaderRecord{}
+	s.mutex.Lock()
+	for _, header := range headers {
+		if _, cached := s.cache[header.Hash]; !cached {
+			wanted = append(wanted, header)
+		}
+	}
+	s.mutex.Unlock()
+	if len(wanted) > 0 {
+		var hashes []string = make([]string, len(wanted))
+		for i, header := range wanted {
+			hashes[i] = header.Hash
+		}
+		var known map[string]bool = map[string]bool{}
+		for _, block := range s.blockChain.GetBlocksByHash(hashes) {
+			known[block.Hash] = true
+		}
+		var missing []HeaderRecord = []HeaderRecord{}
+		for _, header := range wanted {
+			if !known[header.Hash] {
+				missing = append(missing, header)
+			}
+		}
+		wanted = missing
+	}
+
+	var batches chan []HeaderRecord = make(chan []HeaderRecord, len(wanted)/syncBatchSize+1)
+	for start := 0; start < len(wanted); start += syncBatchSize {
+		var end int = start + syncBatchSize
+		if end > len(wanted) {
+			end = len(wanted)
+		}
+		batches <- wanted[start:end]
+	}
+	close(batches)
+
+	var workers sync.WaitGroup
+	var errorsMutex sync.Mutex
+	var failure error = nil
+	for worker := 0; worker < syncDownloaders; worker++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			for batch := range batches {
+				if err := s.downloadBatch(batch, peersWith(sources, batch[len(batch)-1].Hash), worker); err != nil {
+					errorsMutex.Lock()
+					failure = err
+					errorsMutex.Unlock()
+				}
+			}
+		}(worker)
+	}
+	workers.Wait()
+	return failure
+}
+
+// peersWith returns the peers whose branch contains the given block (and therefore its ancestors),
+// sorted by url
+func peersWith(sources map[string]map[string]bool, hash string) []string {
+	var peers []string = []string{}
+	for peer, hashes := range sources {
+		if hashes[hash] {
+			peers = append(peers, peer)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// downloadBatch downloads the bodies of a batch of headers into the cache. Each attempt asks another
+// of the peers for the bodies still missing
+func (s *Syncer) downloadBatch(batch []HeaderRecord, peers []string, worker int) error {
+	if len(peers) == 0 {
+		return fmt.Errorf("no peer has block %d", batch[0].Index)
+	}
+	var missing []HeaderRecord = batch
+	var lastError error = nil
+	for attempt := 0; attempt < syncMaxAttempts && len(missing) > 0; attempt++ {
+		var peer string = peers[(worker+attempt)%len(peers)]
+		var query url.Values = url.Values{}
+		for _, header := range missing {
+			query.Add("hash", header.Hash)
+		}
+		var blocks Blocks
+		if err := s.getJson(peer+"/sync/blocks?"+query.Encode(), &blocks); err != nil {
+			lastError = fmt.Errorf("node %s: %w", peer, err)
+			continue
+		}
+
+		// Keep the bodies that match their header: same hash, and a hash and Merkle roots that match
+		// the contents of the block
+		var received map[string]Block = map[string]Block{}
+		for _, block := range blocks {
+			if reason := checkBlockContents(block); reason != "" {
+				lastError = fmt.Errorf("node %s sent block %d: %s", peer, block.Index, reason)
+				continue
+			}
+			received[block.Hash] = block
+		}
+		var stillMissing []HeaderRecord = []HeaderRecord{}
+		s.mutex.Lock()
+		for _, header := range missing {
+			if block, ok := received[header.Hash]; ok {
+				s.cache[header.Hash] = block
+				s.status.BlocksDownloaded++
+			} else {
+				stillMissing = append(stillMissing, header)
+			}
+		}
+		s.mutex.Unlock()
+		missing = stillMissing
+		if len(missing) > 0 && lastError == nil {
+			lastError = fmt.Errorf("node %s did not send block %d", peer, missing[0].Index)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("failed to download block %d: %w", missing[0].Index, lastError)
+	}
+	return nil
+}
+
+// addWindow adds the downloaded blocks of the given headers to the chain, in order (see AddBlock).
+// Blocks that were added are removed from the cache; a rejected block is dropped, and stops the sync
+func (s *Syncer) addWindow(headers []HeaderRecord) error {
+	for _, header := range headers {
+		s.mutex.Lock()
+		block, cached := s.cache[header.Hash]
+		s.mutex.Unlock()
+		if !cached {
+			continue // already known
+		}
+		result, err := s.blockChain.AddBlock(block)
+		var status string = result.Status
+		s.mutex.Lock()
+		if err == nil || errors.Is(err, ErrBlockRejected) {
+			delete(s.cache, header.Hash)
+		}
+		if err == nil {
+			s.status.BlocksAdded++
+		}
+		if status == BlockConnected || status == BlockReorganized {
+			s.status.Replaced = true
+		}
+		s.mutex.Unlock()
+		if err != nil {
+			return fmt.Errorf("block %d (%s): %w", block.Index, block.Hash, err)
+		}
+		if status == BlockOrphan {
+			return fmt.Errorf("block %d (%s): its parent is no longer known", block.Index, block.Hash)
+		}
+	}
+	return nil
+}
+
+// getJson gets a url and decodes its JSON response into value
+func (s *Syncer) getJson(url string, value interface{}) error {
+	response, err := s.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	if err = json.NewDecoder(response.Body).Decode(value); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// parseHeadersQuery reads the from and count query parameters of GET /sync/headers
+func parseHeadersQuery(request *http.Request) (int, int, error) {
+	var from int = 1
+	var count int = maxHeadersPerRequest
+	var err error
+	if value := request.URL.Query().Get("from"); value != "" {
+		if from, err = strconv.Atoi(value); err != nil || from < 1 {
+			return 0, 0, fmt.Errorf("from must be a block index (1 or more)")
+		}
+	}
+	if value := request.URL.Query().Get("count"); value != "" {
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxHeadersPerRequest {
+			return 0, 0, fmt.Errorf("count must be between 1 and %d", maxHeadersPerRequest)
+		}
+	}
+	return from, count, nil
+}
@@ -0,0 +1,89 @@
+package bid
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syncHandler serves the endpoints a syncer calls on a peer (see sync.go)
+func syncHandler(c *Controller) http.Handler {
+	var mux *http.ServeMux = http.NewServeMux()
+	mux.HandleFunc("/sync/headers", c.GetHeaders)
+	mux.HandleFunc("/sync/blocks", c.GetSyncBlocks)
+	mux.HandleFunc("/mempool/bids", c.GetPendingBids)
+	return mux
+}
+
+// A node syncs the blocks it is missing from two peers with the same chain, one of which fails to serve
+// block bodies, then takes over the pending bids of the peer it synced with. Blocks it already has are not downloaded again
+func TestHeadersFirstSync(t *testing.T) {
+	var source, mirror *Controller = newTestController(t, "http://localhost:9101"), newTestController(t, "http://localhost:9102")
+	defer source.gossip.Stop()
+	defer mirror.gossip.Stop()
+
+	var seller, alice ed25519.PrivateKey = newTestKey(), newTestKey()
+	var now int64 = time.Now().UnixNano()
+	if err := source.blockChain.RegisterAuctionRecord(testAuction(seller, 1, func(record *AuctionRecord) {
+		record.OpenTime, record.CloseTime = now-int64(time.Hour), now+int64(time.Hour)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	for len(source.blockChain.getMainChain()) < 30 {
+		block, err := mineBlock(source.blockChain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = mirror.blockChain.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var bid Bid = testBid(alice, 1, "10.00", 1)
+	for _, node := range []*Controller{source, mirror} {
+		if err := node.blockChain.RegisterBid(bid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sourceServer *httptest.Server = httptest.NewServer(syncHandler(source))
+	defer sourceServer.Close()
+	var failingServer *httptest.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasPrefix(request.URL.Path, "/sync/blocks") {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		syncHandler(mirror).ServeHTTP(writer, request)
+	}))
+	defer failingServer.Close()
+
+	// The syncing node has the first 10 blocks
+	b, err := NewBlockChain(NewMemoryStorage(), TestGenesisConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range source.blockChain.getMainChain()[1:10] {
+		if _, err = b.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := NewSyncer(b, "http://localhost:9100").Sync([]string{failingServer.URL, sourceServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameBlocks(b.getMainChain(), source.blockChain.getMainChain()) {
+		t.Fatalf("chain height %d after sync, expected %d", len(b.getMainChain()), len(source.blockChain.getMainChain()))
+	}
+	if status.ForkIndex != 10 || status.Headers != 20 || status.BlocksDownloaded != 20 || status.BlocksAdded != 20 || !status.Replaced {
+		t.Fatalf("sync status %+v", status)
+	}
+	if pending := b.GetPendingBids(); len(pending) != 1 || pending[0] != bid || status.PendingBidsAdded != 1 {
+		t.Fatalf("pending bids of the sync target not taken over: %+v", pending)
+	}
+
+	// Nothing left to sync
+	if status, err = NewSyncer(b, "http://localhost:9100").Sync([]string{sourceServer.URL}); err != nil || status.Headers != 0 || status.BlocksAdded != 0 {
+		t.Fatalf("second sync: status %+v, error %v", status, err)
+	}
+}
@@ -0,0 +1,848 @@
+/* Webhooks. Instead of keeping an event stream open (see events.go), a client registers a url with
+POST /webhooks and the node POSTs to it when something it cares about happens:
+	outbid			a bid beat the leading bid of an auction (data: OutbidEvent)
+	auction_closed	a block closing an auction joined the main chain (data: AuctionState)
+	bid_confirmed	a block with a bid reached Confirmations confirmations (data: the bid, see BidRecord)
+A subscription narrows these down with auction_ids and bidders (public keys): outbid is sent when the
+bid that lost the lead is of one of the bidders, auction_closed when one of the bidders bid in the
+auction, bid_confirmed for the bids of the bidders. Empty lists match everything.
+Each delivery is a POST of a WebhookPayload, with the headers
+	X-Webhook-Id			the id of the subscription
+	X-Webhook-Delivery		the id of the delivery, the same for all attempts
+	X-Webhook-Event			the type of event
+	X-Webhook-Timestamp		the Unix time of the attempt, in seconds
+	X-Webhook-Signature		sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret>
+The secret of a subscription is only returned when it is created. A delivery succeeds when the receiver
+answers 2xx; otherwise it is retried with exponential backoff, up to maxWebhookAttempts attempts.
+Pending deliveries (the outbox) are stored with the subscriptions, so they are sent after a restart.
+Deliveries are at least once: a delivery sent just before a restart, or a bid confirmed again after a
+reorganization, may be received twice, so receivers should ignore delivery ids and bid hashes they have
+already seen. The last maxWebhookLog attempts are kept for GET /webhooks/{webhookId}/deliveries.
+Anyone who can reach the api can register a webhook, so the node does not send deliveries to loopback,
+link-local or private addresses (see isPrivateAddress), which could reach services that are not public:
+the host of a url is checked when the webhook is registered, and the address a delivery connects to is
+checked again, since a host name may resolve to another address later. An operator whose receivers run
+on the same machine or network allows them with Config.AllowPrivateWebhooks */
+package bid
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Types of webhook events
+const (
+	WebhookOutbid        = "outbid"
+	WebhookAuctionClosed = "auction_closed"
+	WebhookBidConfirmed  = "bid_confirmed"
+)
+
+// Outcomes of a delivery attempt, as written in the delivery log
+const (
+	DeliverySucceeded = "delivered"
+	DeliveryRetrying  = "retrying"
+	DeliveryFailed    = "failed"
+	DeliveryDropped   = "dropped"
+)
+
+// Limits and timings of webhooks
+const (
+	maxWebhookSubscriptions = 100
+	maxWebhookConfirmations = 1000
+	maxWebhookOutbox        = 10000
+	maxWebhookLog           = 1000
+	maxWebhookAttempts      = 10
+	webhookWorkers          = 4
+	webhookTimeout          = 10 * time.Second
+	webhookRetryBase        = 5 * time.Second
+	webhookRetryMax         = time.Hour
+)
+
+// ErrInvalidWebhook is returned when a webhook subscription is not valid
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Webhooks keeps the webhook subscriptions and sends their deliveries
+type Webhooks struct {
+	blockChain   *BlockChain
+	store        Storage
+	client       *http.Client
+	allowPrivate bool
+
+	mutex    sync.Mutex
+	state    WebhookState
+	inFlight map[string]bool
+
+	wake     chan struct{}
+	stop      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	done      chan struct{}
+	sends    sync.WaitGroup
+}
+
+// NewWebhooks loads the webhook subscriptions and outbox from store. Deliveries are sent once started
+func NewWebhooks(blockChain *BlockChain, store Storage, allowPrivate bool) (*Webhooks, error) {
+	state, err := store.LoadWebhooks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	var webhooks *Webhooks = &Webhooks{
+		blockChain:   blockChain,
+		store:        store,
+		client:       newWebhookClient(allowPrivate),
+		allowPrivate: allowPrivate,
+		state:        state,
+		inFlight:     map[string]bool{},
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	return webhooks, nil
+}
+
+// Start starts sending deliveries in the background. Later calls, and calls after Stop, do nothing
+func (w *Webhooks) Start() {
+	w.startOnce.Do(func() { go w.run() })
+}
+
+// Stop stops sending deliveries, once the attempts in flight are over. The outbox stays stored.
+// Webhooks that were not started never start
+func (w *Webhooks) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	w.startOnce.Do(func() { close(w.done) })
+	<-w.done
+	w.sends.Wait()
+}
+
+// Subscribe adds a subscription and returns it, with its secret
+func (w *Webhooks) Subscribe(request WebhookRequest) (WebhookSubscription, error) {
+	if err := checkWebhookRequest(&request, w.allowPrivate); err != nil {
+		return WebhookSubscription{}, err
+	}
+	var subscription WebhookSubscription = WebhookSubscription{
+		Id:            newWebhookId(8),
+		Url:           request.Url,
+		Secret:        newWebhookId(32),
+		Events:        request.Events,
+		AuctionIds:    request.AuctionIds,
+		Bidders:       request.Bidders,
+		Confirmations: request.Confirmations,
+		CreatedAt:     time.Now(),
+	}
+	// Only bids confirmed from now on are sent
+	subscription.ConfirmedThrough = w.blockChain.GetTip().Index - subscription.Confirmations + 1
+	if subscription.ConfirmedThrough < 0 {
+		subscription.ConfirmedThrough = 0
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.state.Subscriptions) >= maxWebhookSubscriptions {
+		return WebhookSubscription{}, fmt.Errorf("%w: at most %d webhooks can be registered", ErrInvalidWebhook, maxWebhookSubscriptions)
+	}
+	w.state.Subscriptions = append(w.state.Subscriptions, subscription)
+	if err := w.save(); err != nil {
+		w.state.Subscriptions = w.state.Subscriptions[:len(w.state.Subscriptions)-1]
+		return WebhookSubscription{}, err
+	}
+	return subscription, nil
+}
+
+// Unsubscribe removes a subscription and its pending deliveries. Returns false if there is no such
+// subscription
+func (w *Webhooks) Unsubscribe(webhookId string) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var subscriptions []WebhookSubscription = []WebhookSubscription{}
+	for _, subscription := range w.state.Subscriptions {
+		if subscription.Id != webhookId {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if len(subscriptions) == len(w.state.Subscriptions) {
+		return false, nil
+	}
+	var outbox []WebhookDelivery = []WebhookDelivery{}
+	for _, delivery := range w.state.Outbox {
+		if delivery.WebhookId != webhookId {
+			outbox = append(outbox, delivery)
+		}
+	}
+	w.state.Subscriptions = subscriptions
+	w.state.Outbox = outbox
+	return true, w.save()
+}
+
+// GetSubscriptions returns the subscriptions, without their secrets
+func (w *Webhooks) GetSubscriptions() []WebhookSubscription {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var subscriptions []WebhookSubscription = make([]WebhookSubscription, 0, len(w.state.Subscriptions))
+	for _, subscription := range w.state.Subscriptions {
+		subscription.Secret = ""
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions
+}
+
+// GetDeliveries returns the pending deliveries of a subscription and its delivery log, oldest first.
+// Returns false if there is no such subscription
+func (w *Webhooks) GetDeliveries(webhookId string) (WebhookDeliveries, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.subscription(webhookId) == nil {
+		return WebhookDeliveries{}, false
+	}
+	var deliveries WebhookDeliveries = WebhookDeliveries{WebhookId: webhookId, Pending: []WebhookDelivery{}, Log: []WebhookLogEntry{}}
+	for _, delivery := range w.state.Outbox {
+		if delivery.WebhookId == webhookId {
+			deliveries.Pending = append(deliveries.Pending, delivery)
+		}
+	}
+	for _, entry := range w.state.Log {
+		if entry.WebhookId == webhookId {
+			deliveries.Log = append(deliveries.Log, entry)
+		}
+	}
+	return deliveries, true
+}
+
+// subscription returns the subscription with the given id, or nil. Must be called with mutex held
+func (w *Webhooks) subscription(webhookId string) *WebhookSubscription {
+	for i := range w.state.Subscriptions {
+		if w.state.Subscriptions[i].Id == webhookId {
+			return &w.state.Subscriptions[i]
+		}
+	}
+	return nil
+}
+
+// save stores the subscriptions, outbox and log. Must be called with mutex held
+func (w *Webhooks) save() error {
+	return w.store.SaveWebhooks(w.state)
+}
+
+/* Turning events into deliveries */
+
+// handleEvent queues the deliveries an event of the event bus calls for. Registered with
+// EventBus.Observe
+func (w *Webhooks) handleEvent(event Event) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.state.Subscriptions) == 0 {
+		return
+	}
+
+	var changed bool
+	switch event.Type {
+	case EventBidAccepted:
+		if record, ok := event.Data.(BidRecord); ok {
+			changed = w.queueOutbid(record)
+		}
+	case EventAuctionClosed:
+		if state, ok := event.Data.(AuctionState); ok {
+			changed = w.queueAuctionClosed(state)
+		}
+	case EventBlockMined, EventBlockReceived:
+		// A block that reorganized the chain is followed by a chain_reorganized event, handled below
+		if data, ok := event.Data.(BlockEvent); ok && data.Status == BlockConnected {
+			changed = w.queueConfirmedBids(0)
+		}
+	case EventChainReorganized:
+		if reorg, ok := event.Data.(ReorgEvent); ok {
+			changed = w.queueConfirmedBids(reorg.ForkIndex)
+		}
+	case EventChainReplaced:
+		if replaced, ok := event.Data.(ChainReplacedEvent); ok {
+			changed = w.queueConfirmedBids(replaced.ForkIndex)
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := w.save(); err != nil {
+		log.Printf("Failed to store webhooks: %s", err)
+	}
+	w.signal()
+}
+
+// queueOutbid queues outbid deliveries when a new bid beats the leading bid of its auction, confirmed
+// or pending, and comes from another bidder. Must be called with mutex held
+func (w *Webhooks) queueOutbid(record BidRecord) bool {
+	if record.Bid.Kind == BidKindCommit {
+		return false
+	}
+	previous, exists := w.blockChain.GetLeadingBid(record.Bid.AuctionId, record.BidHash)
+	if !exists || previous.Bid.PublicKey == record.Bid.PublicKey || record.Bid.BidValue.Cmp(previous.Bid.BidValue) <= 0 {
+		return false
+	}
+	var data OutbidEvent = OutbidEvent{AuctionId: record.Bid.AuctionId, PreviousBid: previous, NewBid: record}
+	var queued bool
+	for _, subscription := range w.state.Subscriptions {
+		if subscription.wants(WebhookOutbid, record.Bid.AuctionId, []string{previous.Bid.PublicKey}) {
+			queued = w.queue(subscription, WebhookOutbid, data) || queued
+		}
+	}
+	return queued
+}
+
+// queueAuctionClosed queues auction_closed deliveries. Must be called with mutex held
+func (w *Webhooks) queueAuctionClosed(state AuctionState) bool {
+	var bidders []string = []string{}
+	for _, bid := range state.bids {
+		bidders = append(bidders, bid.PublicKey)
+	}
+	var queued bool
+	for _, subscription := range w.state.Subscriptions {
+		if subscription.wants(WebhookAuctionClosed, state.Auction.AuctionId, bidders) {
+			queued = w.queue(subscription, WebhookAuctionClosed, state) || queued
+		}
+	}
+	return queued
+}
+
+// queueConfirmedBids queues bid_confirmed deliveries for the bids of the main chain that reached the
+// confirmations of each subscription since the last call. A reorganization or replaced chain that
+// forked after block forkIndex (0 if the chain was only extended) rechecks the blocks after it. Must
+// be called with mutex held
+func (w *Webhooks) queueConfirmedBids(forkIndex int) bool {
+	var chain Blocks = w.blockChain.getMainChain()
+	var changed bool
+	for i := range w.state.Subscriptions {
+		var subscription *WebhookSubscription = &w.state.Subscriptions[i]
+		if !containsString(subscription.Events, WebhookBidConfirmed) {
+			continue
+		}
+		if forkIndex > 0 && subscription.ConfirmedThrough > forkIndex {
+			subscription.ConfirmedThrough = forkIndex
+			changed = true
+		}
+		var confirmedThrough int = len(chain) - subscription.Confirmations + 1
+		for index := subscription.ConfirmedThrough + 1; index <= confirmedThrough; index++ {
+			var block Block = chain[index-1]
+			for position, bid := range block.Bids {
+				if !subscription.wants(WebhookBidConfirmed, bid.AuctionId, []string{bid.PublicKey}) {
+					continue
+				}
+				w.queue(*subscription, WebhookBidConfirmed, BidRecord{
+					Bid:           bid,
+					BidHash:       bid.Hash(),
+					Confirmed:     true,
+					Confirmations: len(chain) - block.Index + 1,
+					BidLocation:   BidLocation{BlockIndex: block.Index, BlockHash: block.Hash, Position: position},
+					Timestamp:     block.Timestamp,
+				})
+			}
+		}
+		if confirmedThrough > subscription.ConfirmedThrough {
+			subscription.ConfirmedThrough = confirmedThrough
+			changed = true
+		}
+	}
+	return changed
+}
+
+// wants checks if a subscription wants an event of the given type about an auction and bidders
+func (subscription WebhookSubscription) wants(eventType string, auctionId int, bidders []string) bool {
+	if !containsString(subscription.Events, eventType) {
+		return false
+	}
+	if len(subscription.AuctionIds) > 0 {
+		var found bool
+		for _, wanted := range subscription.AuctionIds {
+			found = found || wanted == auctionId
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(subscription.Bidders) == 0 {
+		return true
+	}
+	for _, bidder := range bidders {
+		if containsString(subscription.Bidders, bidder) {
+			return true
+		}
+	}
+	return false
+}
+
+// queue adds a delivery to the outbox. When the outbox is full, the delivery is dropped and logged.
+// Must be called with mutex held
+func (w *Webhooks) queue(subscription WebhookSubscription, eventType string, data interface{}) bool {
+	var now time.Time = time.Now()
+	var payload WebhookPayload = WebhookPayload{
+		Id:        newWebhookId(16),
+		WebhookId: subscription.Id,
+		Event:     eventType,
+		Time:      now,
+		Data:      data,
+	}
+	if len(w.state.Outbox) >= maxWebhookOutbox {
+		log.Printf("Webhook outbox is full: dropping %s delivery %s to %s", eventType, payload.Id, subscription.Url)
+		w.record(WebhookLogEntry{
+			Time:       now,
+			DeliveryId: payload.Id,
+			WebhookId:  subscription.Id,
+			Event:      eventType,
+			Outcome:    DeliveryDropped,
+			Error:      "outbox is full",
+		})
+		return true
+	}
+	body, _ := json.Marshal(payload)
+	w.state.Outbox = append(w.state.Outbox, WebhookDelivery{
+		Id:          payload.Id,
+		WebhookId:   subscription.Id,
+		Event:       eventType,
+		Payload:     body,
+		CreatedAt:   now,
+		NextAttempt: now,
+	})
+	return true
+}
+
+// record adds an entry to the delivery log, forgetting the oldest ones. Must be called with mutex held
+func (w *Webhooks) record(entry WebhookLogEntry) {
+	w.state.Log = append(w.state.Log, entry)
+	if len(w.state.Log) > maxWebhookLog {
+		w.state.Log = w.state.Log[len(w.state.Log)-maxWebhookLog:]
+	}
+}
+
+// GetLeadingBid gets the bid with the highest value of an auction, among its confirmed bids and its
+// pending bids, leaving out the bid with the given hash. On equal values the earliest bid leads, the
+// confirmed bids first. Returns false if the auction has no bid with a value
+func (b *BlockChain) GetLeadingBid(auctionId int, excludedHash string) (BidRecord, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var leading BidRecord
+	var exists bool
+	if auction, known := b.ledger.auctions[auctionId]; known && auction.LeadingBid != nil {
+		leading = BidRecord{Bid: *auction.LeadingBid, BidHash: auction.LeadingBid.Hash(), Confirmed: true}
+		exists = leading.BidHash != excludedHash
+	}
+	for _, entry := range b.mempool.listForAuction(auctionId) {
+		if entry.hash == excludedHash || entry.bid.Kind == BidKindCommit {
+			continue
+		}
+		if !exists || entry.bid.BidValue.Cmp(leading.Bid.BidValue) > 0 {
+			leading = BidRecord{Bid: entry.bid, BidHash: entry.hash}
+			exists = true
+		}
+	}
+	return leading, exists
+}
+
+/* Sending deliveries */
+
+// signal wakes the delivery loop up
+func (w *Webhooks) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the deliveries that are due, at most webhookWorkers at a time, until Stop is called
+func (w *Webhooks) run() {
+	defer close(w.done)
+	var timer *time.Timer = time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-timer.C:
+		}
+		var wait time.Duration = w.startDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// startDue starts sending the deliveries that are due and returns how long to wait for the next one
+func (w *Webhooks) startDue() time.Duration {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var now time.Time = time.Now()
+	var wait time.Duration = webhookRetryMax
+	for _, delivery := range w.state.Outbox {
+		if w.inFlight[delivery.Id] {
+			continue
+		}
+		if delivery.NextAttempt.After(now) {
+			if delivery.NextAttempt.Sub(now) < wait {
+				wait = delivery.NextAttempt.Sub(now)
+			}
+			continue
+		}
+		if len(w.inFlight) >= webhookWorkers {
+			break // woken up again when a send is over
+		}
+		var subscription *WebhookSubscription = w.subscription(delivery.WebhookId)
+		if subscription == nil {
+			continue
+		}
+		w.inFlight[delivery.Id] = true
+		w.sends.Add(1)
+		go w.send(*subscription, delivery)
+	}
+	return wait
+}
+
+// send makes an attempt to deliver, then updates the outbox and the log
+func (w *Webhooks) send(subscription WebhookSubscription, delivery WebhookDelivery) {
+	defer w.sends.Done()
+	var started time.Time = time.Now()
+	statusCode, err := w.post(subscription, delivery, started)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	delete(w.inFlight, delivery.Id)
+
+	var position int = -1
+	for i := range w.state.Outbox {
+		if w.state.Outbox[i].Id == delivery.Id {
+			position = i
+		}
+	}
+	if position < 0 {
+		return // the subscription was removed meanwhile
+	}
+	var entry WebhookLogEntry = WebhookLogEntry{
+		Time:       started,
+		DeliveryId: delivery.Id,
+		WebhookId:  delivery.WebhookId,
+		Event:      delivery.Event,
+		Attempt:    delivery.Attempts + 1,
+		StatusCode: statusCode,
+		DurationMs: float64(time.Since(started)) / float64(time.Millisecond),
+	}
+	var pending *WebhookDelivery = &w.state.Outbox[position]
+	pending.Attempts++
+	if err == nil {
+		entry.Outcome = DeliverySucceeded
+		w.state.Outbox = append(w.state.Outbox[:position], w.state.Outbox[position+1:]...)
+	} else if pending.Attempts >= maxWebhookAttempts {
+		entry.Outcome = DeliveryFailed
+		entry.Error = err.Error()
+		log.Printf("Giving up webhook delivery %s to %s after %d attempts: %s", delivery.Id, subscription.Url, pending.Attempts, err)
+		w.state.Outbox = append(w.state.Outbox[:position], w.state.Outbox[position+1:]...)
+	} else {
+		entry.Outcome = DeliveryRetrying
+		entry.Error = err.Error()
+		pending.LastError = err.Error()
+		pending.NextAttempt = time.Now().Add(webhookRetryDelay(pending.Attempts))
+	}
+	w.record(entry)
+	if err := w.save(); err != nil {
+		log.Printf("Failed to store webhooks: %s", err)
+	}
+	w.signal()
+}
+
+// post sends a delivery, signed with the secret of its subscription. Returns the status code of the
+// response (0 if there was none) and an error unless it was 2xx
+func (w *Webhooks) post(subscription WebhookSubscription, delivery WebhookDelivery, now time.Time) (int, error) {
+	var timestamp string = strconv.FormatInt(now.Unix(), 10)
+	request, err := http.NewRequest(http.MethodPost, subscription.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("X-Webhook-Id", subscription.Id)
+	request.Header.Set("X-Webhook-Delivery", delivery.Id)
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", signWebhook(subscription.Secret, timestamp, delivery.Payload))
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// signWebhook returns the X-Webhook-Signature header of a delivery body sent at the given timestamp
+func signWebhook(secret string, timestamp string, body []byte) string {
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay returns how long to wait before the next attempt, after the given number of failed
+// attempts: webhookRetryBase, doubled after each attempt, at most webhookRetryMax
+func webhookRetryDelay(attempts int) time.Duration {
+	var delay time.Duration = webhookRetryBase
+	for attempt := 1; attempt < attempts && delay < webhookRetryMax; attempt++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+// checkWebhookRequest checks a new subscription, and sets the default number of confirmations. Unless
+// allowPrivate is set, the host of the url must not be a private address (see checkWebhookHost)
+func checkWebhookRequest(request *WebhookRequest, allowPrivate bool) error {
+	target, err := url.Parse(request.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an http or https url", ErrInvalidWebhook)
+	}
+	if !allowPrivate {
+		if err = checkWebhookHost(target.Hostname()); err != nil {
+			return err
+		}
+	}
+	if len(request.Events) == 0 {
+		return fmt.Errorf("%w: events must name at least one event", ErrInvalidWebhook)
+	}
+	for _, eventType := range request.Events {
+		switch eventType {
+		case WebhookOutbid, WebhookAuctionClosed, WebhookBidConfirmed:
+		default:
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, eventType)
+		}
+	}
+	if request.Confirmations == 0 {
+		request.Confirmations = 1
+	}
+	if request.Confirmations < 1 || request.Confirmations > maxWebhookConfirmations {
+		return fmt.Errorf("%w: confirmations must be between 1 and %d", ErrInvalidWebhook, maxWebhookConfirmations)
+	}
+	if request.AuctionIds == nil {
+		request.AuctionIds = []int{}
+	}
+	if request.Bidders == nil {
+		request.Bidders = []string{}
+	}
+	return nil
+}
+
+// Private networks (see isPrivateAddress): "this network" (0.0.0.1 reaches the local machine on Linux),
+// RFC 1918, shared address space (RFC 6598), unique local IPv6 addresses and IPv4-compatible IPv6
+// addresses. IPv4-mapped IPv6 addresses (::ffff:127.0.0.1) are checked as the IPv4 address they map
+var privateNetworks []*net.IPNet = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7", "::/96")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet = []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isPrivateAddress checks if an address is not public: loopback, link-local, private (see
+// privateNetworks), unspecified (which reaches the local machine) or multicast
+func isPrivateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebhookHost checks that the host of a webhook url is not a private address. A host name is
+// resolved, and none of its addresses may be private
+func checkWebhookHost(host string) error {
+	var addresses []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = []net.IP{ip}
+	} else {
+		resolved, err := net.LookupIP(host)
+		if err != nil {
+			return fmt.Errorf("%w: host %q cannot be resolved", ErrInvalidWebhook, host)
+		}
+		addresses = resolved
+	}
+	for _, ip := range addresses {
+		if isPrivateAddress(ip) {
+			return fmt.Errorf("%w: host %q is a loopback, link-local or private address (%s)", ErrInvalidWebhook, host, ip)
+		}
+	}
+	return nil
+}
+
+// newWebhookClient creates the http client that sends deliveries. Unless allowPrivate is set, it
+// refuses to connect to private addresses, whatever the host name of the url resolved to (this also
+// covers redirects), and it does not use a proxy, which would connect on its behalf
+func newWebhookClient(allowPrivate bool) *http.Client {
+	var transport *http.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		var dialer *net.Dialer = &net.Dialer{
+			Timeout: webhookTimeout,
+			Control: func(network string, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isPrivateAddress(ip) {
+					return fmt.Errorf("webhook address %s is a loopback, link-local or private address", host)
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// newWebhookId returns a random hex string of the given number of bytes, for ids and secrets
+func newWebhookId(size int) string {
+	var id []byte = make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/* Handlers */
+
+// RegisterWebhook POST /webhooks
+/* Registers a webhook. The body names the url and the events, and optionally the auctions, the bidders
+and the confirmations of bid_confirmed (1 by default). The url must be public unless the node allows
+private addresses (see the doc comment of this file):
+{
+	"url": "https://example.com/hooks/auctions",
+	"events": ["outbid", "bid_confirmed"],
+	"auction_ids": [100],
+	"bidders": ["3d40..."],
+	"confirmations": 6
+}
+Returns 201 Created with the subscription, including the secret deliveries are signed with (it is not
+returned again). Typical output looks like this:
+{
+	"id": "9b2f6c01d4e8a7f3",
+	"url": "https://example.com/hooks/auctions",
+	"secret": "5e0c...",
+	"events": ["outbid", "bid_confirmed"],
+	"auction_ids": [100],
+	"bidders": ["3d40..."],
+	"confirmations": 6,
+	"created_at": "2021-07-25T10:15:00.000000000Z",
+	"confirmed_through": 0
+}
+*/
+func (c *Controller) RegisterWebhook(writer http.ResponseWriter, request *http.Request) {
+	var webhookRequest WebhookRequest
+	if err := json.NewDecoder(request.Body).Decode(&webhookRequest); err != nil {
+		sendStandardResponse(writer, http.StatusBadRequest, "RegisterWebhook", "Webhook is not valid JSON")
+		return
+	}
+	subscription, err := c.webhooks.Subscribe(webhookRequest)
+	if errors.Is(err, ErrInvalidWebhook) {
+		sendStandardResponse(writer, http.StatusBadRequest, "RegisterWebhook", err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Failed to store webhook: %s", err)
+		sendStandardResponse(writer, http.StatusInternalServerError, "RegisterWebhook", "Webhook could not be stored")
+		return
+	}
+	sendJsonResponse(writer, http.StatusCreated, subscription)
+}
+
+// GetWebhooks GET /webhooks
+// Retrieves the registered webhooks, without their secrets
+func (c *Controller) GetWebhooks(writer http.ResponseWriter, request *http.Request) {
+	sendJsonResponse(writer, http.StatusOK, c.webhooks.GetSubscriptions())
+}
+
+// RemoveWebhook DELETE /webhooks/{webhookId}
+// Removes a webhook and drops its pending deliveries. Returns 404 if there is no such webhook
+func (c *Controller) RemoveWebhook(writer http.ResponseWriter, request *http.Request) {
+	var webhookId string = mux.Vars(request)["webhookId"]
+	removed, err := c.webhooks.Unsubscribe(webhookId)
+	if err != nil {
+		log.Printf("Failed to store webhooks: %s", err)
+		sendStandardResponse(writer, http.StatusInternalServerError, "RemoveWebhook", "Webhooks could not be stored")
+		return
+	}
+	if !removed {
+		sendStandardResponse(writer, http.StatusNotFound, "RemoveWebhook", fmt.Sprintf("Webhook %s not found", webhookId))
+		return
+	}
+	sendStandardResponse(writer, http.StatusOK, "RemoveWebhook", fmt.Sprintf("Webhook %s removed", webhookId))
+}
+
+// GetWebhookDeliveries GET /webhooks/{webhookId}/deliveries
+/* Retrieves the pending deliveries of a webhook and its last delivery attempts, oldest first. Returns
+404 if there is no such webhook. Typical output looks like this:
+{
+	"webhook_id": "9b2f6c01d4e8a7f3",
+	"pending": [
+		{
+			"id": "0f3a...",
+			"webhook_id": "9b2f6c01d4e8a7f3",
+			"event": "outbid",
+			"payload": {"id":"0f3a...","webhook_id":"9b2f6c01d4e8a7f3","event":"outbid",...},
+			"attempts": 2,
+			"created_at": "2021-07-25T10:15:00.000000000Z",
+			"next_attempt": "2021-07-25T10:15:15.000000000Z",
+			"last_error": "receiver answered 503"
+		}
+	],
+	"log": [
+		{
+			"time": "2021-07-25T10:15:05.000000000Z",
+			"delivery_id": "0f3a...",
+			"webhook_id": "9b2f6c01d4e8a7f3",
+			"event": "outbid",
+			"attempt": 2,
+			"outcome": "retrying",
+			"status_code": 503,
+			"error": "receiver answered 503",
+			"duration_ms": 12.5
+		}
+	]
+}
+*/
+func (c *Controller) GetWebhookDeliveries(writer http.ResponseWriter, request *http.Request) {
+	var webhookId string = mux.Vars(request)["webhookId"]
+	deliveries, exists := c.webhooks.GetDeliveries(webhookId)
+	if !exists {
+		sendStandardResponse(writer, http.StatusNotFound, "GetWebhookDeliveries", fmt.Sprintf("Webhook %s not found", webhookId))
+		return
+	}
+	sendJsonResponse(writer, http.StatusOK, deliveries)
+}
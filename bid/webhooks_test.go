@@ -0,0 +1,241 @@
+package bid
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver serves a webhook receiver that answers each delivery with the next status code of
+// statusCodes (the last one once they are used up), and keeps the requests it got and their bodies
+type webhookReceiver struct {
+	server      *httptest.Server
+	statusCodes []int
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(statusCodes ...int) *webhookReceiver {
+	var receiver *webhookReceiver = &webhookReceiver{statusCodes: statusCodes}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		receiver.mutex.Lock()
+		var attempt int = len(receiver.requests)
+		receiver.requests = append(receiver.requests, request)
+		receiver.bodies = append(receiver.bodies, body)
+		receiver.mutex.Unlock()
+		if attempt >= len(statusCodes) {
+			attempt = len(statusCodes) - 1
+		}
+		writer.WriteHeader(statusCodes[attempt])
+	}))
+	return receiver
+}
+
+func (r *webhookReceiver) received() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.requests)
+}
+
+// newTestWebhooks creates webhooks on store with one subscription to the receiver, and queues an outbid
+// delivery for it
+func newTestWebhooks(t *testing.T, store Storage, receiver *webhookReceiver) (*Webhooks, WebhookSubscription) {
+	t.Helper()
+	webhooks, err := NewWebhooks(newTestChain(t), store, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := webhooks.Subscribe(WebhookRequest{Url: receiver.server.URL + "/hook", Events: []string{WebhookOutbid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks.mutex.Lock()
+	webhooks.queue(subscription, WebhookOutbid, OutbidEvent{AuctionId: 1})
+	webhooks.save()
+	webhooks.mutex.Unlock()
+	return webhooks, subscription
+}
+
+// outbox returns a copy of the pending deliveries
+func (w *Webhooks) outbox() []WebhookDelivery {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]WebhookDelivery{}, w.state.Outbox...)
+}
+
+// A delivery carries the HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret of the subscription
+func TestWebhookSignature(t *testing.T) {
+	var receiver *webhookReceiver = newWebhookReceiver(http.StatusOK)
+	defer receiver.server.Close()
+	webhooks, subscription := newTestWebhooks(t, NewMemoryStorage(), receiver)
+	webhooks.Start()
+	defer webhooks.Stop()
+	waitFor(t, 5*time.Second, "the delivery", func() bool { return receiver.received() == 1 })
+
+	var request *http.Request = receiver.requests[0]
+	var mac = hmac.New(sha256.New, []byte(subscription.Secret))
+	mac.Write([]byte(request.Header.Get("X-Webhook-Timestamp") + "." + string(receiver.bodies[0])))
+	var expected string = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if request.Header.Get("X-Webhook-Signature") != expected {
+		t.Fatalf("signature %q, expected %q", request.Header.Get("X-Webhook-Signature"), expected)
+	}
+	if request.Header.Get("X-Webhook-Id") != subscription.Id || request.Header.Get("X-Webhook-Event") != WebhookOutbid {
+		t.Fatalf("wrong headers %v", request.Header)
+	}
+	if signWebhook("other secret", request.Header.Get("X-Webhook-Timestamp"), receiver.bodies[0]) == expected {
+		t.Fatalf("signature does not depend on the secret")
+	}
+	waitFor(t, 5*time.Second, "the outbox to be empty", func() bool { return len(webhooks.outbox()) == 0 })
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	var tests = []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{9, 1280 * time.Second},
+		{10, 2560 * time.Second},
+		{11, time.Hour},
+		{100, time.Hour},
+	}
+	for _, test := range tests {
+		if delay := webhookRetryDelay(test.attempts); delay != test.delay {
+			t.Errorf("after %d attempts: delay %s, expected %s", test.attempts, delay, test.delay)
+		}
+	}
+}
+
+// A delivery that fails is retried later, with the same delivery id, until the receiver takes it
+func TestWebhookRetry(t *testing.T) {
+	var receiver *webhookReceiver = newWebhookReceiver(http.StatusInternalServerError, http.StatusOK)
+	defer receiver.server.Close()
+	webhooks, subscription := newTestWebhooks(t, NewMemoryStorage(), receiver)
+	var started time.Time = time.Now()
+	webhooks.Start()
+	defer webhooks.Stop()
+	waitFor(t, 5*time.Second, "the first attempt", func() bool {
+		var outbox []WebhookDelivery = webhooks.outbox()
+		return len(outbox) == 1 && outbox[0].Attempts == 1
+	})
+
+	var delivery WebhookDelivery = webhooks.outbox()[0]
+	if delay := delivery.NextAttempt.Sub(started); delay < webhookRetryBase || delay > webhookRetryBase+5*time.Second {
+		t.Fatalf("next attempt in %s, expected %s", delay, webhookRetryBase)
+	}
+	if delivery.LastError == "" || receiver.received() != 1 {
+		t.Fatalf("failed attempt not recorded: %+v", delivery)
+	}
+
+	// The next attempt is made now rather than after the delay
+	webhooks.mutex.Lock()
+	webhooks.state.Outbox[0].NextAttempt = time.Now()
+	webhooks.mutex.Unlock()
+	webhooks.signal()
+	waitFor(t, 5*time.Second, "the second attempt", func() bool { return len(webhooks.outbox()) == 0 })
+
+	if receiver.received() != 2 || receiver.requests[0].Header.Get("X-Webhook-Delivery") != receiver.requests[1].Header.Get("X-Webhook-Delivery") {
+		t.Fatalf("got %d attempts of different deliveries", receiver.received())
+	}
+	deliveries, _ := webhooks.GetDeliveries(subscription.Id)
+	if len(deliveries.Log) != 2 || deliveries.Log[0].Outcome != DeliveryRetrying || deliveries.Log[0].StatusCode != http.StatusInternalServerError ||
+		deliveries.Log[1].Outcome != DeliverySucceeded || deliveries.Log[1].Attempt != 2 {
+		t.Fatalf("wrong delivery log %+v", deliveries.Log)
+	}
+}
+
+// Deliveries that were not sent are stored, and sent by the webhooks loaded from the same storage
+func TestWebhookOutboxStored(t *testing.T) {
+	var receiver *webhookReceiver = newWebhookReceiver(http.StatusOK)
+	defer receiver.server.Close()
+	var store Storage = NewMemoryStorage()
+	stopped, subscription := newTestWebhooks(t, store, receiver)
+	stopped.Stop()
+
+	restarted, err := NewWebhooks(newTestChain(t), store, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outbox := restarted.outbox(); len(outbox) != 1 || outbox[0].WebhookId != subscription.Id {
+		t.Fatalf("outbox not stored: %+v", outbox)
+	}
+	restarted.Start()
+	defer restarted.Stop()
+	waitFor(t, 5*time.Second, "the stored delivery", func() bool { return receiver.received() == 1 })
+	waitFor(t, 5*time.Second, "the outbox to be empty", func() bool { return len(restarted.outbox()) == 0 })
+
+	state, err := store.LoadWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Outbox) != 0 || len(state.Log) != 1 {
+		t.Fatalf("stored outbox %+v, log %+v", state.Outbox, state.Log)
+	}
+}
+
+func TestPrivateWebhookAddresses(t *testing.T) {
+	var tests = []struct {
+		address string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"0.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"100.64.0.1", true},
+		{"169.254.169.254", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"::127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"::ffff:8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, test := range tests {
+		if private := isPrivateAddress(net.ParseIP(test.address)); private != test.private {
+			t.Errorf("%s: private %v, expected %v", test.address, private, test.private)
+		}
+		var request WebhookRequest = WebhookRequest{Url: "http://" + net.JoinHostPort(test.address, "8080") + "/hook", Events: []string{WebhookOutbid}}
+		if err := checkWebhookRequest(&request, false); errors.Is(err, ErrInvalidWebhook) != test.private {
+			t.Errorf("%s: got %v", test.address, err)
+		}
+		if err := checkWebhookRequest(&request, true); err != nil {
+			t.Errorf("%s: refused although private addresses are allowed: %v", test.address, err)
+		}
+	}
+
+	// The client refuses to connect to a private address, whatever the url
+	var receiver *webhookReceiver = newWebhookReceiver(http.StatusOK)
+	defer receiver.server.Close()
+	_, port, _ := net.SplitHostPort(receiver.server.Listener.Addr().String())
+	for _, host := range []string{"127.0.0.1", "[::ffff:127.0.0.1]", "localhost"} {
+		if response, err := newWebhookClient(false).Get("http://" + host + ":" + port + "/hook"); err == nil {
+			response.Body.Close()
+			t.Errorf("%s: connected to a private address", host)
+		}
+	}
+	if receiver.received() != 0 {
+		t.Fatalf("receiver got %d requests", receiver.received())
+	}
+}
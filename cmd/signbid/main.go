@@ -0,0 +1,109 @@
+// signbid signs a bid so that it can be posted to a node. It reads the bid (in json, without
+// public_key and signature) from standard input and writes the signed bid to standard output:
+//
+//	echo '{"sequence": 1, "bidder_name": "YD", "auction_id": 100, "bid_value": "123.45"}' | go run ./cmd/signbid -key my.key
+//
+// The bid is signed for the chain given with -chain-id (see bid.GenesisConfig), unless it has a chain_id.
+//
+// For sealed auctions, give the bid's kind ("commit" or "reveal"), value and salt: the commitment is
+// computed from them and, for a commit bid, the value and salt are removed before signing (keep them
+// for the reveal).
+//
+// With -auction, it signs an auction record (without seller and signature) instead:
+//
+//	echo '{"type": "close", "auction_id": 100}' | go run ./cmd/signbid -key my.key -auction
+//
+// To hide the reserve price of a new auction, give reserve_price and reserve_salt in the create record:
+// they are replaced by the reserve commitment (keep them for the close record)
+//
+// The private key is kept in the given file (as a hex ed25519 seed) and is created on first use
+package main
+
+import (
+	"MiniBlockChain/bid"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	var keyFile *string = flag.String("key", "bidder.key", "file holding the bidder's private key")
+	var auction *bool = flag.Bool("auction", false, "sign an auction record instead of a bid")
+	var chainId *string = flag.String("chain-id", bid.DefaultChainId, "id of the chain the bid is made on")
+	flag.Parse()
+
+	privateKey, err := loadOrCreateKey(*keyFile)
+	if err != nil {
+		log.Fatalf("cannot load key: %s", err)
+	}
+
+	body, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("cannot read input: %s", err)
+	}
+	var encoder *json.Encoder = json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "\t")
+
+	if *auction {
+		var record bid.AuctionRecord
+		if err = json.Unmarshal(body, &record); err != nil {
+			log.Fatalf("cannot parse auction record: %s", err)
+		}
+		if record.Type == bid.AuctionRecordCreate && record.ReserveSalt != "" {
+			var publicKey string = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+			record.ReserveCommitment = bid.ComputeCommitment(record.AuctionId, publicKey, record.ReservePrice, record.ReserveSalt)
+			record.ReservePrice = bid.Money{}
+			record.ReserveSalt = ""
+		}
+		bid.SignAuctionRecord(&record, privateKey)
+		encoder.Encode(record)
+		return
+	}
+
+	var newBid bid.Bid
+	if err = json.Unmarshal(body, &newBid); err != nil {
+		log.Fatalf("cannot parse bid: %s", err)
+	}
+	if newBid.ChainId == "" {
+		newBid.ChainId = *chainId
+	}
+	if newBid.Kind != "" && newBid.Commitment == "" {
+		var publicKey string = hex.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+		newBid.Commitment = bid.ComputeCommitment(newBid.AuctionId, publicKey, newBid.BidValue, newBid.Salt)
+		if newBid.Kind == bid.BidKindCommit {
+			newBid.BidValue = bid.Money{}
+			newBid.Salt = ""
+		}
+	}
+	bid.SignBid(&newBid, privateKey)
+	encoder.Encode(newBid)
+}
+
+// loadOrCreateKey reads the private key seed from keyFile, or generates a new key and saves its seed
+func loadOrCreateKey(keyFile string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("created new key in %s", keyFile)
+		return privateKey, ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(privateKey.Seed())+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s does not hold a %d byte hex seed", keyFile, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}